@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"netwitter/schemas"
+	"netwitter/storage"
+	"sync"
+)
+
+type MemoryCredentialsStorage struct {
+	mu sync.RWMutex
+
+	credentialsByUser map[schemas.UserId]*schemas.Credentials
+}
+
+func NewInMemoryStorage() *MemoryCredentialsStorage {
+	return &MemoryCredentialsStorage{
+		credentialsByUser: map[schemas.UserId]*schemas.Credentials{},
+	}
+}
+
+func (s *MemoryCredentialsStorage) PutCredentials(_ context.Context, credentials schemas.Credentials) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.credentialsByUser[credentials.UserID]; ok {
+		return fmt.Errorf("%w: credentials of %s", storage.ErrCollision, credentials.UserID)
+	}
+	s.credentialsByUser[credentials.UserID] = &credentials
+	return nil
+}
+
+func (s *MemoryCredentialsStorage) GetCredentials(_ context.Context, userId schemas.UserId) (*schemas.Credentials, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	credentials, ok := s.credentialsByUser[userId]
+	if !ok {
+		return nil, fmt.Errorf("%w: credentials of %s", storage.ErrNotFound, userId)
+	}
+	result := *credentials
+	return &result, nil
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"netwitter/schemas"
+	"netwitter/storage"
+	"time"
+)
+
+const (
+	MinPasswordLength = 8
+	// MaxPasswordLength is where bcrypt stops reading passwords
+	MaxPasswordLength = 72
+	passwordHashCost  = bcrypt.DefaultCost
+)
+
+var (
+	AuthError             = errors.New("auth")
+	ErrInvalidCredentials = fmt.Errorf("%w.invalid_credentials", AuthError)
+	ErrInvalidPassword    = fmt.Errorf("%w.invalid_password", AuthError)
+	ErrInvalidToken       = fmt.Errorf("%w.invalid_token", AuthError)
+)
+
+// absentUserHash is compared with passwords of unknown users,
+// so logins take the same time whether the user exists or not
+var absentUserHash, _ = bcrypt.GenerateFromPassword([]byte("absent user password"), passwordHashCost)
+
+// AuthManager keeps credentials and issues access tokens signed with secret
+type AuthManager struct {
+	credentialsStorage storage.CredentialsStorage
+	secret             []byte
+	tokenTTL           time.Duration
+}
+
+func NewAuthManager(credentialsStorage storage.CredentialsStorage, secret []byte, tokenTTL time.Duration) *AuthManager {
+	return &AuthManager{
+		credentialsStorage: credentialsStorage,
+		secret:             secret,
+		tokenTTL:           tokenTTL,
+	}
+}
+
+// Register fails with storage.ErrCollision when the user already has credentials
+func (am *AuthManager) Register(ctx context.Context, userId schemas.UserId, password string) error {
+	if userId == "" {
+		return fmt.Errorf("%w: blank user id", ErrInvalidCredentials)
+	}
+	err := ValidatePassword(password)
+	if err != nil {
+		return err
+	}
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), passwordHashCost)
+	if err != nil {
+		return fmt.Errorf("password hashing failed: %w", err)
+	}
+	return am.credentialsStorage.PutCredentials(ctx, schemas.Credentials{
+		UserID:       userId,
+		PasswordHash: passwordHash,
+		CreatedAt:    time.Now().UTC().Truncate(time.Millisecond),
+	})
+}
+
+func ValidatePassword(password string) error {
+	if len(password) < MinPasswordLength || len(password) > MaxPasswordLength {
+		return fmt.Errorf("%w: password length must be from %d to %d", ErrInvalidPassword, MinPasswordLength, MaxPasswordLength)
+	}
+	return nil
+}
+
+// Login checks the password and issues an access token
+func (am *AuthManager) Login(ctx context.Context, userId schemas.UserId, password string) (string, time.Time, error) {
+	passwordHash := absentUserHash
+	credentials, err := am.credentialsStorage.GetCredentials(ctx, userId)
+	switch {
+	case err == nil:
+		passwordHash = credentials.PasswordHash
+	case !errors.Is(err, storage.ErrNotFound):
+		return "", time.Time{}, err
+	}
+
+	passwordErr := bcrypt.CompareHashAndPassword(passwordHash, []byte(password))
+	if credentials == nil || passwordErr != nil {
+		return "", time.Time{}, ErrInvalidCredentials
+	}
+
+	issuedAt := time.Now().UTC()
+	expiresAt := issuedAt.Add(am.tokenTTL)
+	return signToken(am.secret, userId, issuedAt, expiresAt), expiresAt, nil
+}
+
+// Authenticate returns the user of a valid access token
+func (am *AuthManager) Authenticate(token string) (schemas.UserId, error) {
+	return verifyToken(am.secret, token, time.Now())
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"netwitter/schemas"
+	"netwitter/storage"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPassword = "correct horse battery"
+
+var testSecret = []byte("test auth secret")
+
+func newTestManager(t *testing.T) *AuthManager {
+	t.Helper()
+	am := NewAuthManager(NewInMemoryStorage(), testSecret, time.Hour)
+	err := am.Register(context.Background(), "user", testPassword)
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	return am
+}
+
+func assertInvalidToken(t *testing.T, err error) {
+	t.Helper()
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected invalid token error, got %v", err)
+	}
+}
+
+func TestLoginAuthenticate(t *testing.T) {
+	am := newTestManager(t)
+
+	token, expiresAt, err := am.Login(context.Background(), "user", testPassword)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if until := time.Until(expiresAt); until <= 0 || until > time.Hour {
+		t.Errorf("token must expire in the ttl, expires at %s", expiresAt)
+	}
+	userId, err := am.Authenticate(token)
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if userId != "user" {
+		t.Errorf("expected user, got %q", userId)
+	}
+}
+
+func TestLoginBadCredentials(t *testing.T) {
+	am := newTestManager(t)
+
+	cases := []struct {
+		userId   schemas.UserId
+		password string
+	}{
+		{"user", "wrong password"},
+		{"user", ""},
+		{"absent", testPassword},
+	}
+	for _, c := range cases {
+		_, _, err := am.Login(context.Background(), c.userId, c.password)
+		if !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("login %s with %q: expected invalid credentials, got %v", c.userId, c.password, err)
+		}
+	}
+}
+
+func TestRegisterRejects(t *testing.T) {
+	am := newTestManager(t)
+	ctx := context.Background()
+
+	err := am.Register(ctx, "other", "short")
+	if !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("expected invalid password, got %v", err)
+	}
+	err = am.Register(ctx, "other", strings.Repeat("a", MaxPasswordLength+1))
+	if !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("expected invalid password, got %v", err)
+	}
+	err = am.Register(ctx, "user", testPassword)
+	if !errors.Is(err, storage.ErrCollision) {
+		t.Errorf("expected collision, got %v", err)
+	}
+}
+
+func TestTamperedToken(t *testing.T) {
+	now := time.Now()
+	token := signToken(testSecret, "user", now, now.Add(time.Hour))
+	parts := strings.Split(token, ".")
+
+	// the signature of one user is put on the claims of another
+	otherToken := signToken(testSecret, "admin", now, now.Add(time.Hour))
+	otherClaims := strings.Split(otherToken, ".")[1]
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	signature[0] ^= 1
+
+	tampered := []string{
+		parts[0] + "." + otherClaims + "." + parts[2],
+		parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(signature),
+		parts[0] + "." + parts[1] + ".",
+		parts[0] + "." + parts[1],
+		"",
+		"garbage",
+	}
+	for _, tamperedToken := range tampered {
+		_, err := verifyToken(testSecret, tamperedToken, now)
+		assertInvalidToken(t, err)
+	}
+}
+
+func TestTokenOfOtherSecret(t *testing.T) {
+	now := time.Now()
+	token := signToken([]byte("other auth secret"), "user", now, now.Add(time.Hour))
+
+	_, err := verifyToken(testSecret, token, now)
+	assertInvalidToken(t, err)
+}
+
+func TestTokenOfOtherAlgorithm(t *testing.T) {
+	now := time.Now()
+	parts := strings.Split(signToken(testSecret, "user", now, now.Add(time.Hour)), ".")
+
+	for _, header := range []string{`{"alg":"none","typ":"JWT"}`, `{"alg":"HS512","typ":"JWT"}`, `{"typ":"JWT","alg":"HS256"}`} {
+		encodedHeader := base64.RawURLEncoding.EncodeToString([]byte(header))
+		signingInput := encodedHeader + "." + parts[1]
+		// signed with the right secret, so only the header is wrong
+		token := signingInput + "." + base64.RawURLEncoding.EncodeToString(tokenSignature(testSecret, signingInput))
+		_, err := verifyToken(testSecret, token, now)
+		assertInvalidToken(t, err)
+
+		_, err = verifyToken(testSecret, signingInput+".", now)
+		assertInvalidToken(t, err)
+	}
+}
+
+func TestExpiredToken(t *testing.T) {
+	issuedAt := time.Now().Add(-time.Hour)
+	expiresAt := issuedAt.Add(time.Minute)
+	token := signToken(testSecret, "user", issuedAt, expiresAt)
+
+	_, err := verifyToken(testSecret, token, expiresAt.Add(-time.Second))
+	if err != nil {
+		t.Errorf("token must be valid before expiry: %v", err)
+	}
+	_, err = verifyToken(testSecret, token, expiresAt)
+	assertInvalidToken(t, err)
+
+	am := NewAuthManager(NewInMemoryStorage(), testSecret, time.Hour)
+	_, err = am.Authenticate(token)
+	assertInvalidToken(t, err)
+}
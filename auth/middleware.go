@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"netwitter/schemas"
+	"strings"
+)
+
+// UserIdHeader names the user of internal test traffic when it is allowed
+const UserIdHeader = "System-Design-User-Id"
+
+type contextKey int
+
+const userContextKey contextKey = iota
+
+func WithUser(ctx context.Context, userId schemas.UserId) context.Context {
+	return context.WithValue(ctx, userContextKey, userId)
+}
+
+// UserFromContext is the authenticated user of a request, empty for anonymous ones
+func UserFromContext(ctx context.Context) schemas.UserId {
+	userId, _ := ctx.Value(userContextKey).(schemas.UserId)
+	return userId
+}
+
+// Middleware puts the user of a valid bearer token to request contexts, requests
+// with an invalid one are rejected. Requests without a token go on anonymously,
+// handlers tell what needs a user. With allowUserHeader the UserIdHeader is
+// trusted for requests without a token.
+func Middleware(am *AuthManager, allowUserHeader bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			var userId schemas.UserId
+			if authorization := r.Header.Get("Authorization"); authorization != "" {
+				token := strings.TrimPrefix(authorization, "Bearer ")
+				if token == authorization {
+					rw.Header().Set("WWW-Authenticate", "Bearer")
+					http.Error(rw, "unsupported authorization scheme", http.StatusUnauthorized)
+					return
+				}
+				var err error
+				userId, err = am.Authenticate(token)
+				if err != nil {
+					rw.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+					http.Error(rw, err.Error(), http.StatusUnauthorized)
+					return
+				}
+			} else if allowUserHeader {
+				userId = schemas.UserId(r.Header.Get(UserIdHeader))
+			}
+
+			if userId != "" {
+				r = r.WithContext(WithUser(r.Context(), userId))
+			}
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
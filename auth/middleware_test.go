@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"netwitter/schemas"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveAuthenticated passes the request through the middleware, the user it got is returned with the response
+func serveAuthenticated(am *AuthManager, allowUserHeader bool, header http.Header) (*httptest.ResponseRecorder, schemas.UserId, bool) {
+	var userId schemas.UserId
+	served := false
+	handler := Middleware(am, allowUserHeader)(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		served = true
+		userId = UserFromContext(r.Context())
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/feed", nil)
+	r.Header = header
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, r)
+	return rw, userId, served
+}
+
+func TestMiddleware(t *testing.T) {
+	am := NewAuthManager(NewInMemoryStorage(), testSecret, time.Hour)
+	now := time.Now()
+	valid := signToken(testSecret, "user", now, now.Add(time.Hour))
+	expired := signToken(testSecret, "user", now.Add(-time.Hour), now.Add(-time.Minute))
+
+	tests := []struct {
+		name            string
+		allowUserHeader bool
+		header          http.Header
+		code            int
+		userId          schemas.UserId
+	}{
+		{"ValidToken", false, http.Header{"Authorization": {"Bearer " + valid}}, http.StatusOK, "user"},
+		{"TamperedToken", false, http.Header{"Authorization": {"Bearer " + valid + "x"}}, http.StatusUnauthorized, ""},
+		{"ExpiredToken", false, http.Header{"Authorization": {"Bearer " + expired}}, http.StatusUnauthorized, ""},
+		{"OtherScheme", false, http.Header{"Authorization": {"Basic dXNlcjpwYXNz"}}, http.StatusUnauthorized, ""},
+		{"Anonymous", false, http.Header{}, http.StatusOK, ""},
+		{"HeaderNotAllowed", false, http.Header{UserIdHeader: {"admin"}}, http.StatusOK, ""},
+		{"HeaderAllowed", true, http.Header{UserIdHeader: {"admin"}}, http.StatusOK, "admin"},
+		{"TokenOverHeader", true, http.Header{"Authorization": {"Bearer " + valid}, UserIdHeader: {"admin"}}, http.StatusOK, "user"},
+		{"InvalidTokenWithHeader", true, http.Header{"Authorization": {"Bearer " + expired}, UserIdHeader: {"admin"}}, http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			rw, userId, served := serveAuthenticated(am, tt.allowUserHeader, tt.header)
+			if rw.Code != tt.code {
+				t.Fatalf("expected %d, got %d %s", tt.code, rw.Code, rw.Body.String())
+			}
+			if tt.code == http.StatusUnauthorized {
+				if served {
+					t.Error("rejected request must not be served")
+				}
+				if !strings.HasPrefix(rw.Header().Get("WWW-Authenticate"), "Bearer") {
+					t.Errorf("expected bearer challenge, got %q", rw.Header().Get("WWW-Authenticate"))
+				}
+				return
+			}
+			if userId != tt.userId {
+				t.Errorf("expected user %q, got %q", tt.userId, userId)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"netwitter/schemas"
+	"netwitter/storage"
+)
+
+type CredentialsStorage struct {
+	credentialsCollection *mongo.Collection
+}
+
+func NewStorage(ctx context.Context, mongoUrl, dbName string) *CredentialsStorage {
+	mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoUrl))
+	if err != nil {
+		panic(fmt.Sprintf("connect to mongo failed: %s", err))
+	}
+
+	credentialsCollection := mongoClient.Database(dbName).Collection("credentials")
+	return &CredentialsStorage{credentialsCollection: credentialsCollection}
+}
+
+func (s *CredentialsStorage) PutCredentials(ctx context.Context, credentials schemas.Credentials) error {
+	_, err := s.credentialsCollection.InsertOne(ctx, credentials)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("%w: credentials of %s", storage.ErrCollision, credentials.UserID)
+		}
+		return fmt.Errorf("credentials insertion failed: %s", err.Error())
+	}
+	return nil
+}
+
+func (s *CredentialsStorage) GetCredentials(ctx context.Context, userId schemas.UserId) (*schemas.Credentials, error) {
+	var credentials schemas.Credentials
+	err := s.credentialsCollection.FindOne(ctx, bson.M{"_id": string(userId)}).Decode(&credentials)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("%w: credentials of %s", storage.ErrNotFound, userId)
+		}
+		return nil, fmt.Errorf("failed to extract, cause %s", err.Error())
+	}
+	return &credentials, nil
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"netwitter/schemas"
+	"strings"
+	"time"
+)
+
+// Access tokens are HS256 JWTs, the header is fixed so no other algorithm is ever accepted
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+type tokenClaims struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func signToken(secret []byte, userId schemas.UserId, issuedAt time.Time, expiresAt time.Time) string {
+	rawClaims, _ := json.Marshal(tokenClaims{
+		Subject:   string(userId),
+		IssuedAt:  issuedAt.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	})
+	signingInput := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(rawClaims)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(tokenSignature(secret, signingInput))
+}
+
+func verifyToken(secret []byte, token string, now time.Time) (schemas.UserId, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return "", fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, tokenSignature(secret, parts[0]+"."+parts[1])) {
+		return "", fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+	var claims tokenClaims
+	err = json.Unmarshal(rawClaims, &claims)
+	if err != nil || claims.Subject == "" {
+		return "", fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return "", fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	return schemas.UserId(claims.Subject), nil
+}
+
+func tokenSignature(secret []byte, signingInput string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,108 @@
+package feed
+
+import (
+	"encoding/json"
+	"netwitter/plain"
+	"netwitter/schemas"
+	"time"
+)
+
+// feedCursor keeps the last item taken from every merged source of a feed:
+// Pushed is the page token of the personal feed, Pulled and Reposts are page
+// tokens of posts and reposts of high-fanout authors. An empty token means
+// nothing is taken yet.
+// Last is the position of the last item taken from any source, sources having
+// no token yet on later pages start from it: authors followed or crossing the
+// fanout threshold between pages do not bring back posts already shown.
+// The cursor is signed as a whole like the page tokens it carries.
+// A Newer cursor is a head: it keeps the newest items taken instead, sources
+// without one go on from Since, the moment the head was issued.
+type feedCursor struct {
+	Size   int                       `json:"s,omitempty"`
+	Newer  bool                      `json:"n,omitempty"`
+	Since  int64                     `json:"t,omitempty"`
+	Pushed string                    `json:"p,omitempty"`
+	Pulled map[schemas.UserId]string `json:"a,omitempty"`
+	// Reposts are not merged with Pulled, cursors of the two lists differ
+	Reposts map[schemas.UserId]string `json:"r,omitempty"`
+	Last    string                    `json:"l,omitempty"`
+}
+
+func newHeadCursor(size int) *feedCursor {
+	return &feedCursor{
+		Size:    size,
+		Newer:   true,
+		Since:   plain.TimeSortKey(time.Now()),
+		Pulled:  map[schemas.UserId]string{},
+		Reposts: map[schemas.UserId]string{},
+	}
+}
+
+// take moves the token of the candidate source to the candidate
+func (c *feedCursor) take(candidate feedCandidate) {
+	position := candidate.position(c.Size, c.Newer)
+	switch {
+	case candidate.isRepost:
+		c.Reposts[candidate.source] = position
+	case candidate.entry != nil:
+		c.Pushed = position
+	default:
+		c.Pulled[candidate.source] = position
+	}
+}
+
+// sourceToken is the page token of a source of a head
+func (c *feedCursor) sourceToken(token string) string {
+	if token != "" {
+		return token
+	}
+	return plain.HeadPageAt(plain.TimeFromSortKey(c.Since), c.Size).Token
+}
+
+// entriesToken is the page token of a source of a head ordered by time the posts were
+// added at: ids of such items are older than the items, so the whole Since
+// millisecond is newer
+func (c *feedCursor) entriesToken(token string) string {
+	if token != "" {
+		return token
+	}
+	return plain.HeadPage(c.Since, schemas.PostId{}, c.Size, 0).Token
+}
+
+// lastTaken is the item at Last, nil on the first page
+func (c *feedCursor) lastTaken() (*feedCandidate, error) {
+	if c.Last == "" {
+		return nil, nil
+	}
+	last, err := plain.DecodeCursor(c.Last)
+	if err != nil {
+		return nil, err
+	}
+	return &feedCandidate{postID: last.ID, at: plain.TimeFromSortKey(last.SortKey)}, nil
+}
+
+func (c *feedCursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return plain.SignToken(raw)
+}
+
+func decodeFeedCursor(token string) (*feedCursor, error) {
+	cursor := &feedCursor{}
+	if token != "" {
+		raw, err := plain.VerifyToken(token)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(raw, cursor)
+		if err != nil {
+			return nil, plain.ErrInvalidToken
+		}
+	}
+	if cursor.Pulled == nil {
+		cursor.Pulled = map[schemas.UserId]string{}
+	}
+	if cursor.Reposts == nil {
+		cursor.Reposts = map[schemas.UserId]string{}
+	}
+	return cursor, nil
+}
@@ -0,0 +1,97 @@
+package feed
+
+import (
+	"encoding/base64"
+	"errors"
+	"netwitter/plain"
+	"netwitter/schemas"
+	"reflect"
+	"testing"
+)
+
+const testCursorSecret = "test cursor secret"
+
+func assertInvalidToken(t *testing.T, err error) {
+	t.Helper()
+	if !errors.Is(err, plain.ErrInvalidToken) {
+		t.Errorf("expected invalid token error, got %v", err)
+	}
+}
+
+func newTestCursor() *feedCursor {
+	return &feedCursor{
+		Size:    5,
+		Pushed:  plain.NextPage(1234, schemas.NewPostId(), 5).Token,
+		Pulled:  map[schemas.UserId]string{"author": plain.NextPage(0, schemas.NewPostId(), 5).Token},
+		Reposts: map[schemas.UserId]string{"author": plain.NextPage(1234, schemas.NewPostId(), 5).Token},
+		Last:    plain.NextPage(1234, schemas.NewPostId(), 5).Token,
+	}
+}
+
+func TestFeedCursorEncodeDecode(t *testing.T) {
+	plain.SetTokenSecret([]byte(testCursorSecret))
+	cursor := newTestCursor()
+
+	got, err := decodeFeedCursor(cursor.encode())
+	if err != nil {
+		t.Fatalf("decode cursor: %v", err)
+	}
+	if !reflect.DeepEqual(got, cursor) {
+		t.Errorf("expected %+v, got %+v", cursor, got)
+	}
+
+	empty, err := decodeFeedCursor("")
+	if err != nil {
+		t.Fatalf("decode empty cursor: %v", err)
+	}
+	if empty.Pulled == nil || empty.Reposts == nil || empty.Pushed != "" || empty.Last != "" {
+		t.Errorf("empty token must start every source, got %+v", empty)
+	}
+}
+
+func TestFeedCursorTampered(t *testing.T) {
+	plain.SetTokenSecret([]byte(testCursorSecret))
+	raw, _ := base64.RawURLEncoding.DecodeString(newTestCursor().encode())
+
+	for _, i := range []int{0, len(raw) / 2, len(raw) - 1} {
+		tampered := append([]byte(nil), raw...)
+		tampered[i] ^= 1
+		_, err := decodeFeedCursor(base64.RawURLEncoding.EncodeToString(tampered))
+		assertInvalidToken(t, err)
+	}
+}
+
+func TestFeedCursorMalformed(t *testing.T) {
+	plain.SetTokenSecret([]byte(testCursorSecret))
+	token := newTestCursor().encode()
+
+	malformed := []string{
+		"!!!",
+		"garbage",
+		token[:len(token)/2],
+		// well signed tokens that are not feed cursors
+		plain.SignToken([]byte("not json")),
+		plain.NextPage(1234, schemas.NewPostId(), 5).Token,
+	}
+	for _, token := range malformed {
+		_, err := decodeFeedCursor(token)
+		assertInvalidToken(t, err)
+	}
+}
+
+func TestFeedCursorOfOtherSecret(t *testing.T) {
+	plain.SetTokenSecret([]byte("other cursor secret"))
+	token := newTestCursor().encode()
+
+	plain.SetTokenSecret([]byte(testCursorSecret))
+	_, err := decodeFeedCursor(token)
+	assertInvalidToken(t, err)
+}
+
+func TestFeedCursorLastTaken(t *testing.T) {
+	plain.SetTokenSecret([]byte(testCursorSecret))
+	cursor := &feedCursor{Last: "garbage"}
+
+	_, err := cursor.lastTaken()
+	assertInvalidToken(t, err)
+}
@@ -0,0 +1,112 @@
+package feed
+
+import (
+	"context"
+	"netwitter/schemas"
+	"sync"
+)
+
+const (
+	FeedEventPost   = "post"
+	FeedEventEdit   = "edit"
+	FeedEventDelete = "delete"
+
+	localEventsBufferSize = 64
+)
+
+// FeedEvent tells a feed has changed. Post events only wake readers up, they
+// take new posts from the feed itself, so nothing is lost or shown twice.
+type FeedEvent struct {
+	Type     string
+	PostID   schemas.PostId
+	AuthorID schemas.UserId
+}
+
+// EventBus delivers feed events to the readers listening to a channel at the moment
+type EventBus interface {
+	Publish(ctx context.Context, channel string, event FeedEvent) error
+	Subscribe(ctx context.Context, channels []string) (EventSubscription, error)
+}
+
+type EventSubscription interface {
+	Events() <-chan FeedEvent
+	Close() error
+}
+
+// userEventsChannel gets events of posts pushed to the user feed
+func userEventsChannel(userId schemas.UserId) string {
+	return "ntwt:events:user:" + string(userId)
+}
+
+// authorEventsChannel gets events of posts of a high-fanout author, as they are not pushed
+func authorEventsChannel(authorId schemas.UserId) string {
+	return "ntwt:events:author:" + string(authorId)
+}
+
+// LocalEventBus delivers events within the process, it is meant for single binary runs.
+// Events are dropped for readers which do not keep up.
+type LocalEventBus struct {
+	mu sync.RWMutex
+
+	subscriptions map[string]map[*localSubscription]bool
+}
+
+func NewLocalEventBus() *LocalEventBus {
+	return &LocalEventBus{
+		subscriptions: map[string]map[*localSubscription]bool{},
+	}
+}
+
+func (b *LocalEventBus) Publish(_ context.Context, channel string, event FeedEvent) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for subscription := range b.subscriptions[channel] {
+		select {
+		case subscription.events <- event:
+		default:
+		}
+	}
+	return nil
+}
+
+func (b *LocalEventBus) Subscribe(_ context.Context, channels []string) (EventSubscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subscription := &localSubscription{
+		bus:      b,
+		channels: channels,
+		events:   make(chan FeedEvent, localEventsBufferSize),
+	}
+	for _, channel := range channels {
+		if b.subscriptions[channel] == nil {
+			b.subscriptions[channel] = map[*localSubscription]bool{}
+		}
+		b.subscriptions[channel][subscription] = true
+	}
+	return subscription, nil
+}
+
+type localSubscription struct {
+	bus      *LocalEventBus
+	channels []string
+	events   chan FeedEvent
+}
+
+func (s *localSubscription) Events() <-chan FeedEvent {
+	return s.events
+}
+
+func (s *localSubscription) Close() error {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	for _, channel := range s.channels {
+		delete(s.bus.subscriptions[channel], s)
+		if len(s.bus.subscriptions[channel]) == 0 {
+			delete(s.bus.subscriptions, channel)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package feed_test
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"netwitter/feed"
+	"netwitter/schemas"
+	"os"
+	"testing"
+	"time"
+)
+
+// eventWait bounds waits for events, silence is awaited for much shorter
+const (
+	eventWait   = time.Second
+	silenceWait = 50 * time.Millisecond
+)
+
+func TestLocalEventBus(t *testing.T) {
+	runEventBusSuite(t, func(t *testing.T) feed.EventBus {
+		return feed.NewLocalEventBus()
+	})
+}
+
+func TestRedisEventBus(t *testing.T) {
+	redisURL := os.Getenv("REDIS_TEST_URL")
+	if redisURL == "" {
+		t.Skip("REDIS_TEST_URL is not set")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: redisURL})
+	defer client.Close()
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+	runEventBusSuite(t, func(t *testing.T) feed.EventBus {
+		return feed.NewRedisEventBus(client)
+	})
+}
+
+func runEventBusSuite(t *testing.T, newBus func(t *testing.T) feed.EventBus) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, bus feed.EventBus)
+	}{
+		{"Delivery", testEventsDelivery},
+		{"ClosedSubscription", testClosedSubscription},
+		{"PushedFeedEvents", testPushedFeedEvents},
+		{"PulledAuthorEvents", testPulledAuthorEvents},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newBus(t))
+		})
+	}
+}
+
+// testUser keeps channels of every test apart in a shared redis
+func testUser(name string) schemas.UserId {
+	return schemas.UserId(name + "_" + schemas.NewPostId().Hex())
+}
+
+func subscribeEvents(t *testing.T, bus feed.EventBus, channels ...string) feed.EventSubscription {
+	t.Helper()
+	subscription, err := bus.Subscribe(context.Background(), channels)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = subscription.Close()
+	})
+	return subscription
+}
+
+func publish(t *testing.T, bus feed.EventBus, channel string, event feed.FeedEvent) {
+	t.Helper()
+	err := bus.Publish(context.Background(), channel, event)
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+}
+
+func assertEvent(t *testing.T, subscription feed.EventSubscription, expected feed.FeedEvent) {
+	t.Helper()
+	select {
+	case event, ok := <-subscription.Events():
+		if !ok {
+			t.Fatalf("expected %s event, the subscription is closed", expected.Type)
+		}
+		if event != expected {
+			t.Errorf("expected event %+v, got %+v", expected, event)
+		}
+	case <-time.After(eventWait):
+		t.Fatalf("expected %s event, got none", expected.Type)
+	}
+}
+
+// assertNoEvent takes a closed subscription for a silent one
+func assertNoEvent(t *testing.T, subscription feed.EventSubscription) {
+	t.Helper()
+	select {
+	case event, ok := <-subscription.Events():
+		if ok {
+			t.Errorf("expected no event, got %+v", event)
+		}
+	case <-time.After(silenceWait):
+	}
+}
+
+func testEventsDelivery(t *testing.T, bus feed.EventBus) {
+	channel := "test:" + string(testUser("channel"))
+	other := "test:" + string(testUser("other"))
+	subscription := subscribeEvents(t, bus, channel, other)
+	unrelated := subscribeEvents(t, bus, "test:"+string(testUser("unrelated")))
+
+	author := testUser("author")
+	for _, eventType := range []string{feed.FeedEventPost, feed.FeedEventEdit, feed.FeedEventDelete} {
+		event := feed.FeedEvent{Type: eventType, PostID: schemas.NewPostId(), AuthorID: author}
+		publish(t, bus, channel, event)
+		assertEvent(t, subscription, event)
+	}
+	event := feed.FeedEvent{Type: feed.FeedEventPost, PostID: schemas.NewPostId(), AuthorID: author}
+	publish(t, bus, other, event)
+	assertEvent(t, subscription, event)
+	assertNoEvent(t, unrelated)
+}
+
+func testClosedSubscription(t *testing.T, bus feed.EventBus) {
+	channel := "test:" + string(testUser("channel"))
+	closed := subscribeEvents(t, bus, channel)
+	open := subscribeEvents(t, bus, channel)
+	if err := closed.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	event := feed.FeedEvent{Type: feed.FeedEventPost, PostID: schemas.NewPostId(), AuthorID: testUser("author")}
+	publish(t, bus, channel, event)
+	assertEvent(t, open, event)
+	assertNoEvent(t, closed)
+}
+
+func subscribeFeedEvents(t *testing.T, env *feedEnv, reader schemas.UserId) feed.EventSubscription {
+	t.Helper()
+	subscription, err := env.feedManager.SubscribeFeedEvents(context.Background(), reader)
+	if err != nil {
+		t.Fatalf("subscribe feed events: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = subscription.Close()
+	})
+	return subscription
+}
+
+// editAndDelete edits the post and deletes it, spreading both like the fan-out tasks do
+func (e *feedEnv) editAndDelete(t *testing.T, post *schemas.Post) {
+	t.Helper()
+	ctx := context.Background()
+	_, err := e.posts.EditPost(ctx, post.ID, post.AuthorID, "edited")
+	if err != nil {
+		t.Fatalf("edit post: %v", err)
+	}
+	err = e.feedManager.SpreadPostOverSubscribers(ctx, post.AuthorID, post.ID)
+	if err != nil {
+		t.Fatalf("spread edit: %v", err)
+	}
+	err = e.posts.DeletePost(ctx, post.ID, post.AuthorID)
+	if err != nil {
+		t.Fatalf("delete post: %v", err)
+	}
+	err = e.feedManager.RetractPostFromSubscribers(ctx, post.AuthorID, post.ID)
+	if err != nil {
+		t.Fatalf("retract post: %v", err)
+	}
+}
+
+func assertPostEvents(t *testing.T, subscription feed.EventSubscription, post *schemas.Post) {
+	t.Helper()
+	for _, eventType := range []string{feed.FeedEventPost, feed.FeedEventEdit, feed.FeedEventDelete} {
+		assertEvent(t, subscription, feed.FeedEvent{Type: eventType, PostID: post.ID, AuthorID: post.AuthorID})
+	}
+}
+
+func testPushedFeedEvents(t *testing.T, bus feed.EventBus) {
+	env := newFeedEnvWithEvents(bus)
+	author, reader, stranger := testUser("author"), testUser("reader"), testUser("stranger")
+	env.subscribe(t, author, reader)
+	subscription := subscribeFeedEvents(t, env, reader)
+	strangerSubscription := subscribeFeedEvents(t, env, stranger)
+
+	post := env.post(t, author, time.Now())
+	env.editAndDelete(t, post)
+	assertPostEvents(t, subscription, post)
+	assertNoEvent(t, strangerSubscription)
+}
+
+func testPulledAuthorEvents(t *testing.T, bus feed.EventBus) {
+	env := newFeedEnvWithEvents(bus)
+	author, reader, stranger := testUser("pulled"), testUser("reader"), testUser("stranger")
+	env.subscribe(t, author, reader, testUser("fan1"), testUser("fan2"))
+	start := time.Now()
+	// the first spread marks the author pulled, readers subscribe to the author channel since
+	env.post(t, author, start)
+
+	subscription := subscribeFeedEvents(t, env, reader)
+	strangerSubscription := subscribeFeedEvents(t, env, stranger)
+	listings := env.users.listings
+	post := env.post(t, author, start.Add(time.Millisecond))
+	env.editAndDelete(t, post)
+	if env.users.listings != listings {
+		t.Errorf("events of a pulled author must go to its channel, got %d subscribers listings", env.users.listings-listings)
+	}
+	assertPostEvents(t, subscription, post)
+	assertNoEvent(t, strangerSubscription)
+}
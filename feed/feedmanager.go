@@ -2,49 +2,197 @@ package feed
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
+	"netwitter/plain"
 	"netwitter/schemas"
 	"netwitter/storage"
+	"time"
 )
 
+// FeedManager pushes posts and reposts to personal feeds of subscribers, except
+// ones of authors with more than fanoutThreshold subscribers: those are pulled from
+// posts storage when feeds are read. Authors stay pulled once they got over the
+// threshold, as posts made meanwhile were pushed to no feed. Zero fanoutThreshold
+// makes no more authors pulled.
+// Feed changes are told to the readers listening to events: pushed ones to
+// every subscriber, pulled ones once to the author channel.
 type FeedManager struct {
-	postStorage storage.Storage
-	userStorage storage.UsersStorage
-	feedStorage storage.FeedStorage
+	postStorage     storage.Storage
+	userStorage     storage.UsersStorage
+	feedStorage     storage.FeedStorage
+	events          EventBus
+	fanoutThreshold int
 }
 
-func NewFeedManager(postStorage storage.Storage, userStorage storage.UsersStorage, feedStorage storage.FeedStorage) *FeedManager {
+func NewFeedManager(postStorage storage.Storage, userStorage storage.UsersStorage, feedStorage storage.FeedStorage, events EventBus, fanoutThreshold int) *FeedManager {
 	return &FeedManager{
-		postStorage: postStorage,
-		userStorage: userStorage,
-		feedStorage: feedStorage,
+		postStorage:     postStorage,
+		userStorage:     userStorage,
+		feedStorage:     feedStorage,
+		events:          events,
+		fanoutThreshold: fanoutThreshold,
 	}
 }
 
+func (fm *FeedManager) isHighFanout(subscribersCount int) bool {
+	return fm.fanoutThreshold > 0 && subscribersCount > fm.fanoutThreshold
+}
+
+// isPulledAuthor tells whether posts of the author are pulled on feed reads,
+// marking the author pulled when they got over the threshold. Subscribers are
+// only counted here, so lists of high-fanout authors are never read.
+func (fm *FeedManager) isPulledAuthor(ctx context.Context, authorID schemas.UserId) (bool, error) {
+	pulled, err := fm.userStorage.IsPulledAuthor(ctx, authorID)
+	if err != nil || pulled || fm.fanoutThreshold <= 0 {
+		return pulled, err
+	}
+	subscribersCount, err := fm.userStorage.CountUserSubscribers(ctx, authorID)
+	if err != nil {
+		return false, err
+	}
+	if !fm.isHighFanout(subscribersCount) {
+		return false, nil
+	}
+	return true, fm.userStorage.MarkPulledAuthor(ctx, authorID)
+}
+
 func (fm *FeedManager) SpreadPostOverSubscribers(ctx context.Context, userID schemas.UserId, postID schemas.PostId) error {
+	pulled, err := fm.isPulledAuthor(ctx, userID)
+	if err != nil {
+		return err
+	}
+	post, err := fm.postStorage.GetPost(ctx, postID)
+	if err != nil {
+		return err
+	}
+	// edits are spread again, their posts are already in feeds
+	event := FeedEvent{Type: FeedEventPost, PostID: postID, AuthorID: userID}
+	if post.Version > 0 {
+		event.Type = FeedEventEdit
+	}
+
+	if pulled {
+		fm.publish(ctx, authorEventsChannel(userID), event)
+		return nil
+	}
+
 	subscribers, err := fm.userStorage.GetUserSubscribers(ctx, userID)
 	if err != nil {
 		return err
 	}
+	for _, subscriber := range subscribers {
+		err = fm.feedStorage.PutPostToFeed(ctx, subscriber, *post)
+		if err != nil {
+			return err
+		}
+		fm.publish(ctx, userEventsChannel(subscriber), event)
+	}
+	return nil
+}
 
+// SpreadRepostOverSubscribers puts the original post to feeds of the reposter subscribers.
+// Feed items are unique per post, so a post already present in a feed is only
+// annotated with the reposter and lifted to the repost time.
+func (fm *FeedManager) SpreadRepostOverSubscribers(ctx context.Context, reposterID schemas.UserId, postID schemas.PostId) error {
+	pulled, err := fm.isPulledAuthor(ctx, reposterID)
+	if err != nil {
+		return err
+	}
 	post, err := fm.postStorage.GetPost(ctx, postID)
 	if err != nil {
 		return err
 	}
 
+	event := FeedEvent{Type: FeedEventPost, PostID: postID, AuthorID: post.AuthorID}
+	if pulled {
+		fm.publish(ctx, authorEventsChannel(reposterID), event)
+		return nil
+	}
+
+	subscribers, err := fm.userStorage.GetUserSubscribers(ctx, reposterID)
+	if err != nil {
+		return err
+	}
 	for _, subscriber := range subscribers {
-		err = fm.feedStorage.PutPostToFeed(ctx, subscriber, *post)
+		if subscriber == post.AuthorID {
+			continue
+		}
+		err = fm.feedStorage.PutRepostToFeed(ctx, subscriber, *post, reposterID)
 		if err != nil {
 			return err
 		}
+		fm.publish(ctx, userEventsChannel(subscriber), event)
+	}
+	return nil
+}
+
+// RetractPostFromSubscribers is the reverse of SpreadPostOverSubscribers.
+// The post is already gone from the posts storage, so it is removed from
+// every feed it was spread to, not only from the current subscribers ones.
+// Deletion events go to the current subscribers only.
+func (fm *FeedManager) RetractPostFromSubscribers(ctx context.Context, userID schemas.UserId, postID schemas.PostId) error {
+	err := fm.feedStorage.RemovePostFromFeeds(ctx, postID)
+	if err != nil {
+		return err
+	}
+
+	pulled, err := fm.isPulledAuthor(ctx, userID)
+	if err != nil {
+		return err
+	}
+	event := FeedEvent{Type: FeedEventDelete, PostID: postID, AuthorID: userID}
+	if pulled {
+		fm.publish(ctx, authorEventsChannel(userID), event)
+		return nil
+	}
+	subscribers, err := fm.userStorage.GetUserSubscribers(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, subscriber := range subscribers {
+		fm.publish(ctx, userEventsChannel(subscriber), event)
 	}
 	return nil
 }
 
+// publish does not fail tasks, as feeds are already written and readers catch up on reconnect
+func (fm *FeedManager) publish(ctx context.Context, channel string, event FeedEvent) {
+	err := fm.events.Publish(ctx, channel, event)
+	if err != nil {
+		log.Printf("Failed to publish feed event: %s", err)
+	}
+}
+
+// SubscribeFeedEvents listens to changes of the user feed, including posts of
+// pulled authors followed at the moment
+func (fm *FeedManager) SubscribeFeedEvents(ctx context.Context, userId schemas.UserId) (EventSubscription, error) {
+	pulledAuthors, err := fm.getPulledAuthors(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	channels := []string{userEventsChannel(userId)}
+	for _, author := range pulledAuthors {
+		channels = append(channels, authorEventsChannel(author))
+	}
+	return fm.events.Subscribe(ctx, channels)
+}
+
 func (fm *FeedManager) CollectPostsToPersonalFeed(ctx context.Context, subscriber schemas.UserId, from schemas.UserId) error {
+	pulled, err := fm.isPulledAuthor(ctx, from)
+	if err != nil {
+		return err
+	}
+	if pulled {
+		return nil
+	}
+
 	postsIterator, err := fm.postStorage.GetAllPostsFromUser(ctx, from)
 	if err != nil {
 		return err
 	}
+	defer postsIterator.Close(ctx)
 
 	for p := postsIterator.GetNextPost(ctx); p != nil; p = postsIterator.GetNextPost(ctx) {
 		err = fm.feedStorage.PutPostToFeed(ctx, subscriber, *p)
@@ -52,5 +200,428 @@ func (fm *FeedManager) CollectPostsToPersonalFeed(ctx context.Context, subscribe
 			return err
 		}
 	}
+	return postsIterator.Err()
+}
+
+// RemovePostsFromPersonalFeed is the reverse of CollectPostsToPersonalFeed.
+// Posts the removed user reposted go back to their feed positions when the
+// subscriber still gets them from their authors.
+func (fm *FeedManager) RemovePostsFromPersonalFeed(ctx context.Context, subscriber schemas.UserId, from schemas.UserId) error {
+	reposts, err := fm.feedStorage.RemoveAuthorFromFeed(ctx, subscriber, from)
+	if err != nil {
+		return err
+	}
+
+	for _, repost := range reposts {
+		subscribed, err := fm.userStorage.IsSubscribed(ctx, subscriber, repost.AuthorID)
+		if err != nil {
+			return err
+		}
+		if !subscribed {
+			continue
+		}
+		pulled, err := fm.isPulledAuthor(ctx, repost.AuthorID)
+		if err != nil {
+			return err
+		}
+		if pulled {
+			continue
+		}
+
+		post, err := fm.postStorage.GetPost(ctx, repost.PostID)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+			return err
+		}
+		err = fm.feedStorage.PutPostToFeed(ctx, subscriber, *post)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// feedCandidate is an item of one of the merged feed sources, entry is set for
+// items of the personal feed and reposts of pulled authors, post for pulled posts
+type feedCandidate struct {
+	source   schemas.UserId
+	postID   schemas.PostId
+	at       time.Time
+	entry    *schemas.FeedEntry
+	post     *schemas.Post
+	isRepost bool
+}
+
+func (c feedCandidate) isNewerThan(other feedCandidate) bool {
+	if !c.at.Equal(other.at) {
+		return c.at.After(other.at)
+	}
+	return c.postID.Hex() > other.postID.Hex()
+}
+
+// position is the cursor of the candidate in its source
+func (c feedCandidate) position(size int, newer bool) string {
+	cursor := plain.Cursor{ID: c.postID, Size: size, Newer: newer}
+	if c.entry != nil {
+		cursor.SortKey = plain.TimeSortKey(c.at)
+	}
+	return cursor.Encode()
+}
+
+// GetUserFeed merges the personal feed with posts and reposts of followed
+// high-fanout authors, newest first. Posts are read from posts storage, so feed posts
+// are the same as the ones returned by id. Posts deleted before their
+// retraction task ran are skipped, a post present in several sources
+// is shown once per page, and posts pushed before their author became
+// pulled are shown from the pulled source only.
+// The first page comes with a head page data to poll for newer posts,
+// pages of newer posts come with the next head only.
+func (fm *FeedManager) GetUserFeed(ctx context.Context, userId schemas.UserId, page plain.GetUserPostsPageData) (_ []*schemas.Post, nextPage *plain.GetUserPostsPageData, head *plain.GetUserPostsPageData, _ error) {
+	size := page.Size
+	if size < 0 {
+		return nil, nil, nil, fmt.Errorf("page size must not be negative: %d", size)
+	}
+	cursor, err := decodeFeedCursor(page.Token)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if size == 0 {
+		size = cursor.Size
+	}
+	if size == 0 {
+		size = plain.DefaultPageSize
+	}
+	cursor.Size = size
+	if cursor.Newer {
+		feedPosts, head, err := fm.getNewerUserFeed(ctx, userId, cursor)
+		return feedPosts, nil, head, err
+	}
+
+	var headCursor *feedCursor
+	if page.Token == "" {
+		headCursor = newHeadCursor(size)
+	}
+
+	last, err := cursor.lastTaken()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// every source gives up to size items, so the merged page is always complete
+	pushedToken := cursor.Pushed
+	if pushedToken == "" && last != nil {
+		pushedToken = plain.NextPage(plain.TimeSortKey(last.at), last.postID, size).Token
+	}
+	feedEntries, nextPushed, err := fm.feedStorage.GetUserFeed(ctx, userId, plain.GetUserPostsPageData{Token: pushedToken, Size: size})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	hasMore := nextPushed != nil
+	pushed := make([]feedCandidate, 0, len(feedEntries))
+	for _, entry := range feedEntries {
+		pushed = append(pushed, feedCandidate{postID: entry.PostID, at: entry.AddedAt, entry: entry})
+	}
+	if headCursor != nil && len(pushed) > 0 {
+		headCursor.take(pushed[0])
+	}
+	sources := [][]feedCandidate{pushed}
+
+	pulledAuthors, err := fm.getPulledAuthors(ctx, userId)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, author := range pulledAuthors {
+		pulled, hasMorePulled, err := fm.getPulledPage(ctx, author, cursor, last)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		hasMore = hasMore || hasMorePulled
+		if headCursor != nil && len(pulled) > 0 {
+			headCursor.take(pulled[0])
+		}
+		sources = append(sources, pulled)
+
+		reposts, hasMoreReposts, err := fm.getRepostsPage(ctx, author, cursor, last)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		hasMore = hasMore || hasMoreReposts
+		if headCursor != nil && len(reposts) > 0 {
+			headCursor.take(reposts[0])
+		}
+		sources = append(sources, reposts)
+	}
+
+	candidates, isTruncated := mergeCandidates(sources, size, feedCandidate.isNewerThan)
+	hasMore = hasMore || isTruncated
+	for _, candidate := range candidates {
+		cursor.take(candidate)
+	}
+	if len(candidates) > 0 {
+		lastCandidate := candidates[len(candidates)-1]
+		cursor.Last = plain.NextPage(plain.TimeSortKey(lastCandidate.at), lastCandidate.postID, size).Token
+	}
+	feedPosts, err := fm.hydrateFeed(ctx, userId, candidates, pulledAuthors)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if hasMore {
+		nextPage = &plain.GetUserPostsPageData{Token: cursor.encode(), Size: size}
+	}
+	if headCursor != nil {
+		head = &plain.GetUserPostsPageData{Token: headCursor.encode(), Size: size}
+	}
+	return feedPosts, nextPage, head, nil
+}
+
+// getPulledPage reads the next posts of a pulled author. A source that is new on a
+// later page starts from the last taken item: posts storage is ordered by id only,
+// so it is read from the second after that item, and posts not older than the item
+// are passed as they were shown or are left to the head
+func (fm *FeedManager) getPulledPage(ctx context.Context, author schemas.UserId, cursor *feedCursor, last *feedCandidate) (_ []feedCandidate, hasMore bool, _ error) {
+	token := cursor.Pulled[author]
+	isNewSource := token == "" && last != nil
+	if isNewSource {
+		token = plain.NextPage(0, schemas.FirstIDAt(last.at.Add(time.Second)), cursor.Size).Token
+	}
+
+	for {
+		posts, nextPulled, err := fm.postStorage.GetUserPosts(ctx, author, plain.GetUserPostsPageData{Token: token, Size: cursor.Size})
+		if err != nil {
+			return nil, false, err
+		}
+		pulled := make([]feedCandidate, 0, len(posts))
+		for _, post := range posts {
+			pulled = append(pulled, feedCandidate{source: author, postID: post.ID, at: post.CreatedAt, post: post})
+		}
+		if !isNewSource {
+			return pulled, nextPulled != nil, nil
+		}
+
+		passed := 0
+		for passed < len(pulled) && !last.isNewerThan(pulled[passed]) {
+			passed++
+		}
+		if passed == 0 {
+			return pulled, nextPulled != nil, nil
+		}
+		// the page is read again, so the source is not short of items
+		token = pulled[passed-1].position(cursor.Size, false)
+		cursor.Pulled[author] = token
+		if nextPulled == nil && passed == len(pulled) {
+			return nil, false, nil
+		}
+	}
+}
+
+// getRepostsPage reads the next reposts of a pulled author. Reposts are ordered like
+// the personal feed, so a source that is new on a later page starts right after
+// the last taken item.
+func (fm *FeedManager) getRepostsPage(ctx context.Context, reposter schemas.UserId, cursor *feedCursor, last *feedCandidate) (_ []feedCandidate, hasMore bool, _ error) {
+	token := cursor.Reposts[reposter]
+	if token == "" && last != nil {
+		token = plain.NextPage(plain.TimeSortKey(last.at), last.postID, cursor.Size).Token
+	}
+	entries, nextReposts, err := fm.postStorage.GetUserReposts(ctx, reposter, plain.GetUserPostsPageData{Token: token, Size: cursor.Size})
+	if err != nil {
+		return nil, false, err
+	}
+	return repostCandidates(reposter, entries), nextReposts != nil, nil
+}
+
+func repostCandidates(reposter schemas.UserId, entries []*schemas.FeedEntry) []feedCandidate {
+	reposts := make([]feedCandidate, 0, len(entries))
+	for _, entry := range entries {
+		reposts = append(reposts, feedCandidate{source: reposter, postID: entry.PostID, at: entry.AddedAt, entry: entry, isRepost: true})
+	}
+	return reposts
+}
+
+// mergeCandidates takes up to size candidates from the sources in the first order.
+// Every source is already in that order and is taken as a prefix, so a cursor
+// set to the last candidate taken from a source never skips any of its items.
+func mergeCandidates(sources [][]feedCandidate, size int, first func(c feedCandidate, other feedCandidate) bool) (merged []feedCandidate, isTruncated bool) {
+	heads := make([]int, len(sources))
+	for len(merged) < size {
+		next := -1
+		for i, source := range sources {
+			if heads[i] < len(source) && (next == -1 || first(source[heads[i]], sources[next][heads[next]])) {
+				next = i
+			}
+		}
+		if next == -1 {
+			return merged, false
+		}
+		merged = append(merged, sources[next][heads[next]])
+		heads[next]++
+	}
+	for i, source := range sources {
+		if heads[i] < len(source) {
+			return merged, true
+		}
+	}
+	return merged, false
+}
+
+// getNewerUserFeed takes the oldest posts newer than the head cursor from every
+// source and merges them, so no newer post is skipped between polls
+func (fm *FeedManager) getNewerUserFeed(ctx context.Context, userId schemas.UserId, cursor *feedCursor) ([]*schemas.Post, *plain.GetUserPostsPageData, error) {
+	size := cursor.Size
+	feedEntries, pushedHead, err := fm.feedStorage.GetUserFeed(ctx, userId, plain.GetUserPostsPageData{Token: cursor.entriesToken(cursor.Pushed), Size: size})
+	if err != nil {
+		return nil, nil, err
+	}
+	pending := pushedHead.Pending
+	// sources come newest first and are merged oldest first
+	pushed := make([]feedCandidate, len(feedEntries))
+	for i, entry := range feedEntries {
+		pushed[len(feedEntries)-1-i] = feedCandidate{postID: entry.PostID, at: entry.AddedAt, entry: entry}
+	}
+	sources := [][]feedCandidate{pushed}
+	total := len(pushed)
+
+	pulledAuthors, err := fm.getPulledAuthors(ctx, userId)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, author := range pulledAuthors {
+		posts, pulledHead, err := fm.postStorage.GetUserPosts(ctx, author, plain.GetUserPostsPageData{Token: cursor.sourceToken(cursor.Pulled[author]), Size: size})
+		if err != nil {
+			return nil, nil, err
+		}
+		pending += pulledHead.Pending
+		pulled := make([]feedCandidate, len(posts))
+		for i, post := range posts {
+			pulled[len(posts)-1-i] = feedCandidate{source: author, postID: post.ID, at: post.CreatedAt, post: post}
+		}
+		sources = append(sources, pulled)
+		total += len(pulled)
+
+		entries, repostsHead, err := fm.postStorage.GetUserReposts(ctx, author, plain.GetUserPostsPageData{Token: cursor.entriesToken(cursor.Reposts[author]), Size: size})
+		if err != nil {
+			return nil, nil, err
+		}
+		pending += repostsHead.Pending
+		reposts := repostCandidates(author, entries)
+		for i, j := 0, len(reposts)-1; i < j; i, j = i+1, j-1 {
+			reposts[i], reposts[j] = reposts[j], reposts[i]
+		}
+		sources = append(sources, reposts)
+		total += len(reposts)
+	}
+
+	// the oldest candidates are taken, the rest are left for the next poll
+	candidates, _ := mergeCandidates(sources, size, func(c feedCandidate, other feedCandidate) bool {
+		return other.isNewerThan(c)
+	})
+	pending += total - len(candidates)
+
+	for i := range candidates {
+		cursor.take(candidates[i])
+	}
+	for i, j := 0, len(candidates)-1; i < j; i, j = i+1, j-1 {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	}
+	feedPosts, err := fm.hydrateFeed(ctx, userId, candidates, pulledAuthors)
+	if err != nil {
+		return nil, nil, err
+	}
+	return feedPosts, &plain.GetUserPostsPageData{Token: cursor.encode(), Size: size, Pending: pending}, nil
+}
+
+// hydrateFeed reads pushed posts from posts storage, candidates go newest first.
+// Posts and reposts of users blocked or muted by the reader are left out, so pages may be short,
+// but a hidden user's repost of a post the reader gets from its author stays as that post.
+// Posts and reposts pushed before their author became pulled are left out too, as the pulled
+// sources have them, unless the reader hides the reposter. Reposts of hidden or
+// the reader's own posts are not taken from pulled sources.
+func (fm *FeedManager) hydrateFeed(ctx context.Context, userId schemas.UserId, candidates []feedCandidate, pulledAuthors []schemas.UserId) ([]*schemas.Post, error) {
+	hidden, err := fm.GetHiddenAuthors(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	pulled := make(map[schemas.UserId]bool, len(pulledAuthors))
+	for _, author := range pulledAuthors {
+		pulled[author] = true
+	}
+
+	subscribed := map[schemas.UserId]bool{}
+
+	feedPosts := make([]*schemas.Post, 0, len(candidates))
+	seen := map[schemas.PostId]bool{}
+	for _, candidate := range candidates {
+		if candidate.isRepost && (hidden[candidate.source] || candidate.entry.AuthorID == userId) {
+			continue
+		}
+		if !candidate.isRepost && candidate.entry != nil {
+			reposter := candidate.entry.RepostedBy
+			if reposter == "" && pulled[candidate.entry.AuthorID] || pulled[reposter] && !hidden[reposter] {
+				continue
+			}
+		}
+		if seen[candidate.postID] {
+			continue
+		}
+
+		post := candidate.post
+		if candidate.entry != nil {
+			post, err = fm.postStorage.GetPost(ctx, candidate.postID)
+			if err != nil {
+				if errors.Is(err, storage.ErrNotFound) {
+					continue
+				}
+				return nil, err
+			}
+			post.RepostedBy = candidate.entry.RepostedBy
+		}
+		if hidden[post.AuthorID] {
+			continue
+		}
+		if hidden[post.RepostedBy] {
+			if pulled[post.AuthorID] {
+				continue
+			}
+			isSubscribed, ok := subscribed[post.AuthorID]
+			if !ok {
+				isSubscribed, err = fm.userStorage.IsSubscribed(ctx, userId, post.AuthorID)
+				if err != nil {
+					return nil, err
+				}
+				subscribed[post.AuthorID] = isSubscribed
+			}
+			if !isSubscribed {
+				continue
+			}
+			post.RepostedBy = ""
+		}
+		seen[candidate.postID] = true
+		feedPosts = append(feedPosts, post)
+	}
+	return feedPosts, nil
+}
+
+// GetHiddenAuthors returns users whose posts the reader does not see in the feed
+func (fm *FeedManager) GetHiddenAuthors(ctx context.Context, userId schemas.UserId) (map[schemas.UserId]bool, error) {
+	blocked, err := fm.userStorage.GetBlockedUsers(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	muted, err := fm.userStorage.GetMutedUsers(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	hidden := make(map[schemas.UserId]bool, len(blocked)+len(muted))
+	for _, author := range append(blocked, muted...) {
+		hidden[author] = true
+	}
+	return hidden, nil
+}
+
+// getPulledAuthors returns followed authors whose posts are not pushed to feeds
+func (fm *FeedManager) getPulledAuthors(ctx context.Context, userId schemas.UserId) ([]schemas.UserId, error) {
+	return fm.userStorage.GetPulledSubscriptions(ctx, userId)
+}
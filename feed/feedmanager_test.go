@@ -0,0 +1,511 @@
+package feed_test
+
+import (
+	"context"
+	"netwitter/feed"
+	"netwitter/plain"
+	"netwitter/schemas"
+	"netwitter/storage/inmemory"
+	"netwitter/users"
+	"netwitter/workers"
+	"sort"
+	"testing"
+	"time"
+)
+
+// fanoutThreshold makes authors with three subscribers pulled
+const fanoutThreshold = 2
+
+// clockedStorage gives posts the creation times set by the test, so posts may tie on them
+type clockedStorage struct {
+	*inmemory.MemoryStorage
+	createdAt map[schemas.PostId]time.Time
+}
+
+func (s *clockedStorage) clocked(post *schemas.Post) *schemas.Post {
+	result := *post
+	result.CreatedAt = s.createdAt[post.ID]
+	return &result
+}
+
+func (s *clockedStorage) GetPost(ctx context.Context, postId schemas.PostId) (*schemas.Post, error) {
+	post, err := s.MemoryStorage.GetPost(ctx, postId)
+	if err != nil {
+		return nil, err
+	}
+	return s.clocked(post), nil
+}
+
+func (s *clockedStorage) GetUserPosts(ctx context.Context, authorID schemas.UserId, pageData plain.GetUserPostsPageData) ([]*schemas.Post, *plain.GetUserPostsPageData, error) {
+	posts, next, err := s.MemoryStorage.GetUserPosts(ctx, authorID, pageData)
+	for i := range posts {
+		posts[i] = s.clocked(posts[i])
+	}
+	return posts, next, err
+}
+
+// countedUsers counts reads of subscribers made by the feed manager
+type countedUsers struct {
+	*users.MemoryUsersStorage
+	counts   int
+	listings int
+}
+
+func (s *countedUsers) CountUserSubscribers(ctx context.Context, userId schemas.UserId) (int, error) {
+	s.counts++
+	return s.MemoryUsersStorage.CountUserSubscribers(ctx, userId)
+}
+
+func (s *countedUsers) GetUserSubscribers(ctx context.Context, userId schemas.UserId) ([]schemas.UserId, error) {
+	s.listings++
+	return s.MemoryUsersStorage.GetUserSubscribers(ctx, userId)
+}
+
+type feedEnv struct {
+	posts       *clockedStorage
+	users       *countedUsers
+	feedManager *feed.FeedManager
+	// expected are posts in feed order, newest first
+	expected []*schemas.Post
+}
+
+func newFeedEnv() *feedEnv {
+	return newFeedEnvWithEvents(feed.NewLocalEventBus())
+}
+
+func newFeedEnvWithEvents(events feed.EventBus) *feedEnv {
+	posts := &clockedStorage{
+		MemoryStorage: inmemory.NewInMemoryStorage(workers.NewLocalScheduler()),
+		createdAt:     map[schemas.PostId]time.Time{},
+	}
+	usersStorage := &countedUsers{MemoryUsersStorage: users.NewInMemoryStorage()}
+	return &feedEnv{
+		posts:       posts,
+		users:       usersStorage,
+		feedManager: feed.NewFeedManager(posts, usersStorage, feed.NewInMemoryStorage(), events, fanoutThreshold),
+	}
+}
+
+func (e *feedEnv) subscribe(t *testing.T, author schemas.UserId, subscribers ...schemas.UserId) {
+	t.Helper()
+	for _, subscriber := range subscribers {
+		err := e.users.MakeSubscription(context.Background(), subscriber, author)
+		if err != nil {
+			t.Fatalf("subscribe %s to %s: %v", subscriber, author, err)
+		}
+	}
+}
+
+// post creates a post at the time and spreads it like the fan-out task does
+func (e *feedEnv) post(t *testing.T, author schemas.UserId, at time.Time) *schemas.Post {
+	t.Helper()
+	ctx := context.Background()
+	post, err := e.posts.PutPost(ctx, author, "hello", plain.PostOptions{})
+	if err != nil {
+		t.Fatalf("put post: %v", err)
+	}
+	e.posts.createdAt[post.ID] = at
+	err = e.feedManager.SpreadPostOverSubscribers(ctx, author, post.ID)
+	if err != nil {
+		t.Fatalf("spread post: %v", err)
+	}
+
+	post = e.posts.clocked(post)
+	e.expected = append(e.expected, post)
+	sort.Slice(e.expected, func(i, j int) bool {
+		if !e.expected[i].CreatedAt.Equal(e.expected[j].CreatedAt) {
+			return e.expected[i].CreatedAt.After(e.expected[j].CreatedAt)
+		}
+		return e.expected[i].ID.Hex() > e.expected[j].ID.Hex()
+	})
+	return post
+}
+
+func (e *feedEnv) page(t *testing.T, reader schemas.UserId, page plain.GetUserPostsPageData) ([]schemas.PostId, *plain.GetUserPostsPageData) {
+	t.Helper()
+	posts, next, _, err := e.feedManager.GetUserFeed(context.Background(), reader, page)
+	if err != nil {
+		t.Fatalf("get feed: %v", err)
+	}
+	ids := make([]schemas.PostId, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+	return ids, next
+}
+
+// walk reads the feed from the page to the end
+func (e *feedEnv) walk(t *testing.T, reader schemas.UserId, page plain.GetUserPostsPageData) []schemas.PostId {
+	t.Helper()
+	var walked []schemas.PostId
+	for pages := 0; pages < 100; pages++ {
+		ids, next := e.page(t, reader, page)
+		walked = append(walked, ids...)
+		if next == nil {
+			return walked
+		}
+		page = *next
+	}
+	t.Fatal("feed walk does not end")
+	return nil
+}
+
+func assertFeed(t *testing.T, expected []*schemas.Post, walked []schemas.PostId) {
+	t.Helper()
+	if len(walked) != len(expected) {
+		t.Fatalf("expected %d posts, got %d", len(expected), len(walked))
+	}
+	for i := range expected {
+		if walked[i] != expected[i].ID {
+			t.Errorf("post %d: expected %s by %s, got %s", i, expected[i].ID.Hex(), expected[i].AuthorID, walked[i].Hex())
+		}
+	}
+}
+
+func TestUserFeedTies(t *testing.T) {
+	for _, size := range []int{1, 2, 3, 5} {
+		env := newFeedEnv()
+		env.subscribe(t, "pushed", "reader")
+		env.subscribe(t, "pulled", "reader", "fan1", "fan2")
+
+		start := time.Now().UTC().Truncate(time.Millisecond)
+		for i := 0; i < 12; i++ {
+			at := start.Add(time.Duration(i/4) * time.Millisecond)
+			author := schemas.UserId("pushed")
+			if i%2 == 1 {
+				author = "pulled"
+			}
+			env.post(t, author, at)
+		}
+
+		assertFeed(t, env.expected, env.walk(t, "reader", plain.GetUserPostsPageData{Size: size}))
+	}
+}
+
+func TestUserFeedAuthorCrossesThreshold(t *testing.T) {
+	env := newFeedEnv()
+	env.subscribe(t, "pushed", "reader")
+	env.subscribe(t, "crossing", "reader", "fan1")
+
+	start := time.Now().UTC().Truncate(time.Millisecond)
+	for i := 0; i < 10; i++ {
+		author := schemas.UserId("pushed")
+		if i%3 != 0 {
+			author = "crossing"
+		}
+		env.post(t, author, start.Add(time.Duration(i)*time.Millisecond))
+	}
+	expected := append([]*schemas.Post(nil), env.expected...)
+
+	firstPage, next := env.page(t, "reader", plain.GetUserPostsPageData{Size: 4})
+	if next == nil {
+		t.Fatal("expected more pages")
+	}
+	env.subscribe(t, "crossing", "fan2")
+	// newer posts are polled by the head, not walked to
+	env.post(t, "crossing", start.Add(time.Hour))
+
+	assertFeed(t, expected, append(firstPage, env.walk(t, "reader", *next)...))
+}
+
+func TestUserFeedAuthorCrossesThresholdDownward(t *testing.T) {
+	env := newFeedEnv()
+	env.subscribe(t, "pushed", "reader")
+	env.subscribe(t, "crossing", "reader", "fan1", "fan2")
+
+	start := time.Now().UTC().Truncate(time.Millisecond)
+	for i := 0; i < 6; i++ {
+		env.post(t, "crossing", start.Add(time.Duration(i)*time.Millisecond))
+		env.post(t, "pushed", start.Add(time.Duration(i)*time.Millisecond))
+	}
+	// posts above were pushed to no feed, so the author stays pulled
+	env.unsubscribe(t, "fan2", "crossing")
+	for i := 6; i < 9; i++ {
+		env.post(t, "crossing", start.Add(time.Duration(i)*time.Millisecond))
+	}
+
+	for _, size := range []int{1, 4, 30} {
+		assertFeed(t, env.expected, env.walk(t, "reader", plain.GetUserPostsPageData{Size: size}))
+	}
+}
+
+func TestPulledAuthorSubscribersAreNotRead(t *testing.T) {
+	env := newFeedEnv()
+	env.subscribe(t, "pushed", "reader")
+	env.subscribe(t, "pulled", "reader", "fan1", "fan2")
+
+	start := time.Now().UTC().Truncate(time.Millisecond)
+	env.post(t, "pulled", start)
+	env.post(t, "pushed", start.Add(time.Millisecond))
+	if env.users.listings != 1 {
+		t.Errorf("expected subscribers of the pushed author only to be listed, got %d listings", env.users.listings)
+	}
+
+	env.users.counts, env.users.listings = 0, 0
+	env.post(t, "pulled", start.Add(2*time.Millisecond))
+	if env.users.counts != 0 || env.users.listings != 0 {
+		t.Errorf("expected no reads of subscribers of a marked author, got %d counts and %d listings", env.users.counts, env.users.listings)
+	}
+
+	env.users.counts = 0
+	assertFeed(t, env.expected, env.walk(t, "reader", plain.GetUserPostsPageData{Size: 2}))
+	if env.users.counts != 0 {
+		t.Errorf("expected feed reads to count no subscribers, got %d counts", env.users.counts)
+	}
+}
+
+func TestUserFeedDedupesPushedAndPulled(t *testing.T) {
+	env := newFeedEnv()
+	env.subscribe(t, "pushed", "reader")
+	env.subscribe(t, "crossing", "reader", "fan1")
+
+	start := time.Now().UTC().Truncate(time.Millisecond)
+	for i := 0; i < 6; i++ {
+		env.post(t, "crossing", start.Add(time.Duration(i)*time.Millisecond))
+		env.post(t, "pushed", start.Add(time.Duration(i)*time.Millisecond))
+	}
+	// posts above are in the personal feed and in the posts of the now pulled author
+	env.subscribe(t, "crossing", "fan2")
+	for i := 6; i < 9; i++ {
+		env.post(t, "crossing", start.Add(time.Duration(i)*time.Millisecond))
+	}
+
+	for _, size := range []int{1, 4, 7, 30} {
+		assertFeed(t, env.expected, env.walk(t, "reader", plain.GetUserPostsPageData{Size: size}))
+	}
+}
+
+func TestUserFeedHeadAcrossSources(t *testing.T) {
+	env := newFeedEnv()
+	env.subscribe(t, "pushed", "reader")
+	env.subscribe(t, "pulled", "reader", "fan1", "fan2")
+
+	start := time.Now().UTC().Truncate(time.Millisecond).Add(-time.Minute)
+	env.post(t, "pushed", start)
+	env.post(t, "pulled", start)
+	_, _, head, err := env.feedManager.GetUserFeed(context.Background(), "reader", plain.GetUserPostsPageData{Size: 2})
+	if err != nil {
+		t.Fatalf("get feed: %v", err)
+	}
+
+	env.expected = nil
+	for i := 1; i <= 7; i++ {
+		at := start.Add(time.Duration(i/2) * time.Millisecond)
+		if i%2 == 0 {
+			env.post(t, "pushed", at)
+		} else {
+			env.post(t, "pulled", at)
+		}
+	}
+
+	// every poll gives the oldest of the newer posts, newest first
+	var polled []schemas.PostId
+	for polls := 0; polls < 10 && head != nil; polls++ {
+		posts, _, next, err := env.feedManager.GetUserFeed(context.Background(), "reader", *head)
+		if err != nil {
+			t.Fatalf("poll feed: %v", err)
+		}
+		if len(posts) == 0 {
+			break
+		}
+		for i := len(posts) - 1; i >= 0; i-- {
+			polled = append([]schemas.PostId{posts[i].ID}, polled...)
+		}
+		head = next
+	}
+	assertFeed(t, env.expected, polled)
+}
+
+// repost remembers the repost and spreads it like the fan-out task does
+func (e *feedEnv) repost(t *testing.T, reposter schemas.UserId, post *schemas.Post) {
+	t.Helper()
+	ctx := context.Background()
+	err := e.posts.PutRepost(ctx, reposter, post.ID)
+	if err != nil {
+		t.Fatalf("put repost: %v", err)
+	}
+	err = e.feedManager.SpreadRepostOverSubscribers(ctx, reposter, post.ID)
+	if err != nil {
+		t.Fatalf("spread repost: %v", err)
+	}
+}
+
+func (e *feedEnv) unsubscribe(t *testing.T, subscriber schemas.UserId, author schemas.UserId) {
+	t.Helper()
+	ctx := context.Background()
+	err := e.users.RemoveSubscription(ctx, subscriber, author)
+	if err != nil {
+		t.Fatalf("unsubscribe %s from %s: %v", subscriber, author, err)
+	}
+	err = e.feedManager.RemovePostsFromPersonalFeed(ctx, subscriber, author)
+	if err != nil {
+		t.Fatalf("remove posts of %s: %v", author, err)
+	}
+}
+
+// assertFeedReposts reads the whole feed at once and checks its posts and who reposted them
+func (e *feedEnv) assertFeedReposts(t *testing.T, reader schemas.UserId, expected []*schemas.Post, reposters []schemas.UserId) {
+	t.Helper()
+	posts, _, _, err := e.feedManager.GetUserFeed(context.Background(), reader, plain.GetUserPostsPageData{Size: 100})
+	if err != nil {
+		t.Fatalf("get feed: %v", err)
+	}
+	if len(posts) != len(expected) {
+		t.Fatalf("expected %d posts, got %d", len(expected), len(posts))
+	}
+	for i := range expected {
+		if posts[i].ID != expected[i].ID || posts[i].RepostedBy != reposters[i] {
+			t.Errorf("post %d: expected %s reposted by %q, got %s reposted by %q",
+				i, expected[i].ID.Hex(), reposters[i], posts[i].ID.Hex(), posts[i].RepostedBy)
+		}
+	}
+}
+
+func TestUnsubscribeFromReposterKeepsFollowedPosts(t *testing.T) {
+	env := newFeedEnv()
+	env.subscribe(t, "author", "reader")
+	env.subscribe(t, "stranger")
+	env.subscribe(t, "reposter", "reader")
+
+	start := time.Now().UTC().Truncate(time.Millisecond).Add(-time.Minute)
+	followed := env.post(t, "author", start)
+	strangers := env.post(t, "stranger", start.Add(time.Millisecond))
+	own := env.post(t, "reposter", start.Add(2*time.Millisecond))
+	env.repost(t, "reposter", followed)
+	env.repost(t, "reposter", strangers)
+	env.assertFeedReposts(t, "reader", []*schemas.Post{strangers, followed, own}, []schemas.UserId{"reposter", "reposter", ""})
+
+	env.unsubscribe(t, "reader", "reposter")
+	env.assertFeedReposts(t, "reader", []*schemas.Post{followed}, []schemas.UserId{""})
+}
+
+func TestUnsubscribeFromAuthorKeepsReposts(t *testing.T) {
+	env := newFeedEnv()
+	env.subscribe(t, "author", "reader")
+	env.subscribe(t, "reposter", "reader")
+
+	start := time.Now().UTC().Truncate(time.Millisecond).Add(-time.Minute)
+	reposted := env.post(t, "author", start)
+	env.post(t, "author", start.Add(time.Millisecond))
+	env.repost(t, "reposter", reposted)
+
+	env.unsubscribe(t, "reader", "author")
+	env.assertFeedReposts(t, "reader", []*schemas.Post{reposted}, []schemas.UserId{"reposter"})
+}
+
+func TestMutedReposterKeepsFollowedPosts(t *testing.T) {
+	env := newFeedEnv()
+	env.subscribe(t, "author", "reader")
+	env.subscribe(t, "stranger")
+	env.subscribe(t, "reposter", "reader")
+
+	start := time.Now().UTC().Truncate(time.Millisecond).Add(-time.Minute)
+	followed := env.post(t, "author", start)
+	strangers := env.post(t, "stranger", start.Add(time.Millisecond))
+	env.repost(t, "reposter", followed)
+	env.repost(t, "reposter", strangers)
+
+	err := env.users.PutMute(context.Background(), "reader", "reposter")
+	if err != nil {
+		t.Fatalf("mute: %v", err)
+	}
+	env.assertFeedReposts(t, "reader", []*schemas.Post{followed}, []schemas.UserId{""})
+}
+
+// assertWalkReposts reads the feed page by page and checks its posts and who reposted them
+func (e *feedEnv) assertWalkReposts(t *testing.T, reader schemas.UserId, size int, expected []*schemas.Post, reposters []schemas.UserId) {
+	t.Helper()
+	var posts []*schemas.Post
+	page := plain.GetUserPostsPageData{Size: size}
+	for pages := 0; pages < 100; pages++ {
+		pagePosts, next, _, err := e.feedManager.GetUserFeed(context.Background(), reader, page)
+		if err != nil {
+			t.Fatalf("get feed: %v", err)
+		}
+		posts = append(posts, pagePosts...)
+		if next == nil {
+			break
+		}
+		page = *next
+	}
+	if len(posts) != len(expected) {
+		t.Fatalf("expected %d posts, got %d", len(expected), len(posts))
+	}
+	for i := range expected {
+		if posts[i].ID != expected[i].ID || posts[i].RepostedBy != reposters[i] {
+			t.Errorf("post %d: expected %s reposted by %q, got %s reposted by %q",
+				i, expected[i].ID.Hex(), reposters[i], posts[i].ID.Hex(), posts[i].RepostedBy)
+		}
+	}
+}
+
+func TestPulledReposterReposts(t *testing.T) {
+	env := newFeedEnv()
+	env.subscribe(t, "author", "reader")
+	env.subscribe(t, "stranger")
+	env.subscribe(t, "reposter", "reader", "fan1", "fan2")
+
+	start := time.Now().UTC().Truncate(time.Millisecond).Add(-time.Minute)
+	followed := env.post(t, "author", start)
+	strangers := env.post(t, "stranger", start.Add(time.Millisecond))
+	readers := env.post(t, "reader", start.Add(2*time.Millisecond))
+	_, _, head, err := env.feedManager.GetUserFeed(context.Background(), "reader", plain.GetUserPostsPageData{Size: 2})
+	if err != nil {
+		t.Fatalf("get feed: %v", err)
+	}
+
+	env.users.listings = 0
+	env.repost(t, "reposter", strangers)
+	env.repost(t, "reposter", readers)
+	if env.users.listings != 0 {
+		t.Errorf("expected subscribers of a pulled reposter not to be listed, got %d listings", env.users.listings)
+	}
+
+	for _, size := range []int{1, 2, 10} {
+		env.assertWalkReposts(t, "reader", size, []*schemas.Post{strangers, followed}, []schemas.UserId{"reposter", ""})
+	}
+	polled, _, _, err := env.feedManager.GetUserFeed(context.Background(), "reader", *head)
+	if err != nil {
+		t.Fatalf("poll feed: %v", err)
+	}
+	if len(polled) != 1 || polled[0].ID != strangers.ID || polled[0].RepostedBy != "reposter" {
+		t.Errorf("expected the repost to be polled, got %v", polled)
+	}
+}
+
+func TestMutedPulledReposter(t *testing.T) {
+	env := newFeedEnv()
+	env.subscribe(t, "author", "reader")
+	env.subscribe(t, "stranger")
+	env.subscribe(t, "reposter", "reader", "fan1", "fan2")
+
+	start := time.Now().UTC().Truncate(time.Millisecond).Add(-time.Minute)
+	followed := env.post(t, "author", start)
+	strangers := env.post(t, "stranger", start.Add(time.Millisecond))
+	env.repost(t, "reposter", followed)
+	env.repost(t, "reposter", strangers)
+
+	err := env.users.PutMute(context.Background(), "reader", "reposter")
+	if err != nil {
+		t.Fatalf("mute: %v", err)
+	}
+	env.assertWalkReposts(t, "reader", 1, []*schemas.Post{followed}, []schemas.UserId{""})
+}
+
+func TestReposterCrossesThreshold(t *testing.T) {
+	env := newFeedEnv()
+	env.subscribe(t, "stranger")
+	env.subscribe(t, "reposter", "reader")
+
+	start := time.Now().UTC().Truncate(time.Millisecond).Add(-time.Minute)
+	pushed := env.post(t, "stranger", start)
+	pulled := env.post(t, "stranger", start.Add(time.Millisecond))
+	env.repost(t, "reposter", pushed)
+	// the repost above is in the personal feed and in the reposts of the now pulled reposter
+	env.subscribe(t, "reposter", "fan1", "fan2")
+	env.repost(t, "reposter", pulled)
+
+	for _, size := range []int{1, 2, 10} {
+		env.assertWalkReposts(t, "reader", size, []*schemas.Post{pulled, pushed}, []schemas.UserId{"reposter", "reposter"})
+	}
+}
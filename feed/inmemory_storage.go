@@ -0,0 +1,149 @@
+package feed
+
+import (
+	"context"
+	"netwitter/plain"
+	"netwitter/schemas"
+	"sort"
+	"sync"
+	"time"
+)
+
+type MemoryFeedStorage struct {
+	mu sync.RWMutex
+
+	itemsByUser map[schemas.UserId]map[schemas.PostId]*PersonalFeedItem
+}
+
+func NewInMemoryStorage() *MemoryFeedStorage {
+	return &MemoryFeedStorage{
+		itemsByUser: map[schemas.UserId]map[schemas.PostId]*PersonalFeedItem{},
+	}
+}
+
+func (s *MemoryFeedStorage) PutPostToFeed(_ context.Context, userId schemas.UserId, post schemas.Post) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := s.getOrCreateItem(userId, post)
+	item.AuthorID = post.AuthorID
+	return nil
+}
+
+func (s *MemoryFeedStorage) PutRepostToFeed(_ context.Context, userId schemas.UserId, post schemas.Post, reposter schemas.UserId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := s.getOrCreateItem(userId, post)
+	item.AuthorID = post.AuthorID
+	item.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	item.RepostedBy = reposter
+	return nil
+}
+
+func (s *MemoryFeedStorage) getOrCreateItem(userId schemas.UserId, post schemas.Post) *PersonalFeedItem {
+	userItems, ok := s.itemsByUser[userId]
+	if !ok {
+		userItems = map[schemas.PostId]*PersonalFeedItem{}
+		s.itemsByUser[userId] = userItems
+	}
+	item, ok := userItems[post.ID]
+	if !ok {
+		item = &PersonalFeedItem{
+			UserID:    userId,
+			PostID:    post.ID,
+			CreatedAt: post.CreatedAt,
+		}
+		userItems[post.ID] = item
+	}
+	return item
+}
+
+func (s *MemoryFeedStorage) RemovePostFromFeeds(_ context.Context, postId schemas.PostId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, userItems := range s.itemsByUser {
+		delete(userItems, postId)
+	}
+	return nil
+}
+
+func (s *MemoryFeedStorage) RemoveAuthorFromFeed(_ context.Context, userId schemas.UserId, authorId schemas.UserId) ([]*schemas.FeedEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var reposts []*schemas.FeedEntry
+	userItems := s.itemsByUser[userId]
+	for postId, item := range userItems {
+		if item.RepostedBy == authorId {
+			reposts = append(reposts, item.toFeedEntry())
+			delete(userItems, postId)
+		} else if item.AuthorID == authorId && item.RepostedBy == "" {
+			delete(userItems, postId)
+		}
+	}
+	return reposts, nil
+}
+
+func (s *MemoryFeedStorage) GetUserFeed(_ context.Context, userId schemas.UserId, data plain.GetUserPostsPageData) ([]*schemas.FeedEntry, *plain.GetUserPostsPageData, error) {
+	cursor, packSize, err := plain.CorrectDestruct(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.RLock()
+	userItems := make([]PersonalFeedItem, 0, len(s.itemsByUser[userId]))
+	for _, item := range s.itemsByUser[userId] {
+		userItems = append(userItems, *item)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(userItems, func(i, j int) bool {
+		if !userItems[i].CreatedAt.Equal(userItems[j].CreatedAt) {
+			return userItems[i].CreatedAt.After(userItems[j].CreatedAt)
+		}
+		return userItems[i].PostID.Hex() > userItems[j].PostID.Hex()
+	})
+
+	if cursor != nil && cursor.Newer {
+		// items newer than the cursor go before it, the oldest of them are taken
+		end := sort.Search(len(userItems), func(i int) bool {
+			return !cursor.Before(plain.TimeSortKey(userItems[i].CreatedAt), userItems[i].PostID)
+		})
+		start := 0
+		if end > packSize {
+			start = end - packSize
+		}
+		userItems = userItems[start:end]
+		if len(userItems) == 0 {
+			return toFeedEntries(userItems), plain.HeadPage(cursor.SortKey, cursor.ID, packSize, 0), nil
+		}
+		head := userItems[0]
+		return toFeedEntries(userItems), plain.HeadPage(plain.TimeSortKey(head.CreatedAt), head.PostID, packSize, start), nil
+	}
+
+	if cursor != nil {
+		start := sort.Search(len(userItems), func(i int) bool {
+			return cursor.After(plain.TimeSortKey(userItems[i].CreatedAt), userItems[i].PostID)
+		})
+		userItems = userItems[start:]
+	}
+
+	var nextPageToken *plain.GetUserPostsPageData
+	if len(userItems) > packSize {
+		last := userItems[packSize-1]
+		nextPageToken = plain.NextPage(plain.TimeSortKey(last.CreatedAt), last.PostID, packSize)
+		userItems = userItems[:packSize]
+	}
+
+	return toFeedEntries(userItems), nextPageToken, nil
+}
+
+func toFeedEntries(items []PersonalFeedItem) []*schemas.FeedEntry {
+	feedEntries := make([]*schemas.FeedEntry, 0, len(items))
+	for i := range items {
+		feedEntries = append(feedEntries, items[i].toFeedEntry())
+	}
+	return feedEntries
+}
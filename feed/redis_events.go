@@ -0,0 +1,101 @@
+package feed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"log"
+	"netwitter/schemas"
+	"sync"
+)
+
+// RedisEventBus delivers events through redis pub/sub, so workers reach
+// readers connected to any server replica
+type RedisEventBus struct {
+	client *redis.Client
+}
+
+func NewRedisEventBus(client *redis.Client) *RedisEventBus {
+	return &RedisEventBus{client: client}
+}
+
+type redisFeedEvent struct {
+	Type     string `json:"type"`
+	PostID   string `json:"postId"`
+	AuthorID string `json:"authorId"`
+}
+
+func (b *RedisEventBus) Publish(ctx context.Context, channel string, event FeedEvent) error {
+	rawEvent, err := json.Marshal(redisFeedEvent{
+		Type:     event.Type,
+		PostID:   event.PostID.Hex(),
+		AuthorID: string(event.AuthorID),
+	})
+	if err != nil {
+		return err
+	}
+	err = b.client.Publish(ctx, channel, rawEvent).Err()
+	if err != nil {
+		return fmt.Errorf("redis error: %s", err.Error())
+	}
+	return nil
+}
+
+func (b *RedisEventBus) Subscribe(ctx context.Context, channels []string) (EventSubscription, error) {
+	pubsub := b.client.Subscribe(ctx, channels...)
+	// events published after the confirmation are not missed
+	_, err := pubsub.Receive(ctx)
+	if err != nil {
+		_ = pubsub.Close()
+		return nil, fmt.Errorf("redis error: %s", err.Error())
+	}
+
+	subscription := &redisSubscription{
+		pubsub: pubsub,
+		events: make(chan FeedEvent),
+		done:   make(chan struct{}),
+	}
+	go subscription.receive()
+	return subscription, nil
+}
+
+type redisSubscription struct {
+	pubsub    *redis.PubSub
+	events    chan FeedEvent
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *redisSubscription) receive() {
+	defer close(s.events)
+	for message := range s.pubsub.Channel() {
+		var rawEvent redisFeedEvent
+		err := json.Unmarshal([]byte(message.Payload), &rawEvent)
+		if err != nil {
+			log.Printf("Malformed feed event: %s", err)
+			continue
+		}
+		postId, err := schemas.IDFromText(rawEvent.PostID)
+		if err != nil {
+			log.Printf("Malformed feed event: %s", err)
+			continue
+		}
+		select {
+		case s.events <- FeedEvent{Type: rawEvent.Type, PostID: postId, AuthorID: schemas.UserId(rawEvent.AuthorID)}:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *redisSubscription) Events() <-chan FeedEvent {
+	return s.events
+}
+
+func (s *redisSubscription) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	return s.pubsub.Close()
+}
@@ -11,12 +11,26 @@ import (
 	"time"
 )
 
+// PersonalFeedItem is unique per user and post.
+// CreatedAt is the position of the item in the feed: the post creation time,
+// or the repost time when the item came to the feed through RepostedBy.
+// Post contents are not copied here, feed readers take them from posts storage,
+// so edits are seen in feeds right away.
 type PersonalFeedItem struct {
-	UserID    schemas.UserId `bson:"userId"`
-	PostID    schemas.PostId `bson:"postId"`
-	AuthorID  schemas.UserId `bson:"authorId"`
-	Text      string         `bson:"text"`
-	CreatedAt time.Time      `bson:"createdAt"`
+	UserID     schemas.UserId `bson:"userId"`
+	PostID     schemas.PostId `bson:"postId"`
+	AuthorID   schemas.UserId `bson:"authorId"`
+	CreatedAt  time.Time      `bson:"createdAt"`
+	RepostedBy schemas.UserId `bson:"repostedBy,omitempty"`
+}
+
+func (item *PersonalFeedItem) toFeedEntry() *schemas.FeedEntry {
+	return &schemas.FeedEntry{
+		PostID:     item.PostID,
+		AuthorID:   item.AuthorID,
+		AddedAt:    item.CreatedAt,
+		RepostedBy: item.RepostedBy,
+	}
 }
 
 type FeedStorage struct {
@@ -39,8 +53,9 @@ func NewStorage(ctx context.Context, mongoUrl, dbName string) *FeedStorage {
 }
 
 func ensureIndexes(ctx context.Context, collection *mongo.Collection) error {
+	// postId is the tie-breaker of feed positions
 	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{"userId", 1}, {"createdAt", -1}},
+		Keys: bson.D{{"userId", 1}, {"createdAt", -1}, {"postId", -1}},
 	})
 	if err != nil {
 		return err
@@ -52,87 +67,189 @@ func ensureIndexes(ctx context.Context, collection *mongo.Collection) error {
 	if err != nil {
 		return err
 	}
+
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"postId", 1}},
+	})
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
 func (s *FeedStorage) PutPostToFeed(ctx context.Context, userId schemas.UserId, post schemas.Post) error {
 	mongoQuery := bson.M{"userId": string(userId), "postId": post.ID}
-	item := &PersonalFeedItem{
-		UserID:    userId,
-		PostID:    post.ID,
-		AuthorID:  post.AuthorID,
-		Text:      string(post.Content),
-		CreatedAt: post.CreatedAt,
+	// position and repost annotation of an existing item are kept
+	mongoCommand := bson.M{
+		"$set": bson.M{
+			"authorId": string(post.AuthorID),
+		},
+		"$setOnInsert": bson.M{
+			"createdAt": post.CreatedAt,
+		},
+	}
+
+	mongoOpts := options.Update().SetUpsert(true)
+	_, err := s.feedCollection.UpdateOne(ctx, mongoQuery, mongoCommand, mongoOpts)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *FeedStorage) PutRepostToFeed(ctx context.Context, userId schemas.UserId, post schemas.Post, reposter schemas.UserId) error {
+	mongoQuery := bson.M{"userId": string(userId), "postId": post.ID}
+	mongoCommand := bson.M{
+		"$set": bson.M{
+			"authorId":   string(post.AuthorID),
+			"createdAt":  time.Now().UTC().Truncate(time.Millisecond),
+			"repostedBy": string(reposter),
+		},
 	}
 
-	mongoOpts := options.Replace().SetUpsert(true)
-	_, err := s.feedCollection.ReplaceOne(ctx, mongoQuery, item, mongoOpts)
+	mongoOpts := options.Update().SetUpsert(true)
+	_, err := s.feedCollection.UpdateOne(ctx, mongoQuery, mongoCommand, mongoOpts)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (s *FeedStorage) GetUserFeed(ctx context.Context, userId schemas.UserId, data plain.GetUserPostsPageData) ([]*schemas.Post, *plain.GetUserPostsPageData, error) {
-	lastSeenPost, packSize, err := plain.CorrectDestruct(data)
+func (s *FeedStorage) RemovePostFromFeeds(ctx context.Context, postId schemas.PostId) error {
+	_, err := s.feedCollection.DeleteMany(ctx, bson.M{"postId": postId})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// RemoveAuthorFromFeed removes posts of the author unless someone else reposted them, and posts reposted by the author
+func (s *FeedStorage) RemoveAuthorFromFeed(ctx context.Context, userId schemas.UserId, authorId schemas.UserId) ([]*schemas.FeedEntry, error) {
+	repostsQuery := bson.M{"userId": string(userId), "repostedBy": string(authorId)}
+	mongoCursor, err := s.feedCollection.Find(ctx, repostsQuery)
+	if err != nil {
+		return nil, err
+	}
+	var items []PersonalFeedItem
+	err = mongoCursor.All(ctx, &items)
+	if err != nil {
+		return nil, err
+	}
+
+	mongoQuery := bson.M{
+		"userId": string(userId),
+		"$or": bson.A{
+			bson.M{"authorId": string(authorId), "repostedBy": bson.M{"$exists": false}},
+			bson.M{"repostedBy": string(authorId)},
+		},
+	}
+	_, err = s.feedCollection.DeleteMany(ctx, mongoQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	reposts := make([]*schemas.FeedEntry, len(items))
+	for i := range items {
+		reposts[i] = items[i].toFeedEntry()
+	}
+	return reposts, nil
+}
+
+func (s *FeedStorage) GetUserFeed(ctx context.Context, userId schemas.UserId, data plain.GetUserPostsPageData) ([]*schemas.FeedEntry, *plain.GetUserPostsPageData, error) {
+	cursor, packSize, err := plain.CorrectDestruct(data)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	if cursor != nil && cursor.Newer {
+		return s.getNewerUserFeed(ctx, userId, cursor, packSize)
+	}
+
 	mongoFilter := bson.M{"userId": string(userId)}
-	if lastSeenPost != nil {
-		mongoFilter["postId"] = bson.M{"$lte": *lastSeenPost}
+	if cursor != nil {
+		cursorTime := plain.TimeFromSortKey(cursor.SortKey)
+		mongoFilter["$or"] = bson.A{
+			bson.M{"createdAt": bson.M{"$lt": cursorTime}},
+			bson.M{"createdAt": cursorTime, "postId": bson.M{"$lt": cursor.ID}},
+		}
 	}
 
-	searchPackSize := int64(packSize + 2)
+	searchPackSize := int64(packSize + 1) // with redundant next
 	mongoOptions := &options.FindOptions{
 		Limit: &searchPackSize,
-		Sort:  bson.M{"createdAt": -1},
+		Sort:  bson.D{{"createdAt", -1}, {"postId", -1}},
 	}
 
-	cursor, err := s.feedCollection.Find(ctx, mongoFilter, mongoOptions)
+	mongoCursor, err := s.feedCollection.Find(ctx, mongoFilter, mongoOptions)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	var allUserFeedItems []*PersonalFeedItem
-	err = cursor.All(ctx, &allUserFeedItems)
+	err = mongoCursor.All(ctx, &allUserFeedItems)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	if lastSeenPost != nil && len(allUserFeedItems) == 0 {
-		return nil, nil, fmt.Errorf("invalid page token:%s", *lastSeenPost)
+	var nextPageToken *plain.GetUserPostsPageData
+	if len(allUserFeedItems) > packSize {
+		last := allUserFeedItems[packSize-1]
+		nextPageToken = plain.NextPage(plain.TimeSortKey(last.CreatedAt), last.PostID, packSize)
+		allUserFeedItems = allUserFeedItems[:packSize]
 	}
 
-	if lastSeenPost != nil && allUserFeedItems[0].PostID != *lastSeenPost {
-		return nil, nil, fmt.Errorf("page not found:%s", *lastSeenPost)
+	feedEntries := make([]*schemas.FeedEntry, 0, len(allUserFeedItems))
+	for i := range allUserFeedItems {
+		feedEntries = append(feedEntries, allUserFeedItems[i].toFeedEntry())
 	}
 
-	if lastSeenPost != nil {
-		allUserFeedItems = allUserFeedItems[1:]
+	return feedEntries, nextPageToken, nil
+}
+
+// getNewerUserFeed returns packSize feed entries going right before the cursor, newest first
+func (s *FeedStorage) getNewerUserFeed(ctx context.Context, userId schemas.UserId, cursor *plain.Cursor, packSize int) ([]*schemas.FeedEntry, *plain.GetUserPostsPageData, error) {
+	cursorTime := plain.TimeFromSortKey(cursor.SortKey)
+	mongoFilter := bson.M{
+		"userId": string(userId),
+		"$or": bson.A{
+			bson.M{"createdAt": bson.M{"$gt": cursorTime}},
+			bson.M{"createdAt": cursorTime, "postId": bson.M{"$gt": cursor.ID}},
+		},
 	}
 
-	var nextPageToken *plain.GetUserPostsPageData
-	if len(allUserFeedItems) > packSize {
-		nextPageToken = &plain.GetUserPostsPageData{
-			LastSeenID: allUserFeedItems[packSize-1].PostID.ToBase64URL(),
-			Size:       packSize,
-		}
-		allUserFeedItems = allUserFeedItems[:packSize]
+	searchPackSize := int64(packSize)
+	mongoOptions := &options.FindOptions{
+		Limit: &searchPackSize,
+		Sort:  bson.D{{"createdAt", 1}, {"postId", 1}},
 	}
 
-	feedPosts := make([]*schemas.Post, 0, len(allUserFeedItems))
-	for i := range allUserFeedItems {
-		feedPosts = append(feedPosts, &schemas.Post{
-			ID:             allUserFeedItems[i].PostID,
-			AuthorID:       allUserFeedItems[i].AuthorID,
-			Content:        schemas.Text(allUserFeedItems[i].Text),
-			CreatedAt:      allUserFeedItems[i].CreatedAt,
-			LastModifiedAt: time.Now(),
-			Version:        0,
-		})
+	mongoCursor, err := s.feedCollection.Find(ctx, mongoFilter, mongoOptions)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return feedPosts, nextPageToken, nil
+	var newerFeedItems []*PersonalFeedItem
+	err = mongoCursor.All(ctx, &newerFeedItems)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(newerFeedItems) == 0 {
+		return []*schemas.FeedEntry{}, plain.HeadPage(cursor.SortKey, cursor.ID, packSize, 0), nil
+	}
+
+	newerCount, err := s.feedCollection.CountDocuments(ctx, mongoFilter)
+	if err != nil {
+		return nil, nil, err
+	}
+	pending := int(newerCount) - len(newerFeedItems)
+	if pending < 0 {
+		pending = 0
+	}
+
+	feedEntries := make([]*schemas.FeedEntry, 0, len(newerFeedItems))
+	for i := len(newerFeedItems) - 1; i >= 0; i-- {
+		feedEntries = append(feedEntries, newerFeedItems[i].toFeedEntry())
+	}
+	head := feedEntries[0]
+	return feedEntries, plain.HeadPage(plain.TimeSortKey(head.AddedAt), head.PostID, packSize, pending), nil
 }
@@ -3,13 +3,13 @@ module netwitter
 go 1.16
 
 require (
-	github.com/RichardKnop/machinery v1.10.6 // indirect
+	github.com/RichardKnop/machinery v1.10.6
 	github.com/go-redis/redis/v8 v8.11.4
 	github.com/golang/snappy v0.0.2 // indirect
 	github.com/gorilla/mux v1.8.0
 	github.com/klauspost/compress v1.11.7 // indirect
 	github.com/stretchr/testify v1.7.0 // indirect
 	go.mongodb.org/mongo-driver v1.7.2
-	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad // indirect
+	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
 	golang.org/x/sync v0.0.0-20201207232520-09787c993a3a // indirect
 )
@@ -1,27 +1,53 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
+	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"netwitter/auth"
+	"netwitter/feed"
+	"netwitter/media"
 	"netwitter/plain"
 	"netwitter/schemas"
 	"netwitter/storage"
 	"netwitter/users"
 	"strconv"
+	"strings"
+	"time"
 )
 
-func NewHTTPHandler(storage storage.Storage, usersManager users.UsersManager) *HTTPHandler {
+const (
+	maxThreadSize = 500
+
+	feedStreamDuration = 10 * time.Second
+	feedStreamRetry    = 500 * time.Millisecond
+)
+
+func NewHTTPHandler(storage storage.Storage, usersManager users.UsersManager, feedManager *feed.FeedManager, likesStorage storage.LikesStorage, mediaManager *media.MediaManager, authManager *auth.AuthManager) *HTTPHandler {
 	return &HTTPHandler{
 		Storage:      storage,
 		usersManager: usersManager,
+		feedManager:  feedManager,
+		likesStorage: likesStorage,
+		mediaManager: mediaManager,
+		authManager:  authManager,
 	}
 }
 
+// HTTPHandler takes users from request contexts, auth.Middleware puts them there
 type HTTPHandler struct {
 	Storage      storage.Storage
 	usersManager users.UsersManager
+	feedManager  *feed.FeedManager
+	likesStorage storage.LikesStorage
+	mediaManager *media.MediaManager
+	authManager  *auth.AuthManager
 }
 
 type PutRequestData struct {
@@ -33,47 +59,142 @@ type PutResponseData struct {
 }
 
 type CreatePostRequestData struct {
-	Text string `json:"text"`
+	Text          string   `json:"text"`
+	ParentID      string   `json:"parentId,omitempty"`
+	AttachmentIDs []string `json:"attachmentIds,omitempty"`
+}
+
+type RepostRequestData struct {
+	Text string `json:"text,omitempty"`
 }
 
 type EditPostRequestData struct {
 	Text string `json:"text"`
 }
 
+// GetUserPostsResponse is a page of posts, Head is the page token of posts newer
+// than the ones seen so far, given with first pages and pages of newer posts.
+// Pending is the number of such posts at the moment.
 type GetUserPostsResponse struct {
 	Posts    []schemas.PostData `json:"posts"`
 	NextPage *string            `json:"nextPage,omitempty"`
+	Head     *string            `json:"head,omitempty"`
+	Pending  *int               `json:"pending,omitempty"`
 }
 
-func (h *HTTPHandler) HandleCreatePost(rw http.ResponseWriter, r *http.Request) {
-	userId := r.Header.Get("System-Design-User-Id")
-	if userId == "" {
-		http.Error(rw, "no auth", http.StatusUnauthorized)
-		return
+func (resp *GetUserPostsResponse) setPages(nextPage *plain.GetUserPostsPageData, head *plain.GetUserPostsPageData) {
+	if nextPage != nil {
+		nextPageEncoded := nextPage.Token
+		resp.NextPage = &nextPageEncoded
 	}
+	if head != nil {
+		headEncoded := head.Token
+		pending := head.Pending
+		resp.Head = &headEncoded
+		resp.Pending = &pending
+	}
+}
 
-	var data CreatePostRequestData
+type GetPostRevisionsResponse struct {
+	Revisions []schemas.PostRevisionData `json:"revisions"`
+	NextPage  *string                    `json:"nextPage,omitempty"`
+}
+
+func parsePageData(queryParams url.Values) (plain.GetUserPostsPageData, error) {
+	var parsedPageData plain.GetUserPostsPageData
+	if pageToken := queryParams.Get("page"); pageToken != "" {
+		parsedPageData = plain.GetUserPostsPageData{Token: pageToken}
+	}
+	if rawSize := queryParams.Get("size"); rawSize != "" {
+		parsedSize, err := strconv.ParseInt(rawSize, 10, 32)
+		if err != nil {
+			return plain.GetUserPostsPageData{}, fmt.Errorf("invalid page size: %s", err.Error())
+		}
+		parsedPageData.Size = int(parsedSize)
+	}
+	return parsedPageData, nil
+}
+
+type CredentialsRequestData struct {
+	UserID   string `json:"userId"`
+	Password string `json:"password"`
+}
+
+type RegisterUserRequestData struct {
+	Handle      string `json:"handle"`
+	Password    string `json:"password"`
+	DisplayName string `json:"displayName"`
+	Bio         string `json:"bio"`
+	Private     bool   `json:"private"`
+}
+
+type UpdateProfileRequestData struct {
+	DisplayName *string `json:"displayName"`
+	Bio         *string `json:"bio"`
+	AvatarID    *string `json:"avatarId"`
+	Private     *bool   `json:"private"`
+}
+
+type TokenResponse struct {
+	AccessToken string    `json:"accessToken"`
+	TokenType   string    `json:"tokenType"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// HandleRegisterUser creates a profile and credentials of a new user
+func (h *HTTPHandler) HandleRegisterUser(rw http.ResponseWriter, r *http.Request) {
+	var data RegisterUserRequestData
 	err := json.NewDecoder(r.Body).Decode(&data)
 	if err != nil {
 		http.Error(rw, "bad body", http.StatusBadRequest)
 		return
 	}
 
-	text := data.Text
-	if text == "" {
-		http.Error(rw, "text must not be empty", http.StatusBadRequest)
+	// the password is checked first, so a rejected one does not leave a profile behind
+	err = auth.ValidatePassword(data.Password)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := h.usersManager.Register(r.Context(), schemas.User{
+		ID:          schemas.UserId(data.Handle),
+		DisplayName: data.DisplayName,
+		Bio:         data.Bio,
+		Private:     data.Private,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, users.UsersError):
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, storage.ErrCollision):
+			http.Error(rw, "handle is taken", http.StatusConflict)
+		default:
+			http.Error(rw, "internal error", http.StatusInternalServerError)
+		}
 		return
 	}
 
-	newPost, err := h.Storage.PutPost(r.Context(), schemas.UserId(userId), schemas.Text(text))
+	err = h.authManager.Register(r.Context(), user.ID, data.Password)
 	if err != nil {
-		http.Error(rw, "internal error", http.StatusInternalServerError)
+		// a profile without credentials would keep the handle taken for good
+		unregisterErr := h.usersManager.Unregister(r.Context(), user.ID)
+		if unregisterErr != nil {
+			log.Printf("Failed to unregister %s: %s", user.ID, unregisterErr)
+		}
+		switch {
+		case errors.Is(err, auth.AuthError):
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, storage.ErrCollision):
+			http.Error(rw, "handle is taken", http.StatusConflict)
+		default:
+			http.Error(rw, "internal error", http.StatusInternalServerError)
+		}
 		return
 	}
 
-	postData := newPost.ToPostData()
-	rawResponse, _ := json.Marshal(postData)
+	rawResponse, _ := json.Marshal(user.ToUserData())
 	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusCreated)
 	_, err = rw.Write(rawResponse)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
@@ -81,27 +202,24 @@ func (h *HTTPHandler) HandleCreatePost(rw http.ResponseWriter, r *http.Request)
 	}
 }
 
-func (h *HTTPHandler) HandleGetPost(rw http.ResponseWriter, r *http.Request) {
-	postId := mux.Vars(r)["postId"]
-	if postId == "" {
-		http.Error(rw, "incorrect post id", http.StatusBadRequest)
-		return
-	}
-
-	postIdBase64, err := schemas.IDFromRawString(postId)
-	if err != nil {
-		http.Error(rw, err.Error(), http.StatusBadRequest)
+func (h *HTTPHandler) HandleGetUserProfile(rw http.ResponseWriter, r *http.Request) {
+	userId := schemas.UserId(mux.Vars(r)["userId"])
+	if userId == "" {
+		http.Error(rw, "empty user id", http.StatusBadRequest)
 		return
 	}
 
-	post, err := h.Storage.GetPost(r.Context(), postIdBase64)
+	user, err := h.usersManager.GetProfile(r.Context(), userId)
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusNotFound)
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "user not found", http.StatusNotFound)
+			return
+		}
+		http.Error(rw, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	postData := post.ToPostData()
-	rawResponse, _ := json.Marshal(postData)
+	rawResponse, _ := json.Marshal(user.ToUserData())
 	rw.Header().Set("Content-Type", "application/json")
 	_, err = rw.Write(rawResponse)
 	if err != nil {
@@ -110,108 +228,250 @@ func (h *HTTPHandler) HandleGetPost(rw http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (h *HTTPHandler) HandleGetUserPosts(rw http.ResponseWriter, r *http.Request) {
-	userId := schemas.UserId(mux.Vars(r)["userId"])
+// HandleUpdateUserProfile changes only fields present in the body, an empty avatarId removes the avatar
+func (h *HTTPHandler) HandleUpdateUserProfile(rw http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
 	if userId == "" {
-		http.Error(rw, "blank userId", http.StatusBadRequest)
+		http.Error(rw, "no auth", http.StatusUnauthorized)
+		return
+	}
+	if schemas.UserId(mux.Vars(r)["userId"]) != userId {
+		http.Error(rw, "you shall not pass", http.StatusForbidden)
 		return
 	}
 
-	queryParams := r.URL.Query()
-	var parsedPageData plain.GetUserPostsPageData
-	if pageToken := queryParams.Get("page"); pageToken != "" {
-		parsedPageData = plain.GetUserPostsPageData{LastSeenID: pageToken}
+	var data UpdateProfileRequestData
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		http.Error(rw, "bad body", http.StatusBadRequest)
+		return
 	}
-	if rawSize := queryParams.Get("size"); rawSize != "" {
-		parsedSize, err := strconv.ParseInt(rawSize, 10, 32)
+
+	update := plain.ProfileUpdate{DisplayName: data.DisplayName, Bio: data.Bio, Private: data.Private}
+	if data.AvatarID != nil {
+		avatarId := schemas.AttachmentId(*data.AvatarID)
+		update.AvatarID = &avatarId
+		if avatarId != "" {
+			_, err = h.mediaManager.ResolveAvatar(r.Context(), userId, *data.AvatarID)
+			if err != nil {
+				if errors.Is(err, media.MediaError) {
+					http.Error(rw, err.Error(), http.StatusBadRequest)
+					return
+				}
+				http.Error(rw, "internal error", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	user, err := h.usersManager.UpdateProfile(r.Context(), userId, update)
+	if err != nil {
+		switch {
+		case errors.Is(err, users.UsersError):
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, storage.ErrNotFound):
+			http.Error(rw, "user not found", http.StatusNotFound)
+		default:
+			http.Error(rw, "internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+	if update.AvatarID != nil {
+		err = h.mediaManager.SetAvatar(r.Context(), userId, user.AvatarID)
 		if err != nil {
-			http.Error(rw, fmt.Sprintf("invalid page size: %s", err.Error()), http.StatusBadRequest)
+			http.Error(rw, "internal error", http.StatusInternalServerError)
 			return
 		}
-		parsedPageData.Size = int(parsedSize)
-	}
-	if parsedPageData.Size == 0 {
-		parsedPageData.Size = plain.DefaultPageSize
 	}
 
-	postList, nextPageToken, err := h.Storage.GetUserPosts(r.Context(), schemas.UserId(userId), parsedPageData)
+	rawResponse, _ := json.Marshal(user.ToUserData())
+	rw.Header().Set("Content-Type", "application/json")
+	_, err = rw.Write(rawResponse)
 	if err != nil {
-		http.Error(rw, fmt.Sprintf("failed find posts:%s", err.Error()), http.StatusBadRequest)
+		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
+}
 
-	response := GetUserPostsResponse{
-		Posts: make([]schemas.PostData, len(postList)),
+// checkPostVisible replies as if the post did not exist and returns false when
+// the author is a private account the viewer is not approved for
+func (h *HTTPHandler) checkPostVisible(rw http.ResponseWriter, ctx context.Context, viewer schemas.UserId, post *schemas.Post) bool {
+	canView, err := h.usersManager.CanViewPosts(ctx, viewer, post.AuthorID)
+	if err != nil {
+		http.Error(rw, "internal error", http.StatusInternalServerError)
+		return false
 	}
-
-	for i, post := range postList {
-		response.Posts[i] = post.ToPostData()
+	if !canView {
+		http.Error(rw, "post not found", http.StatusNotFound)
+		return false
 	}
+	return true
+}
 
-	if nextPageToken != nil {
-		nextPageEncoded := nextPageToken.LastSeenID
-		response.NextPage = &nextPageEncoded
+// checkAttachmentVisible replies as if the attachment did not exist and returns false when
+// the viewer may not see the post it is attached to, uploads no post or profile took
+// and attachments of deleted posts are seen only by the owner
+func (h *HTTPHandler) checkAttachmentVisible(rw http.ResponseWriter, ctx context.Context, viewer schemas.UserId, attachment *schemas.Attachment) bool {
+	switch {
+	case attachment.PostID != nil:
+		post, err := h.Storage.GetPost(ctx, *attachment.PostID)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				http.Error(rw, "attachment not found", http.StatusNotFound)
+				return false
+			}
+			http.Error(rw, "internal error", http.StatusInternalServerError)
+			return false
+		}
+		return h.checkPostVisible(rw, ctx, viewer, post)
+	case attachment.AvatarOf != nil:
+		return true
+	case viewer == attachment.OwnerID:
+		return true
+	default:
+		http.Error(rw, "attachment not found", http.StatusNotFound)
+		return false
 	}
+}
 
-	rawResponse, _ := json.Marshal(response)
-	rw.Header().Set("Content-Type", "application/json")
-	_, err = rw.Write(rawResponse)
+// checkAuthorExists replies with an error and returns false when the user has no profile
+func (h *HTTPHandler) checkAuthorExists(rw http.ResponseWriter, ctx context.Context, userId schemas.UserId) bool {
+	err := h.usersManager.CheckUserExists(ctx, userId)
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusBadRequest)
-		return
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "user is not registered", http.StatusForbidden)
+			return false
+		}
+		http.Error(rw, "internal error", http.StatusInternalServerError)
+		return false
 	}
+	return true
 }
 
-func (h *HTTPHandler) HandleEditPost(rw http.ResponseWriter, r *http.Request) {
-	userId := r.Header.Get("System-Design-User-Id")
-	if userId == "" {
-		http.Error(rw, "no auth", http.StatusUnauthorized)
+// HandleIssueToken exchanges credentials for an access token
+func (h *HTTPHandler) HandleIssueToken(rw http.ResponseWriter, r *http.Request) {
+	var data CredentialsRequestData
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		http.Error(rw, "bad body", http.StatusBadRequest)
 		return
 	}
 
-	postId := mux.Vars(r)["postId"]
-	if postId == "" {
-		http.Error(rw, "incorrect post id", http.StatusBadRequest)
+	token, expiresAt, err := h.authManager.Login(r.Context(), schemas.UserId(data.UserID), data.Password)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			http.Error(rw, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	postIdBase64, err := schemas.IDFromRawString(postId)
+	rawResponse, _ := json.Marshal(TokenResponse{AccessToken: token, TokenType: "Bearer", ExpiresAt: expiresAt})
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	_, err = rw.Write(rawResponse)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
+}
 
-	var data EditPostRequestData
-	err = json.NewDecoder(r.Body).Decode(&data)
+func (h *HTTPHandler) HandleCreatePost(rw http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+	if userId == "" {
+		http.Error(rw, "no auth", http.StatusUnauthorized)
+		return
+	}
+
+	var data CreatePostRequestData
+	err := json.NewDecoder(r.Body).Decode(&data)
 	if err != nil {
 		http.Error(rw, "bad body", http.StatusBadRequest)
 		return
 	}
 
 	text := data.Text
-	if text == "" {
+	if text == "" && len(data.AttachmentIDs) == 0 {
 		http.Error(rw, "text must not be empty", http.StatusBadRequest)
 		return
 	}
-
-	post, err := h.Storage.GetPost(r.Context(), postIdBase64)
-	if err != nil {
-		http.Error(rw, err.Error(), http.StatusNotFound)
+	if !h.checkAuthorExists(rw, r.Context(), userId) {
 		return
 	}
 
-	if string(post.AuthorID) != userId {
-		http.Error(rw, "you shall not pass", http.StatusForbidden)
-		return
+	var opts plain.PostOptions
+	if len(data.AttachmentIDs) != 0 {
+		opts.Attachments, err = h.mediaManager.ResolveAttachments(r.Context(), userId, data.AttachmentIDs)
+		if err != nil {
+			if errors.Is(err, media.ErrAttachmentUsed) {
+				http.Error(rw, err.Error(), http.StatusConflict)
+				return
+			}
+			if errors.Is(err, media.MediaError) {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(rw, "internal error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if data.ParentID != "" {
+		parentId, err := schemas.IDFromRawString(data.ParentID)
+		if err != nil {
+			http.Error(rw, fmt.Sprintf("invalid parent id: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		parent, err := h.Storage.GetPost(r.Context(), parentId)
+		if err != nil {
+			http.Error(rw, "parent post not found", http.StatusBadRequest)
+			return
+		}
+		canView, err := h.usersManager.CanViewPosts(r.Context(), userId, parent.AuthorID)
+		if err != nil {
+			http.Error(rw, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if !canView {
+			http.Error(rw, "parent post not found", http.StatusBadRequest)
+			return
+		}
+		opts.ParentID = &parent.ID
 	}
 
-	editedPost, err := h.Storage.EditPost(r.Context(), post.ID, post.AuthorID, schemas.Text(text))
+	// attachments are claimed first, so followers never get a post whose media went to another one
+	if len(opts.Attachments) != 0 {
+		postId := schemas.NewPostId()
+		opts.ID = &postId
+		err = h.mediaManager.ClaimAttachments(r.Context(), postId, opts.Attachments)
+		if err != nil {
+			if errors.Is(err, media.ErrAttachmentUsed) {
+				http.Error(rw, err.Error(), http.StatusConflict)
+				return
+			}
+			http.Error(rw, "internal error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	newPost, err := h.Storage.PutPost(r.Context(), userId, schemas.Text(text), opts)
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		// the post is kept when only fan-out scheduling failed, its attachments stay with it
+		if opts.ID != nil {
+			_, getErr := h.Storage.GetPost(r.Context(), *opts.ID)
+			if errors.Is(getErr, storage.ErrNotFound) {
+				_ = h.mediaManager.ReleasePost(r.Context(), *opts.ID)
+			}
+		}
+		http.Error(rw, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	postData := editedPost.ToPostData()
+	postData, err := h.toPostData(r.Context(), userId, newPost)
+	if err != nil {
+		http.Error(rw, "internal error", http.StatusInternalServerError)
+		return
+	}
 	rawResponse, _ := json.Marshal(postData)
 	rw.Header().Set("Content-Type", "application/json")
 	_, err = rw.Write(rawResponse)
@@ -221,46 +481,35 @@ func (h *HTTPHandler) HandleEditPost(rw http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (h *HTTPHandler) HandleGetUserSubscriptions(rw http.ResponseWriter, r *http.Request) {
-	userIdRaw := r.Header.Get("System-Design-User-Id")
-	if userIdRaw == "" {
-		http.Error(rw, "no auth", http.StatusUnauthorized)
+func (h *HTTPHandler) HandleGetPost(rw http.ResponseWriter, r *http.Request) {
+	postId := mux.Vars(r)["postId"]
+	if postId == "" {
+		http.Error(rw, "incorrect post id", http.StatusBadRequest)
 		return
 	}
-	userId := schemas.UserId(userIdRaw)
 
-	userSubscriptions, err := h.usersManager.GetUserSubscriptions(r.Context(), userId)
+	postIdBase64, err := schemas.IDFromRawString(postId)
 	if err != nil {
-		http.Error(rw, "failed get subscriptions", http.StatusInternalServerError)
+		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	usersList := schemas.UsersListFromUsers(userSubscriptions)
-	rawResponse, _ := json.Marshal(usersList)
-	rw.Header().Set("Content-Type", "application/json")
-	_, err = rw.Write(rawResponse)
+	post, err := h.Storage.GetPost(r.Context(), postIdBase64)
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusBadRequest)
+		http.Error(rw, err.Error(), http.StatusNotFound)
 		return
 	}
-}
 
-func (h *HTTPHandler) HandleGetUserSubscribers(rw http.ResponseWriter, r *http.Request) {
-	userIdRaw := r.Header.Get("System-Design-User-Id")
-	if userIdRaw == "" {
-		http.Error(rw, "no auth", http.StatusUnauthorized)
+	viewer := auth.UserFromContext(r.Context())
+	if !h.checkPostVisible(rw, r.Context(), viewer, post) {
 		return
 	}
-	userId := schemas.UserId(userIdRaw)
-
-	userSubscribers, err := h.usersManager.GetUserSubscribers(r.Context(), userId)
+	postData, err := h.toPostData(r.Context(), viewer, post)
 	if err != nil {
-		http.Error(rw, "failed get subscribers", http.StatusInternalServerError)
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	usersList := schemas.UsersListFromUsers(userSubscribers)
-	rawResponse, _ := json.Marshal(usersList)
+	rawResponse, _ := json.Marshal(postData)
 	rw.Header().Set("Content-Type", "application/json")
 	_, err = rw.Write(rawResponse)
 	if err != nil {
@@ -269,73 +518,61 @@ func (h *HTTPHandler) HandleGetUserSubscribers(rw http.ResponseWriter, r *http.R
 	}
 }
 
-func (h *HTTPHandler) HandleSubscribeUser(rw http.ResponseWriter, r *http.Request) {
-	userIdRaw := r.Header.Get("System-Design-User-Id")
-	if userIdRaw == "" {
-		http.Error(rw, "no auth", http.StatusUnauthorized)
+func (h *HTTPHandler) HandleGetUserPosts(rw http.ResponseWriter, r *http.Request) {
+	userId := schemas.UserId(mux.Vars(r)["userId"])
+	if userId == "" {
+		http.Error(rw, "blank userId", http.StatusBadRequest)
 		return
 	}
-	userId := schemas.UserId(userIdRaw)
 
-	to := schemas.UserId(mux.Vars(r)["userId"])
-	if to == "" {
-		http.Error(rw, "empty target", http.StatusBadRequest)
+	parsedPageData, err := parsePageData(r.URL.Query())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if userId == to {
-		http.Error(rw, "self-subscriptions not allowed", http.StatusBadRequest)
+	cursor, size, err := plain.CorrectDestruct(parsedPageData)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	err := h.usersManager.MakeSubscription(r.Context(), userId, to)
+	viewer := auth.UserFromContext(r.Context())
+	canView, err := h.usersManager.CanViewPosts(r.Context(), viewer, userId)
 	if err != nil {
-		http.Error(rw, "subscription failed", http.StatusInternalServerError)
+		http.Error(rw, "internal error", http.StatusInternalServerError)
 		return
 	}
-}
-
-func (h *HTTPHandler) HandleGetUserFeed(rw http.ResponseWriter, r *http.Request) {
-	userIdRaw := r.Header.Get("System-Design-User-Id")
-	if userIdRaw == "" {
-		http.Error(rw, "no auth", http.StatusUnauthorized)
+	if !canView {
+		http.Error(rw, "account is private", http.StatusForbidden)
 		return
 	}
-	userId := schemas.UserId(userIdRaw)
 
-	queryParams := r.URL.Query()
-	var parsedPageData plain.GetUserPostsPageData
-	if pageToken := queryParams.Get("page"); pageToken != "" {
-		parsedPageData = plain.GetUserPostsPageData{LastSeenID: pageToken}
-	}
-	if rawSize := queryParams.Get("size"); rawSize != "" {
-		parsedSize, err := strconv.ParseInt(rawSize, 10, 32)
-		if err != nil {
-			http.Error(rw, fmt.Sprintf("invalid page size: %s", err.Error()), http.StatusBadRequest)
-			return
-		}
-		parsedPageData.Size = int(parsedSize)
-	}
-	if parsedPageData.Size == 0 {
-		parsedPageData.Size = plain.DefaultPageSize
-	}
-
-	userFeed, nextPageToken, err := h.usersManager.GetUserFeed(r.Context(), userId, parsedPageData)
+	postList, pageToken, err := h.Storage.GetUserPosts(r.Context(), userId, parsedPageData)
 	if err != nil {
-		http.Error(rw, fmt.Sprintf("failed find feed: %s", err.Error()), http.StatusInternalServerError)
+		http.Error(rw, fmt.Sprintf("failed find posts:%s", err.Error()), http.StatusBadRequest)
 		return
 	}
 
-	response := &GetUserPostsResponse{
-		Posts: make([]schemas.PostData, len(userFeed)),
+	postsData, err := h.toPostsData(r.Context(), viewer, postList)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	for i := range userFeed {
-		response.Posts[i] = userFeed[i].ToPostData()
+	response := GetUserPostsResponse{
+		Posts: postsData,
 	}
 
-	if nextPageToken != nil {
-		nextPageEncoded := nextPageToken.LastSeenID
-		response.NextPage = &nextPageEncoded
+	switch {
+	case cursor != nil && cursor.Newer:
+		// pages of newer posts are followed by the next head only
+		response.setPages(nil, pageToken)
+	case cursor == nil && len(postList) > 0:
+		response.setPages(pageToken, plain.HeadPage(0, postList[0].ID, size, 0))
+	case cursor == nil:
+		response.setPages(pageToken, plain.HeadPageAt(time.Now(), size))
+	default:
+		response.setPages(pageToken, nil)
 	}
 
 	rawResponse, _ := json.Marshal(response)
@@ -347,6 +584,1284 @@ func (h *HTTPHandler) HandleGetUserFeed(rw http.ResponseWriter, r *http.Request)
 	}
 }
 
+func (h *HTTPHandler) HandleGetHashtagPosts(rw http.ResponseWriter, r *http.Request) {
+	hashtag := schemas.NormalizeHashtag(mux.Vars(r)["tag"])
+	if hashtag == "" {
+		http.Error(rw, "blank tag", http.StatusBadRequest)
+		return
+	}
+
+	parsedPageData, err := parsePageData(r.URL.Query())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	postList, nextPageToken, err := h.Storage.GetHashtagPosts(r.Context(), hashtag, parsedPageData)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("failed find posts:%s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	viewer := auth.UserFromContext(r.Context())
+	postList, err = h.hideBlockedPosts(r.Context(), viewer, postList)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	postList, err = h.hideInvisiblePosts(r.Context(), viewer, postList)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	postsData, err := h.toPostsData(r.Context(), viewer, postList)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response := GetUserPostsResponse{
+		Posts: postsData,
+	}
+
+	if nextPageToken != nil {
+		nextPageEncoded := nextPageToken.Token
+		response.NextPage = &nextPageEncoded
+	}
+
+	rawResponse, _ := json.Marshal(response)
+	rw.Header().Set("Content-Type", "application/json")
+	_, err = rw.Write(rawResponse)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func (h *HTTPHandler) HandleSearchPosts(rw http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+	query := plain.SearchQuery{
+		Text:     queryParams.Get("q"),
+		AuthorID: schemas.UserId(queryParams.Get("author")),
+	}
+	if strings.TrimSpace(query.Text) == "" {
+		http.Error(rw, "blank query", http.StatusBadRequest)
+		return
+	}
+
+	parsedPageData, err := parsePageData(queryParams)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	searchPageData := plain.SearchPageData{Cursor: parsedPageData.Token, Size: parsedPageData.Size}
+
+	postList, nextPageToken, err := h.Storage.SearchPosts(r.Context(), query, searchPageData)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("failed search posts:%s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	viewer := auth.UserFromContext(r.Context())
+	postList, err = h.hideBlockedPosts(r.Context(), viewer, postList)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	postList, err = h.hideInvisiblePosts(r.Context(), viewer, postList)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	postsData, err := h.toPostsData(r.Context(), viewer, postList)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response := GetUserPostsResponse{
+		Posts: postsData,
+	}
+
+	if nextPageToken != nil {
+		nextPageEncoded := nextPageToken.Cursor
+		response.NextPage = &nextPageEncoded
+	}
+
+	rawResponse, _ := json.Marshal(response)
+	rw.Header().Set("Content-Type", "application/json")
+	_, err = rw.Write(rawResponse)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// HandleRepost makes a plain repost of the post, or a quote post when the body carries a text
+func (h *HTTPHandler) HandleRepost(rw http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+	if userId == "" {
+		http.Error(rw, "no auth", http.StatusUnauthorized)
+		return
+	}
+
+	postId := mux.Vars(r)["postId"]
+	if postId == "" {
+		http.Error(rw, "incorrect post id", http.StatusBadRequest)
+		return
+	}
+
+	postIdBase64, err := schemas.IDFromRawString(postId)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var data RepostRequestData
+	err = json.NewDecoder(r.Body).Decode(&data)
+	if err != nil && !errors.Is(err, io.EOF) {
+		http.Error(rw, "bad body", http.StatusBadRequest)
+		return
+	}
+
+	if !h.checkAuthorExists(rw, r.Context(), userId) {
+		return
+	}
+	post, err := h.Storage.GetPost(r.Context(), postIdBase64)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !h.checkPostVisible(rw, r.Context(), userId, post) {
+		return
+	}
+
+	var resultPost *schemas.Post
+	if data.Text != "" {
+		quotePost, err := h.Storage.PutPost(r.Context(), userId, schemas.Text(data.Text), plain.PostOptions{QuotedID: &post.ID})
+		if err != nil {
+			http.Error(rw, "internal error", http.StatusInternalServerError)
+			return
+		}
+		resultPost = quotePost
+	} else {
+		err = h.Storage.PutRepost(r.Context(), userId, post.ID)
+		if err != nil {
+			if errors.Is(err, storage.ErrCollision) {
+				http.Error(rw, "already reposted", http.StatusConflict)
+				return
+			}
+			http.Error(rw, "internal error", http.StatusInternalServerError)
+			return
+		}
+		post.RepostedBy = userId
+		resultPost = post
+	}
+
+	postData, err := h.toPostData(r.Context(), userId, resultPost)
+	if err != nil {
+		http.Error(rw, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	rawResponse, _ := json.Marshal(postData)
+	rw.Header().Set("Content-Type", "application/json")
+	_, err = rw.Write(rawResponse)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func (h *HTTPHandler) HandleGetPostReplies(rw http.ResponseWriter, r *http.Request) {
+	postId := mux.Vars(r)["postId"]
+	if postId == "" {
+		http.Error(rw, "incorrect post id", http.StatusBadRequest)
+		return
+	}
+
+	postIdBase64, err := schemas.IDFromRawString(postId)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	parsedPageData, err := parsePageData(r.URL.Query())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	post, err := h.Storage.GetPost(r.Context(), postIdBase64)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	viewer := auth.UserFromContext(r.Context())
+	if !h.checkPostVisible(rw, r.Context(), viewer, post) {
+		return
+	}
+
+	replyList, nextPageToken, err := h.Storage.GetPostReplies(r.Context(), post.ID, parsedPageData)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("failed find replies:%s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	replyList, err = h.hideBlockedPosts(r.Context(), viewer, replyList)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	replyList, err = h.hideInvisiblePosts(r.Context(), viewer, replyList)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	postsData, err := h.toPostsData(r.Context(), viewer, replyList)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response := GetUserPostsResponse{
+		Posts: postsData,
+	}
+
+	if nextPageToken != nil {
+		nextPageEncoded := nextPageToken.Token
+		response.NextPage = &nextPageEncoded
+	}
+
+	rawResponse, _ := json.Marshal(response)
+	rw.Header().Set("Content-Type", "application/json")
+	_, err = rw.Write(rawResponse)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func (h *HTTPHandler) HandleGetPostThread(rw http.ResponseWriter, r *http.Request) {
+	postId := mux.Vars(r)["postId"]
+	if postId == "" {
+		http.Error(rw, "incorrect post id", http.StatusBadRequest)
+		return
+	}
+
+	postIdBase64, err := schemas.IDFromRawString(postId)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	post, err := h.Storage.GetPost(r.Context(), postIdBase64)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	viewer := auth.UserFromContext(r.Context())
+	if !h.checkPostVisible(rw, r.Context(), viewer, post) {
+		return
+	}
+	blocked, err := h.getBlockedSet(r.Context(), viewer)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var threadPosts []*schemas.Post
+	visibility := newPostsVisibility(&h.usersManager, viewer)
+	root, err := h.collectThread(r.Context(), post, blocked, visibility, &threadPosts)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("failed collect thread: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	summaries, err := h.likesStorage.GetLikesSummary(r.Context(), viewer, postIDs(threadPosts))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	thread := root.toThreadData(summaries)
+
+	rawResponse, _ := json.Marshal(thread)
+	rw.Header().Set("Content-Type", "application/json")
+	_, err = rw.Write(rawResponse)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+type threadNode struct {
+	post    *schemas.Post
+	replies []*threadNode
+}
+
+func (tn *threadNode) toThreadData(summaries map[schemas.PostId]schemas.LikesSummary) schemas.PostThreadData {
+	thread := schemas.PostThreadData{
+		Post:    tn.post.ToPostData(),
+		Replies: make([]schemas.PostThreadData, len(tn.replies)),
+	}
+	thread.Post.SetLikes(summaries[tn.post.ID])
+	for i := range tn.replies {
+		thread.Replies[i] = tn.replies[i].toThreadData(summaries)
+	}
+	return thread
+}
+
+// collectThread walks replies depth-first, oldest reply first, and stops
+// descending once maxThreadSize posts have been collected into collected.
+// Replies of blocked users and of private accounts the viewer is not approved
+// for are skipped together with replies to them.
+func (h *HTTPHandler) collectThread(ctx context.Context, root *schemas.Post, blocked map[schemas.UserId]bool, visibility *postsVisibility, collected *[]*schemas.Post) (*threadNode, error) {
+	*collected = append(*collected, root)
+	node := &threadNode{post: root}
+
+	repliesIterator, err := h.Storage.GetAllRepliesToPost(ctx, root.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer repliesIterator.Close(ctx)
+	for p := repliesIterator.GetNextPost(ctx); p != nil && len(*collected) < maxThreadSize; p = repliesIterator.GetNextPost(ctx) {
+		if blocked[p.AuthorID] {
+			continue
+		}
+		canView, err := visibility.canView(ctx, p.AuthorID)
+		if err != nil {
+			return nil, err
+		}
+		if !canView {
+			continue
+		}
+		reply, err := h.collectThread(ctx, p, blocked, visibility, collected)
+		if err != nil {
+			return nil, err
+		}
+		node.replies = append(node.replies, reply)
+	}
+	return node, repliesIterator.Err()
+}
+
+func (h *HTTPHandler) HandleGetPostRevisions(rw http.ResponseWriter, r *http.Request) {
+	postId := mux.Vars(r)["postId"]
+	if postId == "" {
+		http.Error(rw, "incorrect post id", http.StatusBadRequest)
+		return
+	}
+
+	postIdBase64, err := schemas.IDFromRawString(postId)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	parsedPageData, err := parsePageData(r.URL.Query())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	post, err := h.Storage.GetPost(r.Context(), postIdBase64)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if !h.checkPostVisible(rw, r.Context(), auth.UserFromContext(r.Context()), post) {
+		return
+	}
+
+	revisionList, nextPageToken, err := h.Storage.GetPostRevisions(r.Context(), post.ID, parsedPageData)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("failed find revisions:%s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	response := GetPostRevisionsResponse{
+		Revisions: make([]schemas.PostRevisionData, len(revisionList)),
+	}
+	for i, revision := range revisionList {
+		response.Revisions[i] = revision.ToPostRevisionData()
+	}
+
+	if nextPageToken != nil {
+		nextPageEncoded := nextPageToken.Token
+		response.NextPage = &nextPageEncoded
+	}
+
+	rawResponse, _ := json.Marshal(response)
+	rw.Header().Set("Content-Type", "application/json")
+	_, err = rw.Write(rawResponse)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func (h *HTTPHandler) HandleEditPost(rw http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+	if userId == "" {
+		http.Error(rw, "no auth", http.StatusUnauthorized)
+		return
+	}
+
+	postId := mux.Vars(r)["postId"]
+	if postId == "" {
+		http.Error(rw, "incorrect post id", http.StatusBadRequest)
+		return
+	}
+
+	postIdBase64, err := schemas.IDFromRawString(postId)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var data EditPostRequestData
+	err = json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		http.Error(rw, "bad body", http.StatusBadRequest)
+		return
+	}
+
+	text := data.Text
+	if text == "" {
+		http.Error(rw, "text must not be empty", http.StatusBadRequest)
+		return
+	}
+
+	post, err := h.Storage.GetPost(r.Context(), postIdBase64)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if post.AuthorID != userId {
+		http.Error(rw, "you shall not pass", http.StatusForbidden)
+		return
+	}
+
+	editedPost, err := h.Storage.EditPost(r.Context(), post.ID, post.AuthorID, schemas.Text(text))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	postData, err := h.toPostData(r.Context(), userId, editedPost)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rawResponse, _ := json.Marshal(postData)
+	rw.Header().Set("Content-Type", "application/json")
+	_, err = rw.Write(rawResponse)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func (h *HTTPHandler) HandleDeletePost(rw http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+	if userId == "" {
+		http.Error(rw, "no auth", http.StatusUnauthorized)
+		return
+	}
+
+	postId := mux.Vars(r)["postId"]
+	if postId == "" {
+		http.Error(rw, "incorrect post id", http.StatusBadRequest)
+		return
+	}
+
+	postIdBase64, err := schemas.IDFromRawString(postId)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	post, err := h.Storage.GetPost(r.Context(), postIdBase64)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if post.AuthorID != userId {
+		http.Error(rw, "you shall not pass", http.StatusForbidden)
+		return
+	}
+
+	err = h.Storage.DeletePost(r.Context(), post.ID, post.AuthorID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = h.likesStorage.DeletePost(r.Context(), post.ID)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(post.Attachments) != 0 {
+		err = h.mediaManager.ReleasePost(r.Context(), post.ID)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func (h *HTTPHandler) HandleUploadMedia(rw http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+	if userId == "" {
+		http.Error(rw, "no auth", http.StatusUnauthorized)
+		return
+	}
+	if r.ContentLength > media.MaxBlobSize {
+		http.Error(rw, media.ErrTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	attachment, err := h.mediaManager.Upload(r.Context(), userId, r.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, media.ErrTooLarge):
+			http.Error(rw, err.Error(), http.StatusRequestEntityTooLarge)
+		case errors.Is(err, media.ErrUnsupportedType):
+			http.Error(rw, err.Error(), http.StatusUnsupportedMediaType)
+		default:
+			http.Error(rw, "internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	rawResponse, _ := json.Marshal(attachment.ToAttachmentData())
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusCreated)
+	_, err = rw.Write(rawResponse)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func (h *HTTPHandler) HandleGetMedia(rw http.ResponseWriter, r *http.Request) {
+	attachmentId := schemas.AttachmentId(mux.Vars(r)["attachmentId"])
+	if attachmentId == "" {
+		http.Error(rw, "blank attachment id", http.StatusBadRequest)
+		return
+	}
+
+	attachment, content, err := h.mediaManager.Open(r.Context(), attachmentId)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	if !h.checkAttachmentVisible(rw, r.Context(), auth.UserFromContext(r.Context()), attachment) {
+		return
+	}
+	rw.Header().Set("Content-Type", attachment.ContentType)
+	rw.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	_, _ = io.Copy(rw, content)
+}
+
+func (h *HTTPHandler) HandleGetUserSubscriptions(rw http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+	if userId == "" {
+		http.Error(rw, "no auth", http.StatusUnauthorized)
+		return
+	}
+
+	userSubscriptions, err := h.usersManager.GetUserSubscriptions(r.Context(), userId)
+	if err != nil {
+		http.Error(rw, "failed get subscriptions", http.StatusInternalServerError)
+		return
+	}
+
+	usersList := schemas.UsersListFromUsers(userSubscriptions)
+	rawResponse, _ := json.Marshal(usersList)
+	rw.Header().Set("Content-Type", "application/json")
+	_, err = rw.Write(rawResponse)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func (h *HTTPHandler) HandleGetUserSubscribers(rw http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+	if userId == "" {
+		http.Error(rw, "no auth", http.StatusUnauthorized)
+		return
+	}
+
+	userSubscribers, err := h.usersManager.GetUserSubscribers(r.Context(), userId)
+	if err != nil {
+		http.Error(rw, "failed get subscribers", http.StatusInternalServerError)
+		return
+	}
+
+	usersList := schemas.UsersListFromUsers(userSubscribers)
+	rawResponse, _ := json.Marshal(usersList)
+	rw.Header().Set("Content-Type", "application/json")
+	_, err = rw.Write(rawResponse)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func (h *HTTPHandler) HandleSubscribeUser(rw http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+	if userId == "" {
+		http.Error(rw, "no auth", http.StatusUnauthorized)
+		return
+	}
+
+	to := schemas.UserId(mux.Vars(r)["userId"])
+	if to == "" {
+		http.Error(rw, "empty target", http.StatusBadRequest)
+		return
+	}
+
+	if userId == to {
+		http.Error(rw, "self-subscriptions not allowed", http.StatusBadRequest)
+		return
+	}
+
+	requested, err := h.usersManager.MakeSubscription(r.Context(), userId, to)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "user not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, users.ErrBlocked) {
+			http.Error(rw, "you shall not pass", http.StatusForbidden)
+			return
+		}
+		http.Error(rw, "subscription failed", http.StatusInternalServerError)
+		return
+	}
+	if requested {
+		// a private account has to approve the follow request first
+		rw.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (h *HTTPHandler) HandleUnsubscribeUser(rw http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+	if userId == "" {
+		http.Error(rw, "no auth", http.StatusUnauthorized)
+		return
+	}
+
+	from := schemas.UserId(mux.Vars(r)["userId"])
+	if from == "" {
+		http.Error(rw, "empty target", http.StatusBadRequest)
+		return
+	}
+
+	err := h.usersManager.RemoveSubscription(r.Context(), userId, from)
+	if err != nil {
+		http.Error(rw, "unsubscription failed", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *HTTPHandler) HandleGetFollowRequests(rw http.ResponseWriter, r *http.Request) {
+	h.handleRelationList(rw, r, h.usersManager.GetFollowRequests)
+}
+
+func (h *HTTPHandler) HandleApproveFollowRequest(rw http.ResponseWriter, r *http.Request) {
+	h.handleFollowRequestDecision(rw, r, h.usersManager.ApproveFollowRequest)
+}
+
+func (h *HTTPHandler) HandleRejectFollowRequest(rw http.ResponseWriter, r *http.Request) {
+	h.handleFollowRequestDecision(rw, r, h.usersManager.RejectFollowRequest)
+}
+
+func (h *HTTPHandler) handleFollowRequestDecision(rw http.ResponseWriter, r *http.Request, decide func(context.Context, schemas.UserId, schemas.UserId) error) {
+	userId := auth.UserFromContext(r.Context())
+	if userId == "" {
+		http.Error(rw, "no auth", http.StatusUnauthorized)
+		return
+	}
+
+	requester := schemas.UserId(mux.Vars(r)["userId"])
+	if requester == "" {
+		http.Error(rw, "empty requester", http.StatusBadRequest)
+		return
+	}
+
+	err := decide(r.Context(), userId, requester)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "follow request not found", http.StatusNotFound)
+			return
+		}
+		http.Error(rw, "follow request decision failed", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *HTTPHandler) HandleGetBlockedUsers(rw http.ResponseWriter, r *http.Request) {
+	h.handleRelationList(rw, r, h.usersManager.GetBlockedUsers)
+}
+
+func (h *HTTPHandler) HandleBlockUser(rw http.ResponseWriter, r *http.Request) {
+	h.handleRelationChange(rw, r, h.usersManager.Block)
+}
+
+func (h *HTTPHandler) HandleUnblockUser(rw http.ResponseWriter, r *http.Request) {
+	h.handleRelationChange(rw, r, h.usersManager.Unblock)
+}
+
+func (h *HTTPHandler) HandleGetMutedUsers(rw http.ResponseWriter, r *http.Request) {
+	h.handleRelationList(rw, r, h.usersManager.GetMutedUsers)
+}
+
+func (h *HTTPHandler) HandleMuteUser(rw http.ResponseWriter, r *http.Request) {
+	h.handleRelationChange(rw, r, h.usersManager.Mute)
+}
+
+func (h *HTTPHandler) HandleUnmuteUser(rw http.ResponseWriter, r *http.Request) {
+	h.handleRelationChange(rw, r, h.usersManager.Unmute)
+}
+
+func (h *HTTPHandler) handleRelationList(rw http.ResponseWriter, r *http.Request, list func(context.Context, schemas.UserId) ([]schemas.UserId, error)) {
+	userId := auth.UserFromContext(r.Context())
+	if userId == "" {
+		http.Error(rw, "no auth", http.StatusUnauthorized)
+		return
+	}
+
+	targets, err := list(r.Context(), userId)
+	if err != nil {
+		http.Error(rw, "failed get users", http.StatusInternalServerError)
+		return
+	}
+
+	usersList := schemas.UsersListFromUsers(targets)
+	rawResponse, _ := json.Marshal(usersList)
+	rw.Header().Set("Content-Type", "application/json")
+	_, err = rw.Write(rawResponse)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func (h *HTTPHandler) handleRelationChange(rw http.ResponseWriter, r *http.Request, change func(context.Context, schemas.UserId, schemas.UserId) error) {
+	userId := auth.UserFromContext(r.Context())
+	if userId == "" {
+		http.Error(rw, "no auth", http.StatusUnauthorized)
+		return
+	}
+
+	target := schemas.UserId(mux.Vars(r)["userId"])
+	if target == "" {
+		http.Error(rw, "empty target", http.StatusBadRequest)
+		return
+	}
+
+	if userId == target {
+		http.Error(rw, "self-relations not allowed", http.StatusBadRequest)
+		return
+	}
+
+	err := change(r.Context(), userId, target)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(rw, "user not found", http.StatusNotFound)
+			return
+		}
+		http.Error(rw, "relation change failed", http.StatusInternalServerError)
+		return
+	}
+}
+
+// hideBlockedPosts leaves out posts of users the viewer blocked
+func (h *HTTPHandler) hideBlockedPosts(ctx context.Context, viewer schemas.UserId, posts []*schemas.Post) ([]*schemas.Post, error) {
+	blocked, err := h.getBlockedSet(ctx, viewer)
+	if err != nil {
+		return nil, err
+	}
+	if len(blocked) == 0 {
+		return posts, nil
+	}
+
+	visible := make([]*schemas.Post, 0, len(posts))
+	for _, post := range posts {
+		if !blocked[post.AuthorID] {
+			visible = append(visible, post)
+		}
+	}
+	return visible, nil
+}
+
+// hideInvisiblePosts leaves out posts of private accounts the viewer is not approved for
+func (h *HTTPHandler) hideInvisiblePosts(ctx context.Context, viewer schemas.UserId, posts []*schemas.Post) ([]*schemas.Post, error) {
+	visibility := newPostsVisibility(&h.usersManager, viewer)
+	visible := make([]*schemas.Post, 0, len(posts))
+	for _, post := range posts {
+		canView, err := visibility.canView(ctx, post.AuthorID)
+		if err != nil {
+			return nil, err
+		}
+		if canView {
+			visible = append(visible, post)
+		}
+	}
+	return visible, nil
+}
+
+// postsVisibility asks UsersManager.CanViewPosts once per author of a listing
+type postsVisibility struct {
+	usersManager *users.UsersManager
+	viewer       schemas.UserId
+	byAuthor     map[schemas.UserId]bool
+}
+
+func newPostsVisibility(usersManager *users.UsersManager, viewer schemas.UserId) *postsVisibility {
+	return &postsVisibility{
+		usersManager: usersManager,
+		viewer:       viewer,
+		byAuthor:     map[schemas.UserId]bool{},
+	}
+}
+
+func (pv *postsVisibility) canView(ctx context.Context, author schemas.UserId) (bool, error) {
+	canView, ok := pv.byAuthor[author]
+	if ok {
+		return canView, nil
+	}
+	canView, err := pv.usersManager.CanViewPosts(ctx, pv.viewer, author)
+	if err != nil {
+		return false, err
+	}
+	pv.byAuthor[author] = canView
+	return canView, nil
+}
+
+func (h *HTTPHandler) getBlockedSet(ctx context.Context, viewer schemas.UserId) (map[schemas.UserId]bool, error) {
+	if viewer == "" {
+		return nil, nil
+	}
+	blockedUsers, err := h.usersManager.GetBlockedUsers(ctx, viewer)
+	if err != nil {
+		return nil, err
+	}
+	blocked := make(map[schemas.UserId]bool, len(blockedUsers))
+	for _, user := range blockedUsers {
+		blocked[user] = true
+	}
+	return blocked, nil
+}
+
+func (h *HTTPHandler) HandleGetUserFeed(rw http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+	if userId == "" {
+		http.Error(rw, "no auth", http.StatusUnauthorized)
+		return
+	}
+
+	parsedPageData, err := parsePageData(r.URL.Query())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userFeed, nextPageToken, headToken, err := h.feedManager.GetUserFeed(r.Context(), userId, parsedPageData)
+	if errors.Is(err, plain.ErrInvalidToken) {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("failed find feed: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	postsData, err := h.toPostsData(r.Context(), userId, userFeed)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response := &GetUserPostsResponse{
+		Posts: postsData,
+	}
+
+	response.setPages(nextPageToken, headToken)
+
+	rawResponse, _ := json.Marshal(response)
+	rw.Header().Set("Content-Type", "application/json")
+	_, err = rw.Write(rawResponse)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// FeedDeleteEventData is the data of feed stream deletion events
+type FeedDeleteEventData struct {
+	ID string `json:"id"`
+}
+
+// HandleStreamFeed sends feed changes as server-sent events: new posts in feed
+// order, edits and deletions. Ids of post events are feed heads, so a stream
+// resumes from Last-Event-ID after reconnect, or from the head given as cursor.
+// Streams end before the server write timeout, clients reconnect right away.
+func (h *HTTPHandler) HandleStreamFeed(rw http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+	if userId == "" {
+		http.Error(rw, "no auth", http.StatusUnauthorized)
+		return
+	}
+
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), feedStreamDuration)
+	defer cancel()
+
+	// subscribed before the catch up, so no post is missed in between
+	subscription, err := h.feedManager.SubscribeFeedEvents(ctx, userId)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("failed subscribe feed: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer subscription.Close()
+
+	head := r.Header.Get("Last-Event-ID")
+	if head == "" {
+		head = r.URL.Query().Get("cursor")
+	}
+	if head == "" {
+		_, _, headPage, err := h.feedManager.GetUserFeed(ctx, userId, plain.GetUserPostsPageData{Size: 1})
+		if err != nil {
+			http.Error(rw, fmt.Sprintf("failed find feed: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		head = headPage.Token
+	}
+
+	posts, headPage, err := h.getNewerFeed(ctx, userId, head)
+	if errors.Is(err, plain.ErrInvalidToken) {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("failed find feed: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+	_, err = fmt.Fprintf(rw, "retry: %d\n\n", feedStreamRetry.Milliseconds())
+	if err == nil {
+		head, err = h.streamNewerPosts(ctx, rw, userId, posts, headPage)
+	}
+	for err == nil {
+		flusher.Flush()
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-subscription.Events():
+			if !ok {
+				return
+			}
+			head, err = h.streamFeedEvent(ctx, rw, userId, head, event)
+		}
+	}
+	if ctx.Err() == nil {
+		log.Printf("Feed stream of %s failed: %s", userId, err)
+	}
+}
+
+// getNewerFeed reads posts newer than the feed head
+func (h *HTTPHandler) getNewerFeed(ctx context.Context, userId schemas.UserId, head string) ([]*schemas.Post, *plain.GetUserPostsPageData, error) {
+	posts, _, nextHead, err := h.feedManager.GetUserFeed(ctx, userId, plain.GetUserPostsPageData{Token: head, Size: plain.DefaultPageSize})
+	if err != nil {
+		return nil, nil, err
+	}
+	if nextHead == nil {
+		return nil, nil, fmt.Errorf("%w: not a feed head", plain.ErrInvalidToken)
+	}
+	return posts, nextHead, nil
+}
+
+// streamNewerPosts sends posts of the newer feed page and all the pending ones, oldest first.
+// The last post of every page carries the next head as id.
+func (h *HTTPHandler) streamNewerPosts(ctx context.Context, w io.Writer, userId schemas.UserId, posts []*schemas.Post, head *plain.GetUserPostsPageData) (string, error) {
+	for {
+		postsData, err := h.toPostsData(ctx, userId, posts)
+		if err != nil {
+			return "", err
+		}
+		for i := len(postsData) - 1; i >= 0; i-- {
+			id := ""
+			if i == 0 {
+				id = head.Token
+			}
+			err = writeFeedEvent(w, id, feed.FeedEventPost, postsData[i])
+			if err != nil {
+				return "", err
+			}
+		}
+		if head.Pending == 0 {
+			return head.Token, nil
+		}
+
+		posts, head, err = h.getNewerFeed(ctx, userId, head.Token)
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+func (h *HTTPHandler) streamFeedEvent(ctx context.Context, w io.Writer, userId schemas.UserId, head string, event feed.FeedEvent) (string, error) {
+	switch event.Type {
+	case feed.FeedEventPost:
+		posts, headPage, err := h.getNewerFeed(ctx, userId, head)
+		if err != nil {
+			return "", err
+		}
+		return h.streamNewerPosts(ctx, w, userId, posts, headPage)
+	case feed.FeedEventEdit:
+		post, err := h.Storage.GetPost(ctx, event.PostID)
+		if errors.Is(err, storage.ErrNotFound) {
+			return head, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		hidden, err := h.feedManager.GetHiddenAuthors(ctx, userId)
+		if err != nil {
+			return "", err
+		}
+		if hidden[post.AuthorID] {
+			return head, nil
+		}
+		postsData, err := h.toPostsData(ctx, userId, []*schemas.Post{post})
+		if err != nil {
+			return "", err
+		}
+		return head, writeFeedEvent(w, "", feed.FeedEventEdit, postsData[0])
+	case feed.FeedEventDelete:
+		return head, writeFeedEvent(w, "", feed.FeedEventDelete, FeedDeleteEventData{ID: event.PostID.ToBase64URL()})
+	default:
+		return head, nil
+	}
+}
+
+func writeFeedEvent(w io.Writer, id string, event string, data interface{}) error {
+	rawData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		_, err = fmt.Fprintf(w, "id: %s\n", id)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, rawData)
+	return err
+}
+
+func (h *HTTPHandler) HandleGetUserMentions(rw http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+	if userId == "" {
+		http.Error(rw, "no auth", http.StatusUnauthorized)
+		return
+	}
+
+	parsedPageData, err := parsePageData(r.URL.Query())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mentions, nextPageToken, err := h.Storage.GetUserMentions(r.Context(), userId, parsedPageData)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("failed find mentions: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	mentions, err = h.hideBlockedPosts(r.Context(), userId, mentions)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	mentions, err = h.hideInvisiblePosts(r.Context(), userId, mentions)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	postsData, err := h.toPostsData(r.Context(), userId, mentions)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response := &GetUserPostsResponse{
+		Posts: postsData,
+	}
+
+	if nextPageToken != nil {
+		nextPageEncoded := nextPageToken.Token
+		response.NextPage = &nextPageEncoded
+	}
+
+	rawResponse, _ := json.Marshal(response)
+	rw.Header().Set("Content-Type", "application/json")
+	_, err = rw.Write(rawResponse)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func (h *HTTPHandler) HandleLikePost(rw http.ResponseWriter, r *http.Request) {
+	h.handleLikeChange(rw, r, h.likesStorage.PutLike)
+}
+
+func (h *HTTPHandler) HandleUnlikePost(rw http.ResponseWriter, r *http.Request) {
+	h.handleLikeChange(rw, r, h.likesStorage.RemoveLike)
+}
+
+func (h *HTTPHandler) handleLikeChange(rw http.ResponseWriter, r *http.Request, change func(context.Context, schemas.UserId, schemas.PostId) error) {
+	userId := auth.UserFromContext(r.Context())
+	if userId == "" {
+		http.Error(rw, "no auth", http.StatusUnauthorized)
+		return
+	}
+
+	postId := mux.Vars(r)["postId"]
+	if postId == "" {
+		http.Error(rw, "incorrect post id", http.StatusBadRequest)
+		return
+	}
+
+	postIdBase64, err := schemas.IDFromRawString(postId)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	post, err := h.Storage.GetPost(r.Context(), postIdBase64)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if !h.checkPostVisible(rw, r.Context(), userId, post) {
+		return
+	}
+
+	err = change(r.Context(), userId, post.ID)
+	if err != nil {
+		http.Error(rw, "like failed", http.StatusInternalServerError)
+		return
+	}
+
+	postData, err := h.toPostData(r.Context(), userId, post)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rawResponse, _ := json.Marshal(postData)
+	rw.Header().Set("Content-Type", "application/json")
+	_, err = rw.Write(rawResponse)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func (h *HTTPHandler) HandleGetPostLikers(rw http.ResponseWriter, r *http.Request) {
+	postId := mux.Vars(r)["postId"]
+	if postId == "" {
+		http.Error(rw, "incorrect post id", http.StatusBadRequest)
+		return
+	}
+
+	postIdBase64, err := schemas.IDFromRawString(postId)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	post, err := h.Storage.GetPost(r.Context(), postIdBase64)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if !h.checkPostVisible(rw, r.Context(), auth.UserFromContext(r.Context()), post) {
+		return
+	}
+
+	likers, err := h.likesStorage.GetPostLikers(r.Context(), post.ID)
+	if err != nil {
+		http.Error(rw, "failed get likers", http.StatusInternalServerError)
+		return
+	}
+
+	usersList := schemas.UsersListFromUsers(likers)
+	rawResponse, _ := json.Marshal(usersList)
+	rw.Header().Set("Content-Type", "application/json")
+	_, err = rw.Write(rawResponse)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// toPostsData converts posts as they are seen by viewer, viewer may be empty
+func (h *HTTPHandler) toPostsData(ctx context.Context, viewer schemas.UserId, posts []*schemas.Post) ([]schemas.PostData, error) {
+	summaries, err := h.likesStorage.GetLikesSummary(ctx, viewer, postIDs(posts))
+	if err != nil {
+		return nil, err
+	}
+
+	postsData := make([]schemas.PostData, len(posts))
+	for i, post := range posts {
+		postsData[i] = post.ToPostData()
+		postsData[i].SetLikes(summaries[post.ID])
+	}
+	return postsData, nil
+}
+
+func (h *HTTPHandler) toPostData(ctx context.Context, viewer schemas.UserId, post *schemas.Post) (schemas.PostData, error) {
+	postsData, err := h.toPostsData(ctx, viewer, []*schemas.Post{post})
+	if err != nil {
+		return schemas.PostData{}, err
+	}
+	return postsData[0], nil
+}
+
+func postIDs(posts []*schemas.Post) []schemas.PostId {
+	ids := make([]schemas.PostId, len(posts))
+	for i := range posts {
+		ids[i] = posts[i].ID
+	}
+	return ids
+}
+
 func (h *HTTPHandler) HandlePing(rw http.ResponseWriter, r *http.Request) {
 	rw.WriteHeader(http.StatusOK)
 }
@@ -0,0 +1,692 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"netwitter/auth"
+	"netwitter/feed"
+	"netwitter/likes"
+	"netwitter/media"
+	"netwitter/plain"
+	"netwitter/schemas"
+	"netwitter/storage"
+	"netwitter/storage/inmemory"
+	"netwitter/users"
+	"netwitter/workers"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pngHeader is enough for content sniffing to take an upload for an image
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+// testEnv serves a handler with all storages in memory, fan-out tasks are queued but never executed
+type testEnv struct {
+	handler      *HTTPHandler
+	usersManager *users.UsersManager
+	mediaManager *media.MediaManager
+	feedManager  *feed.FeedManager
+	events       *trackedEvents
+	credentials  *flakyCredentials
+}
+
+// flakyCredentials fails to store credentials while failing is set
+type flakyCredentials struct {
+	*auth.MemoryCredentialsStorage
+	failing bool
+}
+
+func (s *flakyCredentials) PutCredentials(ctx context.Context, credentials schemas.Credentials) error {
+	if s.failing {
+		return errors.New("credentials storage is down")
+	}
+	return s.MemoryCredentialsStorage.PutCredentials(ctx, credentials)
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	scheduler := workers.NewLocalScheduler()
+	postsStorage := inmemory.NewInMemoryStorage(scheduler)
+	usersStorage := users.NewInMemoryStorage()
+	usersManager := users.NewUsersManager(usersStorage, users.NewInMemoryProfilesStorage(), scheduler)
+	events := &trackedEvents{LocalEventBus: feed.NewLocalEventBus()}
+	feedManager := feed.NewFeedManager(postsStorage, usersStorage, feed.NewInMemoryStorage(), events, 10000)
+	mediaManager := media.NewMediaManager(media.NewLocalBlobStore(t.TempDir()), media.NewInMemoryStorage())
+	credentials := &flakyCredentials{MemoryCredentialsStorage: auth.NewInMemoryStorage()}
+	authManager := auth.NewAuthManager(credentials, []byte("test secret"), time.Hour)
+	return &testEnv{
+		handler:      NewHTTPHandler(postsStorage, *usersManager, feedManager, likes.NewInMemoryStorage(), mediaManager, authManager),
+		usersManager: usersManager,
+		mediaManager: mediaManager,
+		feedManager:  feedManager,
+		events:       events,
+		credentials:  credentials,
+	}
+}
+
+func (e *testEnv) register(t *testing.T, userId schemas.UserId, private bool) {
+	t.Helper()
+	ctx := context.Background()
+	_, err := e.usersManager.Register(ctx, schemas.User{ID: userId})
+	if err != nil {
+		t.Fatalf("register %s: %v", userId, err)
+	}
+	if private {
+		_, err = e.usersManager.UpdateProfile(ctx, userId, plain.ProfileUpdate{Private: &private})
+		if err != nil {
+			t.Fatalf("make %s private: %v", userId, err)
+		}
+	}
+}
+
+// follow subscribes the follower, approving the request when the account is private
+func (e *testEnv) follow(t *testing.T, follower schemas.UserId, author schemas.UserId) {
+	t.Helper()
+	ctx := context.Background()
+	requested, err := e.usersManager.MakeSubscription(ctx, follower, author)
+	if err != nil {
+		t.Fatalf("subscribe %s to %s: %v", follower, author, err)
+	}
+	if requested {
+		err = e.usersManager.ApproveFollowRequest(ctx, author, follower)
+		if err != nil {
+			t.Fatalf("approve %s for %s: %v", follower, author, err)
+		}
+	}
+}
+
+func (e *testEnv) upload(t *testing.T, owner schemas.UserId) schemas.AttachmentId {
+	t.Helper()
+	attachment, err := e.mediaManager.Upload(context.Background(), owner, bytes.NewReader(pngHeader))
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	return attachment.ID
+}
+
+// serve calls the handler as the viewer, an empty viewer is anonymous
+func serve(handle http.HandlerFunc, method string, target string, viewer schemas.UserId, vars map[string]string, body interface{}) *httptest.ResponseRecorder {
+	var rawBody io.Reader
+	if body != nil {
+		encoded, _ := json.Marshal(body)
+		rawBody = bytes.NewReader(encoded)
+	}
+	r := httptest.NewRequest(method, target, rawBody)
+	if viewer != "" {
+		r = r.WithContext(auth.WithUser(r.Context(), viewer))
+	}
+	r = mux.SetURLVars(r, vars)
+	rw := httptest.NewRecorder()
+	handle(rw, r)
+	return rw
+}
+
+func (e *testEnv) createPost(t *testing.T, author schemas.UserId, data CreatePostRequestData) schemas.PostData {
+	t.Helper()
+	rw := serve(e.handler.HandleCreatePost, http.MethodPost, "/api/v1/posts", author, nil, data)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("create post: %d %s", rw.Code, rw.Body.String())
+	}
+	var post schemas.PostData
+	err := json.Unmarshal(rw.Body.Bytes(), &post)
+	if err != nil {
+		t.Fatalf("decode post: %v", err)
+	}
+	return post
+}
+
+func (e *testEnv) getMedia(viewer schemas.UserId, attachmentId schemas.AttachmentId) int {
+	vars := map[string]string{"attachmentId": string(attachmentId)}
+	return serve(e.handler.HandleGetMedia, http.MethodGet, "/api/v1/media/"+string(attachmentId), viewer, vars, nil).Code
+}
+
+func TestRegisterRetryAfterCredentialsFailure(t *testing.T) {
+	env := newTestEnv(t)
+	data := RegisterUserRequestData{Handle: "alice", Password: "correct horse battery"}
+
+	env.credentials.failing = true
+	rw := serve(env.handler.HandleRegisterUser, http.MethodPost, "/api/v1/users", "", nil, data)
+	if rw.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d %s", rw.Code, rw.Body.String())
+	}
+	_, err := env.usersManager.GetProfile(context.Background(), "alice")
+	if err == nil {
+		t.Error("expected the profile to be removed")
+	}
+
+	env.credentials.failing = false
+	rw = serve(env.handler.HandleRegisterUser, http.MethodPost, "/api/v1/users", "", nil, data)
+	if rw.Code != http.StatusCreated {
+		t.Fatalf("expected the retry to register, got %d %s", rw.Code, rw.Body.String())
+	}
+}
+
+func TestCreatePostAttachmentRace(t *testing.T) {
+	env := newTestEnv(t)
+	env.register(t, "author", false)
+	attachmentId := env.upload(t, "author")
+
+	const racers = 8
+	codes := make([]int, racers)
+	var wg sync.WaitGroup
+	for i := 0; i < racers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data := CreatePostRequestData{Text: "mine", AttachmentIDs: []string{string(attachmentId)}}
+			codes[i] = serve(env.handler.HandleCreatePost, http.MethodPost, "/api/v1/posts", "author", nil, data).Code
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusOK:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("expected created or conflict, got %d", code)
+		}
+	}
+	if created != 1 {
+		t.Errorf("exactly one post must take the attachment, %d did", created)
+	}
+
+	posts, _, err := env.handler.Storage.GetUserPosts(context.Background(), "author", plain.GetUserPostsPageData{})
+	if err != nil {
+		t.Fatalf("get posts: %v", err)
+	}
+	if len(posts) != 1 {
+		t.Errorf("losers must not publish posts, got %d posts", len(posts))
+	}
+}
+
+func TestGetMediaOfPrivatePost(t *testing.T) {
+	env := newTestEnv(t)
+	env.register(t, "author", true)
+	env.register(t, "follower", false)
+	env.register(t, "stranger", false)
+	env.follow(t, "follower", "author")
+	attachmentId := env.upload(t, "author")
+	env.createPost(t, "author", CreatePostRequestData{AttachmentIDs: []string{string(attachmentId)}})
+
+	cases := []struct {
+		viewer schemas.UserId
+		code   int
+	}{
+		{"author", http.StatusOK},
+		{"follower", http.StatusOK},
+		{"stranger", http.StatusNotFound},
+		{"", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		if code := env.getMedia(c.viewer, attachmentId); code != c.code {
+			t.Errorf("viewer %q: expected %d, got %d", c.viewer, c.code, code)
+		}
+	}
+}
+
+func TestGetMediaNotInPost(t *testing.T) {
+	env := newTestEnv(t)
+	env.register(t, "author", false)
+	attachmentId := env.upload(t, "author")
+
+	if code := env.getMedia("author", attachmentId); code != http.StatusOK {
+		t.Errorf("owner must see own upload, got %d", code)
+	}
+	if code := env.getMedia("stranger", attachmentId); code != http.StatusNotFound {
+		t.Errorf("upload no post took must be hidden, got %d", code)
+	}
+
+	post := env.createPost(t, "author", CreatePostRequestData{AttachmentIDs: []string{string(attachmentId)}})
+	if code := env.getMedia("stranger", attachmentId); code != http.StatusOK {
+		t.Errorf("attachment of a public post must be seen, got %d", code)
+	}
+	rw := serve(env.handler.HandleDeletePost, http.MethodDelete, "/api/v1/posts/"+post.ID, "author", map[string]string{"postId": post.ID}, nil)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("delete post: %d %s", rw.Code, rw.Body.String())
+	}
+	if code := env.getMedia("stranger", attachmentId); code != http.StatusNotFound {
+		t.Errorf("attachment of a deleted post must be hidden, got %d", code)
+	}
+}
+
+func TestGetMediaAvatar(t *testing.T) {
+	env := newTestEnv(t)
+	env.register(t, "author", true)
+	attachmentId := env.upload(t, "author")
+	_, err := env.usersManager.UpdateProfile(context.Background(), "author", plain.ProfileUpdate{AvatarID: &attachmentId})
+	if err != nil {
+		t.Fatalf("update profile: %v", err)
+	}
+	err = env.mediaManager.SetAvatar(context.Background(), "author", &attachmentId)
+	if err != nil {
+		t.Fatalf("set avatar: %v", err)
+	}
+
+	if code := env.getMedia("stranger", attachmentId); code != http.StatusOK {
+		t.Errorf("avatars are public, got %d", code)
+	}
+}
+
+// postIds reads ids of the posts a listing replied with
+func postIds(t *testing.T, rw *httptest.ResponseRecorder) []string {
+	t.Helper()
+	if rw.Code != http.StatusOK {
+		t.Fatalf("list posts: %d %s", rw.Code, rw.Body.String())
+	}
+	var response GetUserPostsResponse
+	err := json.Unmarshal(rw.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("decode posts: %v", err)
+	}
+	ids := make([]string, len(response.Posts))
+	for i, post := range response.Posts {
+		ids[i] = post.ID
+	}
+	return ids
+}
+
+func (e *testEnv) getHashtagPosts(t *testing.T, viewer schemas.UserId, tag string) []string {
+	t.Helper()
+	return postIds(t, serve(e.handler.HandleGetHashtagPosts, http.MethodGet, "/api/v1/hashtags/"+tag, viewer, map[string]string{"tag": tag}, nil))
+}
+
+func (e *testEnv) searchPosts(t *testing.T, viewer schemas.UserId, query string) []string {
+	t.Helper()
+	return postIds(t, serve(e.handler.HandleSearchPosts, http.MethodGet, "/api/v1/search?q="+query, viewer, nil, nil))
+}
+
+func (e *testEnv) getMentions(t *testing.T, viewer schemas.UserId) []string {
+	t.Helper()
+	return postIds(t, serve(e.handler.HandleGetUserMentions, http.MethodGet, "/api/v1/mentions", viewer, nil, nil))
+}
+
+func assertPostIds(t *testing.T, what string, ids []string, expected ...string) {
+	t.Helper()
+	if len(ids) != len(expected) {
+		t.Errorf("%s: expected posts %v, got %v", what, expected, ids)
+		return
+	}
+	for i := range expected {
+		if ids[i] != expected[i] {
+			t.Errorf("%s: expected posts %v, got %v", what, expected, ids)
+			return
+		}
+	}
+}
+
+func TestHashtagAndSearchHideBlocked(t *testing.T) {
+	env := newTestEnv(t)
+	env.register(t, "reader", false)
+	env.register(t, "blocked", false)
+	env.register(t, "author", false)
+	blockedPost := env.createPost(t, "blocked", CreatePostRequestData{Text: "hello #topic"})
+	post := env.createPost(t, "author", CreatePostRequestData{Text: "hello #topic"})
+
+	assertPostIds(t, "hashtag", env.getHashtagPosts(t, "reader", "topic"), post.ID, blockedPost.ID)
+	err := env.usersManager.Block(context.Background(), "reader", "blocked")
+	if err != nil {
+		t.Fatalf("block: %v", err)
+	}
+
+	assertPostIds(t, "hashtag", env.getHashtagPosts(t, "reader", "topic"), post.ID)
+	assertPostIds(t, "search", env.searchPosts(t, "reader", "hello"), post.ID)
+	assertPostIds(t, "anonymous search", env.searchPosts(t, "", "hello"), post.ID, blockedPost.ID)
+}
+
+func TestPrivatePostReadPaths(t *testing.T) {
+	env := newTestEnv(t)
+	env.register(t, "author", true)
+	env.register(t, "follower", false)
+	env.register(t, "stranger", false)
+	env.follow(t, "follower", "author")
+	post := env.createPost(t, "author", CreatePostRequestData{Text: "secret #topic @follower @stranger"})
+	env.createPost(t, "author", CreatePostRequestData{Text: "reply", ParentID: post.ID})
+
+	postPaths := []struct {
+		name   string
+		handle http.HandlerFunc
+		method string
+		suffix string
+	}{
+		{"replies", env.handler.HandleGetPostReplies, http.MethodGet, "/replies"},
+		{"thread", env.handler.HandleGetPostThread, http.MethodGet, "/thread"},
+		{"revisions", env.handler.HandleGetPostRevisions, http.MethodGet, "/revisions"},
+		{"likers", env.handler.HandleGetPostLikers, http.MethodGet, "/likes"},
+		{"like", env.handler.HandleLikePost, http.MethodPut, "/like"},
+		{"unlike", env.handler.HandleUnlikePost, http.MethodDelete, "/like"},
+	}
+	vars := map[string]string{"postId": post.ID}
+	for _, path := range postPaths {
+		target := "/api/v1/posts/" + post.ID + path.suffix
+		if rw := serve(path.handle, path.method, target, "follower", vars, nil); rw.Code != http.StatusOK {
+			t.Errorf("%s: follower expected %d, got %d %s", path.name, http.StatusOK, rw.Code, rw.Body.String())
+		}
+		for _, viewer := range []schemas.UserId{"stranger", ""} {
+			rw := serve(path.handle, path.method, target, viewer, vars, nil)
+			if rw.Code != http.StatusNotFound && !(viewer == "" && rw.Code == http.StatusUnauthorized) {
+				t.Errorf("%s: viewer %q expected %d, got %d", path.name, viewer, http.StatusNotFound, rw.Code)
+			}
+		}
+	}
+
+	rw := serve(env.handler.HandleCreatePost, http.MethodPost, "/api/v1/posts", "stranger", nil, CreatePostRequestData{Text: "reply", ParentID: post.ID})
+	if rw.Code != http.StatusBadRequest {
+		t.Errorf("reply to a hidden post: expected %d, got %d", http.StatusBadRequest, rw.Code)
+	}
+
+	assertPostIds(t, "follower hashtag", env.getHashtagPosts(t, "follower", "topic"), post.ID)
+	assertPostIds(t, "stranger hashtag", env.getHashtagPosts(t, "stranger", "topic"))
+	assertPostIds(t, "anonymous hashtag", env.getHashtagPosts(t, "", "topic"))
+	assertPostIds(t, "follower search", env.searchPosts(t, "follower", "secret"), post.ID)
+	assertPostIds(t, "stranger search", env.searchPosts(t, "stranger", "secret"))
+	assertPostIds(t, "anonymous search", env.searchPosts(t, "", "secret"))
+	assertPostIds(t, "follower mentions", env.getMentions(t, "follower"), post.ID)
+	assertPostIds(t, "stranger mentions", env.getMentions(t, "stranger"))
+}
+
+func TestPrivateRepliesToPublicPost(t *testing.T) {
+	env := newTestEnv(t)
+	env.register(t, "author", false)
+	env.register(t, "private", true)
+	env.register(t, "stranger", false)
+	env.follow(t, "private", "author")
+	post := env.createPost(t, "author", CreatePostRequestData{Text: "public"})
+	env.createPost(t, "private", CreatePostRequestData{Text: "hidden reply", ParentID: post.ID})
+	publicReply := env.createPost(t, "author", CreatePostRequestData{Text: "public reply", ParentID: post.ID})
+
+	vars := map[string]string{"postId": post.ID}
+	replies := serve(env.handler.HandleGetPostReplies, http.MethodGet, "/api/v1/posts/"+post.ID+"/replies", "stranger", vars, nil)
+	assertPostIds(t, "replies", postIds(t, replies), publicReply.ID)
+
+	rw := serve(env.handler.HandleGetPostThread, http.MethodGet, "/api/v1/posts/"+post.ID+"/thread", "stranger", vars, nil)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("thread: %d %s", rw.Code, rw.Body.String())
+	}
+	var thread schemas.PostThreadData
+	err := json.Unmarshal(rw.Body.Bytes(), &thread)
+	if err != nil {
+		t.Fatalf("decode thread: %v", err)
+	}
+	if len(thread.Replies) != 1 || thread.Replies[0].Post.ID != publicReply.ID {
+		t.Errorf("thread must hold only the public reply, got %d replies", len(thread.Replies))
+	}
+}
+
+// trackedReplies counts reply iterators left open and fails them while failing is set
+type trackedReplies struct {
+	storage.Storage
+	open    int
+	failing bool
+}
+
+type trackedIterator struct {
+	plain.PostsIterator
+	replies *trackedReplies
+}
+
+func (i *trackedIterator) GetNextPost(ctx context.Context) *schemas.Post {
+	if i.replies.failing {
+		return nil
+	}
+	return i.PostsIterator.GetNextPost(ctx)
+}
+
+func (i *trackedIterator) Err() error {
+	if i.replies.failing {
+		return errors.New("replies cursor failed")
+	}
+	return i.PostsIterator.Err()
+}
+
+func (i *trackedIterator) Close(ctx context.Context) error {
+	i.replies.open--
+	return i.PostsIterator.Close(ctx)
+}
+
+func (s *trackedReplies) GetAllRepliesToPost(ctx context.Context, postId schemas.PostId) (plain.PostsIterator, error) {
+	iterator, err := s.Storage.GetAllRepliesToPost(ctx, postId)
+	if err != nil {
+		return nil, err
+	}
+	s.open++
+	return &trackedIterator{PostsIterator: iterator, replies: s}, nil
+}
+
+func TestThreadClosesRepliesIterators(t *testing.T) {
+	env := newTestEnv(t)
+	env.register(t, "author", false)
+	post := env.createPost(t, "author", CreatePostRequestData{Text: "root"})
+	reply := env.createPost(t, "author", CreatePostRequestData{Text: "reply", ParentID: post.ID})
+	env.createPost(t, "author", CreatePostRequestData{Text: "nested", ParentID: reply.ID})
+	env.createPost(t, "author", CreatePostRequestData{Text: "other reply", ParentID: post.ID})
+	replies := &trackedReplies{Storage: env.handler.Storage}
+	env.handler.Storage = replies
+
+	vars := map[string]string{"postId": post.ID}
+	rw := serve(env.handler.HandleGetPostThread, http.MethodGet, "/api/v1/posts/"+post.ID+"/thread", "author", vars, nil)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("thread: %d %s", rw.Code, rw.Body.String())
+	}
+	if replies.open != 0 {
+		t.Errorf("expected all replies iterators closed, %d are open", replies.open)
+	}
+
+	replies.failing = true
+	rw = serve(env.handler.HandleGetPostThread, http.MethodGet, "/api/v1/posts/"+post.ID+"/thread", "author", vars, nil)
+	if rw.Code != http.StatusInternalServerError {
+		t.Errorf("expected a failed replies cursor to fail the thread, got %d", rw.Code)
+	}
+	if replies.open != 0 {
+		t.Errorf("expected failed replies iterators closed, %d are open", replies.open)
+	}
+}
+
+// trackedEvents counts feed event subscriptions not closed yet
+type trackedEvents struct {
+	*feed.LocalEventBus
+	mu   sync.Mutex
+	open int
+}
+
+type trackedSubscription struct {
+	feed.EventSubscription
+	events    *trackedEvents
+	closeOnce sync.Once
+}
+
+func (b *trackedEvents) Subscribe(ctx context.Context, channels []string) (feed.EventSubscription, error) {
+	subscription, err := b.LocalEventBus.Subscribe(ctx, channels)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	b.open++
+	b.mu.Unlock()
+	return &trackedSubscription{EventSubscription: subscription, events: b}, nil
+}
+
+func (b *trackedEvents) openSubscriptions() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open
+}
+
+func (s *trackedSubscription) Close() error {
+	s.closeOnce.Do(func() {
+		s.events.mu.Lock()
+		s.events.open--
+		s.events.mu.Unlock()
+	})
+	return s.EventSubscription.Close()
+}
+
+type sseEvent struct {
+	id    string
+	event string
+	data  string
+}
+
+// feedStream is a feed stream served to the viewer, done is closed once the handler returns
+type feedStream struct {
+	events <-chan sseEvent
+	cancel context.CancelFunc
+	done   <-chan struct{}
+}
+
+func (e *testEnv) streamFeed(t *testing.T, viewer schemas.UserId) *feedStream {
+	t.Helper()
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		e.handler.HandleStreamFeed(rw, r.WithContext(auth.WithUser(r.Context(), viewer)))
+	}))
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/v1/feed/stream", nil)
+	if err != nil {
+		t.Fatalf("stream request: %v", err)
+	}
+	response, err := server.Client().Do(r)
+	if err != nil {
+		t.Fatalf("stream feed: %v", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("stream feed: %d", response.StatusCode)
+	}
+
+	events := make(chan sseEvent)
+	go func() {
+		defer response.Body.Close()
+		defer close(events)
+		lines := bufio.NewScanner(response.Body)
+		var event sseEvent
+		for lines.Scan() {
+			line := lines.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				event.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				event.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				event.data = strings.TrimPrefix(line, "data: ")
+			case line == "" && event.event != "":
+				events <- event
+				event = sseEvent{}
+			}
+		}
+	}()
+	return &feedStream{events: events, cancel: cancel, done: done}
+}
+
+func (s *feedStream) next(t *testing.T, expectedType string) sseEvent {
+	t.Helper()
+	select {
+	case event, ok := <-s.events:
+		if !ok {
+			t.Fatalf("expected %s event, the stream ended", expectedType)
+		}
+		if event.event != expectedType {
+			t.Fatalf("expected %s event, got %s %s", expectedType, event.event, event.data)
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatalf("expected %s event, got none", expectedType)
+	}
+	return sseEvent{}
+}
+
+func decodeEventPost(t *testing.T, event sseEvent) schemas.PostData {
+	t.Helper()
+	var post schemas.PostData
+	err := json.Unmarshal([]byte(event.data), &post)
+	if err != nil {
+		t.Fatalf("decode %s event: %v", event.event, err)
+	}
+	return post
+}
+
+func TestStreamFeedEvents(t *testing.T) {
+	env := newTestEnv(t)
+	env.register(t, "author", false)
+	env.register(t, "reader", false)
+	env.follow(t, "reader", "author")
+	ctx := context.Background()
+	stream := env.streamFeed(t, "reader")
+
+	// fan-out tasks are never executed, posts are spread like they do
+	created := env.createPost(t, "author", CreatePostRequestData{Text: "hello"})
+	postId, err := schemas.IDFromRawString(created.ID)
+	if err != nil {
+		t.Fatalf("post id: %v", err)
+	}
+	if err = env.feedManager.SpreadPostOverSubscribers(ctx, "author", postId); err != nil {
+		t.Fatalf("spread post: %v", err)
+	}
+	event := stream.next(t, feed.FeedEventPost)
+	if post := decodeEventPost(t, event); post.ID != created.ID {
+		t.Errorf("expected post %s, got %s", created.ID, post.ID)
+	}
+	if event.id == "" {
+		t.Error("post event must carry the feed head")
+	}
+
+	vars := map[string]string{"postId": created.ID}
+	rw := serve(env.handler.HandleEditPost, http.MethodPatch, "/api/v1/posts/"+created.ID, "author", vars, EditPostRequestData{Text: "edited"})
+	if rw.Code != http.StatusOK {
+		t.Fatalf("edit post: %d %s", rw.Code, rw.Body.String())
+	}
+	if err = env.feedManager.SpreadPostOverSubscribers(ctx, "author", postId); err != nil {
+		t.Fatalf("spread edit: %v", err)
+	}
+	edited := decodeEventPost(t, stream.next(t, feed.FeedEventEdit))
+	if edited.ID != created.ID || edited.Content != "edited" {
+		t.Errorf("expected post %s edited, got %s with %q", created.ID, edited.ID, edited.Content)
+	}
+
+	rw = serve(env.handler.HandleDeletePost, http.MethodDelete, "/api/v1/posts/"+created.ID, "author", vars, nil)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("delete post: %d %s", rw.Code, rw.Body.String())
+	}
+	if err = env.feedManager.RetractPostFromSubscribers(ctx, "author", postId); err != nil {
+		t.Fatalf("retract post: %v", err)
+	}
+	var deleted FeedDeleteEventData
+	if err = json.Unmarshal([]byte(stream.next(t, feed.FeedEventDelete).data), &deleted); err != nil {
+		t.Fatalf("decode delete event: %v", err)
+	}
+	if deleted.ID != created.ID {
+		t.Errorf("expected post %s deleted, got %s", created.ID, deleted.ID)
+	}
+}
+
+func TestStreamFeedEndsOnDisconnect(t *testing.T) {
+	env := newTestEnv(t)
+	env.register(t, "reader", false)
+	stream := env.streamFeed(t, "reader")
+	if open := env.events.openSubscriptions(); open != 1 {
+		t.Fatalf("expected the stream subscribed, %d subscriptions are open", open)
+	}
+
+	stream.cancel()
+	select {
+	case <-stream.done:
+	case <-time.After(time.Second):
+		t.Fatal("stream must end once the client is gone")
+	}
+	if open := env.events.openSubscriptions(); open != 0 {
+		t.Errorf("expected the stream subscription closed, %d are open", open)
+	}
+}
@@ -0,0 +1,187 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"log"
+	"net/http"
+	"netwitter/auth"
+	"time"
+)
+
+const (
+	KeyHeader      = "Idempotency-Key"
+	ReplayedHeader = "Idempotent-Replayed"
+
+	maxKeyLength = 255
+	maxBodySize  = 1 << 20
+	// pendingTTL outlives the server write timeout, so a claim of a lost request expires on its own
+	pendingTTL   = 30 * time.Second
+	waitInterval = 50 * time.Millisecond
+)
+
+// waitTimeout stays under the server write timeout
+var waitTimeout = 10 * time.Second
+
+// Middleware runs Handler for requests to the routes, keyed like "POST /api/v1/posts".
+// It goes after auth.Middleware and before rate limiting, so replays do not use up limits.
+func Middleware(store Store, ttl time.Duration, routes map[string]bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		idempotent := Handler(store, ttl, next.ServeHTTP)
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			route := mux.CurrentRoute(r)
+			if route == nil {
+				next.ServeHTTP(rw, r)
+				return
+			}
+			pathTemplate, err := route.GetPathTemplate()
+			if err != nil || !routes[r.Method+" "+pathTemplate] {
+				next.ServeHTTP(rw, r)
+				return
+			}
+			idempotent(rw, r)
+		})
+	}
+}
+
+// Handler makes requests of a user with the same Idempotency-Key run once: retries get
+// the stored response of the first request for ttl, duplicates coming while it is in
+// flight wait for it. A key reused with another request is rejected with 422.
+// Failed and rate limited requests are not stored, they may be retried with the same key.
+// Requests without the key or without a user go straight to next.
+func Handler(store Store, ttl time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(KeyHeader)
+		userId := auth.UserFromContext(r.Context())
+		if key == "" || userId == "" {
+			next(rw, r)
+			return
+		}
+		if len(key) > maxKeyLength {
+			http.Error(rw, "idempotency key is too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+		if err != nil {
+			http.Error(rw, "bad body", http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxBodySize {
+			http.Error(rw, "body is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		storeKey := string(userId) + ":" + key
+		claim := Record{
+			Owner:       primitive.NewObjectID().Hex(),
+			Fingerprint: fingerprint(r, body),
+		}
+		record, err := waitForRecord(r, store, storeKey, claim)
+		if err != nil {
+			log.Printf("Idempotency store failed: %s", err)
+			http.Error(rw, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if record != nil {
+			replay(rw, claim, record)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: rw, status: http.StatusOK}
+		next(recorder, r)
+
+		// the context of a finished request may be canceled already
+		ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+		defer cancel()
+		if recorder.status >= http.StatusInternalServerError || recorder.status == http.StatusTooManyRequests {
+			err = store.Abandon(ctx, storeKey, claim.Owner)
+		} else {
+			claim.Status = recorder.status
+			claim.ContentType = recorder.Header().Get("Content-Type")
+			claim.Body = recorder.body.Bytes()
+			err = store.Complete(ctx, storeKey, claim, ttl)
+		}
+		if err != nil {
+			log.Printf("Idempotency store failed: %s", err)
+		}
+	}
+}
+
+// waitForRecord claims the key and returns nil, or returns the record of a finished
+// request with the key. Duplicates in flight are waited for, abandoned claims are retaken.
+func waitForRecord(r *http.Request, store Store, key string, claim Record) (*Record, error) {
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		record, err := store.Begin(r.Context(), key, claim, pendingTTL)
+		if err != nil {
+			return nil, err
+		}
+		if record == nil || record.Done || record.Fingerprint != claim.Fingerprint || time.Now().After(deadline) {
+			return record, nil
+		}
+
+		select {
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		case <-time.After(waitInterval):
+		}
+	}
+}
+
+func replay(rw http.ResponseWriter, claim Record, record *Record) {
+	if record.Fingerprint != claim.Fingerprint {
+		http.Error(rw, "idempotency key is used with another request", http.StatusUnprocessableEntity)
+		return
+	}
+	if !record.Done {
+		http.Error(rw, "request with the idempotency key is in progress", http.StatusConflict)
+		return
+	}
+
+	if record.ContentType != "" {
+		rw.Header().Set("Content-Type", record.ContentType)
+	}
+	rw.Header().Set(ReplayedHeader, "true")
+	rw.WriteHeader(record.Status)
+	_, err := rw.Write(record.Body)
+	if err != nil {
+		log.Printf("Idempotent replay failed: %s", err)
+	}
+}
+
+// fingerprint tells requests apart, a key stays bound to the route it was first used with
+func fingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	_, _ = io.WriteString(hash, r.Method+" "+r.URL.Path+"\n")
+	_, _ = hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder passes the response through and keeps a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if !rr.wroteHeader {
+		rr.status = status
+		rr.wroteHeader = true
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(data []byte) (int, error) {
+	rr.wroteHeader = true
+	rr.body.Write(data)
+	return rr.ResponseWriter.Write(data)
+}
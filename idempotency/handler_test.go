@@ -0,0 +1,209 @@
+package idempotency
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"netwitter/auth"
+	"netwitter/ratelimit"
+	"netwitter/schemas"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingHandler replies with the number of its call, or with the status of statuses at that call
+type countingHandler struct {
+	calls    int32
+	statuses []int
+}
+
+func (h *countingHandler) serve(rw http.ResponseWriter, r *http.Request) {
+	call := int(atomic.AddInt32(&h.calls, 1))
+	status := http.StatusCreated
+	if call <= len(h.statuses) {
+		status = h.statuses[call-1]
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	_, _ = fmt.Fprintf(rw, `{"call":%d}`, call)
+}
+
+func (h *countingHandler) assertCalls(t *testing.T, expected int) {
+	t.Helper()
+	if calls := int(atomic.LoadInt32(&h.calls)); calls != expected {
+		t.Errorf("expected %d handler calls, got %d", expected, calls)
+	}
+}
+
+func send(handler http.Handler, userId schemas.UserId, target string, key string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	if key != "" {
+		r.Header.Set(KeyHeader, key)
+	}
+	if userId != "" {
+		r = r.WithContext(auth.WithUser(r.Context(), userId))
+	}
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, r)
+	return rw
+}
+
+func assertResponse(t *testing.T, rw *httptest.ResponseRecorder, status int, body string, replayed bool) {
+	t.Helper()
+	if rw.Code != status {
+		t.Fatalf("expected %d, got %d %s", status, rw.Code, rw.Body.String())
+	}
+	if body != "" && rw.Body.String() != body {
+		t.Errorf("expected body %s, got %s", body, rw.Body.String())
+	}
+	if (rw.Header().Get(ReplayedHeader) == "true") != replayed {
+		t.Errorf("expected replayed %v, got header %q", replayed, rw.Header().Get(ReplayedHeader))
+	}
+}
+
+func TestReplay(t *testing.T) {
+	next := &countingHandler{}
+	handler := Handler(NewInMemoryStore(), time.Minute, next.serve)
+
+	assertResponse(t, send(handler, "user", "/api/v1/posts", "key", `{"text":"a"}`), http.StatusCreated, `{"call":1}`, false)
+	rw := send(handler, "user", "/api/v1/posts", "key", `{"text":"a"}`)
+	assertResponse(t, rw, http.StatusCreated, `{"call":1}`, true)
+	if rw.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("replay must keep the content type, got %q", rw.Header().Get("Content-Type"))
+	}
+	next.assertCalls(t, 1)
+
+	// keys belong to users
+	assertResponse(t, send(handler, "other", "/api/v1/posts", "key", `{"text":"a"}`), http.StatusCreated, `{"call":2}`, false)
+	next.assertCalls(t, 2)
+}
+
+func TestKeyReusedWithOtherRequest(t *testing.T) {
+	next := &countingHandler{}
+	handler := Handler(NewInMemoryStore(), time.Minute, next.serve)
+
+	assertResponse(t, send(handler, "user", "/api/v1/posts", "key", `{"text":"a"}`), http.StatusCreated, "", false)
+	assertResponse(t, send(handler, "user", "/api/v1/posts", "key", `{"text":"b"}`), http.StatusUnprocessableEntity, "", false)
+	assertResponse(t, send(handler, "user", "/api/v1/users/a/subscribe", "key", `{"text":"a"}`), http.StatusUnprocessableEntity, "", false)
+	next.assertCalls(t, 1)
+}
+
+func TestInFlightDuplicate(t *testing.T) {
+	saved := waitTimeout
+	waitTimeout = 200 * time.Millisecond
+	t.Cleanup(func() {
+		waitTimeout = saved
+	})
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	next := &countingHandler{}
+	handler := Handler(NewInMemoryStore(), time.Minute, func(rw http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		next.serve(rw, r)
+	})
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() {
+		first <- send(handler, "user", "/api/v1/posts", "key", `{"text":"a"}`)
+	}()
+	<-started
+
+	assertResponse(t, send(handler, "user", "/api/v1/posts", "key", `{"text":"a"}`), http.StatusConflict, "", false)
+	close(release)
+	assertResponse(t, <-first, http.StatusCreated, `{"call":1}`, false)
+	assertResponse(t, send(handler, "user", "/api/v1/posts", "key", `{"text":"a"}`), http.StatusCreated, `{"call":1}`, true)
+}
+
+func TestInFlightDuplicateWaits(t *testing.T) {
+	started := make(chan struct{})
+	next := &countingHandler{}
+	handler := Handler(NewInMemoryStore(), time.Minute, func(rw http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(3 * waitInterval)
+		next.serve(rw, r)
+	})
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() {
+		first <- send(handler, "user", "/api/v1/posts", "key", `{"text":"a"}`)
+	}()
+	<-started
+
+	// a duplicate finishing within waitTimeout gets the response of the first request
+	assertResponse(t, send(handler, "user", "/api/v1/posts", "key", `{"text":"a"}`), http.StatusCreated, `{"call":1}`, true)
+	assertResponse(t, <-first, http.StatusCreated, `{"call":1}`, false)
+	next.assertCalls(t, 1)
+}
+
+func TestFailedRequestAbandonsKey(t *testing.T) {
+	next := &countingHandler{statuses: []int{http.StatusInternalServerError, http.StatusTooManyRequests}}
+	handler := Handler(NewInMemoryStore(), time.Minute, next.serve)
+
+	assertResponse(t, send(handler, "user", "/api/v1/posts", "key", `{"text":"a"}`), http.StatusInternalServerError, "", false)
+	assertResponse(t, send(handler, "user", "/api/v1/posts", "key", `{"text":"a"}`), http.StatusTooManyRequests, "", false)
+	assertResponse(t, send(handler, "user", "/api/v1/posts", "key", `{"text":"a"}`), http.StatusCreated, `{"call":3}`, false)
+	assertResponse(t, send(handler, "user", "/api/v1/posts", "key", `{"text":"a"}`), http.StatusCreated, `{"call":3}`, true)
+}
+
+func TestClientErrorIsStored(t *testing.T) {
+	next := &countingHandler{statuses: []int{http.StatusBadRequest}}
+	handler := Handler(NewInMemoryStore(), time.Minute, next.serve)
+
+	assertResponse(t, send(handler, "user", "/api/v1/posts", "key", `{"text":"a"}`), http.StatusBadRequest, `{"call":1}`, false)
+	assertResponse(t, send(handler, "user", "/api/v1/posts", "key", `{"text":"a"}`), http.StatusBadRequest, `{"call":1}`, true)
+}
+
+func TestReplayExpires(t *testing.T) {
+	const ttl = 100 * time.Millisecond
+	next := &countingHandler{}
+	handler := Handler(NewInMemoryStore(), ttl, next.serve)
+
+	assertResponse(t, send(handler, "user", "/api/v1/posts", "key", `{"text":"a"}`), http.StatusCreated, `{"call":1}`, false)
+	assertResponse(t, send(handler, "user", "/api/v1/posts", "key", `{"text":"a"}`), http.StatusCreated, `{"call":1}`, true)
+	time.Sleep(ttl + 50*time.Millisecond)
+	assertResponse(t, send(handler, "user", "/api/v1/posts", "key", `{"text":"a"}`), http.StatusCreated, `{"call":2}`, false)
+}
+
+func TestWithoutKeyOrUser(t *testing.T) {
+	next := &countingHandler{}
+	handler := Handler(NewInMemoryStore(), time.Minute, next.serve)
+
+	send(handler, "user", "/api/v1/posts", "", `{"text":"a"}`)
+	send(handler, "user", "/api/v1/posts", "", `{"text":"a"}`)
+	send(handler, "", "/api/v1/posts", "key", `{"text":"a"}`)
+	send(handler, "", "/api/v1/posts", "key", `{"text":"a"}`)
+	next.assertCalls(t, 4)
+
+	assertResponse(t, send(handler, "user", "/api/v1/posts", strings.Repeat("k", maxKeyLength+1), `{}`), http.StatusBadRequest, "", false)
+}
+
+func TestMiddlewareReplaysBeforeRateLimit(t *testing.T) {
+	next := &countingHandler{}
+	router := mux.NewRouter()
+	router.HandleFunc("/api/v1/posts", next.serve).Methods(http.MethodPost)
+	router.HandleFunc("/api/v1/users", next.serve).Methods(http.MethodPost)
+	routeKey := ratelimit.RouteKey(http.MethodPost, "/api/v1/posts")
+	router.Use(Middleware(NewInMemoryStore(), time.Minute, map[string]bool{routeKey: true}))
+	router.Use(ratelimit.Middleware(ratelimit.NewInMemoryLimiter(), map[string]ratelimit.Policy{
+		routeKey: {Name: "posts", Limit: 1, Window: time.Minute},
+	}))
+
+	assertResponse(t, send(router, "user", "/api/v1/posts", "first", `{"text":"a"}`), http.StatusCreated, `{"call":1}`, false)
+	for i := 0; i < 3; i++ {
+		assertResponse(t, send(router, "user", "/api/v1/posts", "first", `{"text":"a"}`), http.StatusCreated, `{"call":1}`, true)
+	}
+	// a rate limited request is not stored, its retry is counted again
+	assertResponse(t, send(router, "user", "/api/v1/posts", "second", `{"text":"b"}`), http.StatusTooManyRequests, "", false)
+	assertResponse(t, send(router, "user", "/api/v1/posts", "second", `{"text":"b"}`), http.StatusTooManyRequests, "", false)
+	next.assertCalls(t, 1)
+
+	// routes not listed are not idempotent
+	send(router, "user", "/api/v1/users", "first", `{}`)
+	send(router, "user", "/api/v1/users", "first", `{}`)
+	next.assertCalls(t, 3)
+}
@@ -0,0 +1,7 @@
+local key = KEYS[1]
+local owner = ARGV[1]
+
+if redis.call("HGET", key, "owner") ~= owner then
+    return 0
+end
+return redis.call("DEL", key)
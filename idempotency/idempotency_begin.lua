@@ -0,0 +1,11 @@
+local key = KEYS[1]
+local owner, fingerprint = ARGV[1], ARGV[2]
+local pendingTtlMs = ARGV[3]
+
+if redis.call("EXISTS", key) == 1 then
+    return redis.call("HMGET", key, "owner", "fp", "done", "status", "ctype", "body")
+end
+
+redis.call("HSET", key, "owner", owner, "fp", fingerprint, "done", 0)
+redis.call("PEXPIRE", key, pendingTtlMs)
+return false
@@ -0,0 +1,12 @@
+local key = KEYS[1]
+local owner, status, contentType, body = ARGV[1], ARGV[2], ARGV[3], ARGV[4]
+local ttlMs = ARGV[5]
+
+-- a claim expired under a slow request may be taken by a retry already
+if redis.call("HGET", key, "owner") ~= owner then
+    return 0
+end
+
+redis.call("HSET", key, "done", 1, "status", status, "ctype", contentType, "body", body)
+redis.call("PEXPIRE", key, ttlMs)
+return 1
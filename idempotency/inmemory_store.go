@@ -0,0 +1,78 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often expired records are dropped
+const sweepInterval = time.Minute
+
+type memoryRecord struct {
+	record    Record
+	expiresAt time.Time
+}
+
+// MemoryStore keeps records within the process, it is meant for single binary runs
+type MemoryStore struct {
+	mu sync.Mutex
+
+	records   map[string]*memoryRecord
+	nextSweep time.Time
+}
+
+func NewInMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records: map[string]*memoryRecord{},
+	}
+}
+
+func (s *MemoryStore) Begin(_ context.Context, key string, claim Record, pendingTTL time.Duration) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.After(s.nextSweep) {
+		s.sweep(now)
+	}
+
+	if stored, ok := s.records[key]; ok && now.Before(stored.expiresAt) {
+		result := stored.record
+		return &result, nil
+	}
+	s.records[key] = &memoryRecord{record: claim, expiresAt: now.Add(pendingTTL)}
+	return nil, nil
+}
+
+func (s *MemoryStore) Complete(_ context.Context, key string, record Record, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.records[key]
+	if !ok || stored.record.Owner != record.Owner {
+		return nil
+	}
+	record.Done = true
+	s.records[key] = &memoryRecord{record: record, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStore) Abandon(_ context.Context, key string, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored, ok := s.records[key]; ok && stored.record.Owner == owner {
+		delete(s.records, key)
+	}
+	return nil
+}
+
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, stored := range s.records {
+		if !now.Before(stored.expiresAt) {
+			delete(s.records, key)
+		}
+	}
+	s.nextSweep = now.Add(sweepInterval)
+}
@@ -0,0 +1,80 @@
+package idempotency
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"time"
+)
+
+//go:embed idempotency_begin.lua
+var beginSource string
+var beginScript = redis.NewScript(beginSource)
+
+//go:embed idempotency_complete.lua
+var completeSource string
+var completeScript = redis.NewScript(completeSource)
+
+//go:embed idempotency_abandon.lua
+var abandonSource string
+var abandonScript = redis.NewScript(abandonSource)
+
+// RedisStore keeps records in redis hashes, so retries reaching another replica are replayed too
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) Begin(ctx context.Context, key string, claim Record, pendingTTL time.Duration) (*Record, error) {
+	keys := []string{keyPrefix + key}
+	argv := []interface{}{claim.Owner, claim.Fingerprint, pendingTTL.Milliseconds()}
+	returned, err := beginScript.Run(ctx, s.client, keys, argv...).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %s", err.Error())
+	}
+
+	fields, ok := returned.([]interface{})
+	if !ok || len(fields) != 6 {
+		return nil, fmt.Errorf("unexpected idempotency script result: %v", returned)
+	}
+	values := make([]string, len(fields))
+	for i := range fields {
+		values[i], _ = fields[i].(string)
+	}
+	status, _ := strconv.Atoi(values[3])
+	return &Record{
+		Owner:       values[0],
+		Fingerprint: values[1],
+		Done:        values[2] == "1",
+		Status:      status,
+		ContentType: values[4],
+		Body:        []byte(values[5]),
+	}, nil
+}
+
+func (s *RedisStore) Complete(ctx context.Context, key string, record Record, ttl time.Duration) error {
+	keys := []string{keyPrefix + key}
+	argv := []interface{}{record.Owner, record.Status, record.ContentType, record.Body, ttl.Milliseconds()}
+	err := completeScript.Run(ctx, s.client, keys, argv...).Err()
+	if err != nil {
+		return fmt.Errorf("redis error: %s", err.Error())
+	}
+	return nil
+}
+
+func (s *RedisStore) Abandon(ctx context.Context, key string, owner string) error {
+	err := abandonScript.Run(ctx, s.client, []string{keyPrefix + key}, owner).Err()
+	if err != nil {
+		return fmt.Errorf("redis error: %s", err.Error())
+	}
+	return nil
+}
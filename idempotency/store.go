@@ -0,0 +1,29 @@
+package idempotency
+
+import (
+	"context"
+	"time"
+)
+
+const keyPrefix = "ntwt:idempotency:"
+
+// Record is a claim of an idempotency key by the request holding Owner,
+// once Done it keeps the response replayed to retries
+type Record struct {
+	Owner       string
+	Fingerprint string
+	Done        bool
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+type Store interface {
+	// Begin claims the key for pendingTTL and returns nil, or returns the record
+	// of the key when it is already claimed
+	Begin(ctx context.Context, key string, claim Record, pendingTTL time.Duration) (*Record, error)
+	// Complete keeps the response for ttl, if the claim of owner still holds
+	Complete(ctx context.Context, key string, record Record, ttl time.Duration) error
+	// Abandon releases the claim of owner, so the request may be retried
+	Abandon(ctx context.Context, key string, owner string) error
+}
@@ -0,0 +1,160 @@
+package idempotency
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	runStoreSuite(t, func(t *testing.T) Store {
+		return NewInMemoryStore()
+	})
+}
+
+func TestRedisStore(t *testing.T) {
+	redisURL := os.Getenv("REDIS_TEST_URL")
+	if redisURL == "" {
+		t.Skip("REDIS_TEST_URL is not set")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: redisURL})
+	defer client.Close()
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+	runStoreSuite(t, func(t *testing.T) Store {
+		return NewRedisStore(client)
+	})
+}
+
+func runStoreSuite(t *testing.T, newStore func(t *testing.T) Store) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s Store)
+	}{
+		{"BeginClaims", testBeginClaims},
+		{"CompleteKeepsResponse", testCompleteKeepsResponse},
+		{"OnlyOwnerCompletes", testOnlyOwnerCompletes},
+		{"Abandon", testAbandon},
+		{"Expiry", testExpiry},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newStore(t))
+		})
+	}
+}
+
+// testKey keeps records of every test apart in a shared redis
+func testKey() string {
+	return "user:" + primitive.NewObjectID().Hex()
+}
+
+func testClaim() Record {
+	return Record{Owner: primitive.NewObjectID().Hex(), Fingerprint: "fingerprint"}
+}
+
+func begin(t *testing.T, s Store, key string, claim Record, pendingTTL time.Duration) *Record {
+	t.Helper()
+	record, err := s.Begin(context.Background(), key, claim, pendingTTL)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	return record
+}
+
+func complete(t *testing.T, s Store, key string, claim Record, ttl time.Duration) {
+	t.Helper()
+	claim.Status = 201
+	claim.ContentType = "application/json"
+	claim.Body = []byte(`{"id":"1"}`)
+	err := s.Complete(context.Background(), key, claim, ttl)
+	if err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+}
+
+func testBeginClaims(t *testing.T, s Store) {
+	key := testKey()
+	claim := testClaim()
+	if record := begin(t, s, key, claim, time.Minute); record != nil {
+		t.Fatalf("free key must be claimed, got %+v", record)
+	}
+
+	record := begin(t, s, key, testClaim(), time.Minute)
+	if record == nil || record.Owner != claim.Owner || record.Fingerprint != claim.Fingerprint || record.Done {
+		t.Errorf("claimed key must return the pending claim, got %+v", record)
+	}
+}
+
+func testCompleteKeepsResponse(t *testing.T, s Store) {
+	key := testKey()
+	claim := testClaim()
+	begin(t, s, key, claim, time.Minute)
+	complete(t, s, key, claim, time.Minute)
+
+	record := begin(t, s, key, testClaim(), time.Minute)
+	if record == nil || !record.Done {
+		t.Fatalf("expected done record, got %+v", record)
+	}
+	if record.Status != 201 || record.ContentType != "application/json" || string(record.Body) != `{"id":"1"}` || record.Fingerprint != claim.Fingerprint {
+		t.Errorf("record must keep the response, got %+v", record)
+	}
+}
+
+func testOnlyOwnerCompletes(t *testing.T, s Store) {
+	key := testKey()
+	claim := testClaim()
+	begin(t, s, key, claim, time.Minute)
+	complete(t, s, key, testClaim(), time.Minute)
+
+	record := begin(t, s, key, testClaim(), time.Minute)
+	if record == nil || record.Done || record.Owner != claim.Owner {
+		t.Errorf("other owner must not complete the claim, got %+v", record)
+	}
+}
+
+func testAbandon(t *testing.T, s Store) {
+	key := testKey()
+	claim := testClaim()
+	begin(t, s, key, claim, time.Minute)
+
+	err := s.Abandon(context.Background(), key, testClaim().Owner)
+	if err != nil {
+		t.Fatalf("abandon: %v", err)
+	}
+	if record := begin(t, s, key, testClaim(), time.Minute); record == nil {
+		t.Fatal("other owner must not abandon the claim")
+	}
+
+	err = s.Abandon(context.Background(), key, claim.Owner)
+	if err != nil {
+		t.Fatalf("abandon: %v", err)
+	}
+	if record := begin(t, s, key, testClaim(), time.Minute); record != nil {
+		t.Errorf("abandoned key must be claimed again, got %+v", record)
+	}
+}
+
+func testExpiry(t *testing.T, s Store) {
+	const ttl = 100 * time.Millisecond
+	pending := testKey()
+	begin(t, s, pending, testClaim(), ttl)
+	done := testKey()
+	claim := testClaim()
+	begin(t, s, done, claim, time.Minute)
+	complete(t, s, done, claim, ttl)
+
+	time.Sleep(ttl + 50*time.Millisecond)
+	if record := begin(t, s, pending, testClaim(), time.Minute); record != nil {
+		t.Errorf("expired claim must be claimed again, got %+v", record)
+	}
+	if record := begin(t, s, done, testClaim(), time.Minute); record != nil {
+		t.Errorf("expired response must be claimed again, got %+v", record)
+	}
+}
@@ -0,0 +1,84 @@
+package likes
+
+import (
+	"context"
+	"netwitter/schemas"
+	"sort"
+	"sync"
+	"time"
+)
+
+type MemoryLikesStorage struct {
+	mu sync.RWMutex
+
+	likesByPost map[schemas.PostId]map[schemas.UserId]time.Time
+}
+
+func NewInMemoryStorage() *MemoryLikesStorage {
+	return &MemoryLikesStorage{
+		likesByPost: map[schemas.PostId]map[schemas.UserId]time.Time{},
+	}
+}
+
+func (s *MemoryLikesStorage) PutLike(_ context.Context, userId schemas.UserId, postId schemas.PostId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	postLikes, ok := s.likesByPost[postId]
+	if !ok {
+		postLikes = map[schemas.UserId]time.Time{}
+		s.likesByPost[postId] = postLikes
+	}
+	if _, ok = postLikes[userId]; !ok {
+		postLikes[userId] = time.Now()
+	}
+	return nil
+}
+
+func (s *MemoryLikesStorage) RemoveLike(_ context.Context, userId schemas.UserId, postId schemas.PostId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.likesByPost[postId], userId)
+	return nil
+}
+
+func (s *MemoryLikesStorage) DeletePost(_ context.Context, postId schemas.PostId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.likesByPost, postId)
+	return nil
+}
+
+func (s *MemoryLikesStorage) GetPostLikers(_ context.Context, postId schemas.PostId) ([]schemas.UserId, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	postLikes := s.likesByPost[postId]
+	likers := make([]schemas.UserId, 0, len(postLikes))
+	for userId := range postLikes {
+		likers = append(likers, userId)
+	}
+	// newest likes first like in mongo storage
+	sort.Slice(likers, func(i, j int) bool {
+		return postLikes[likers[i]].After(postLikes[likers[j]])
+	})
+	return likers, nil
+}
+
+func (s *MemoryLikesStorage) GetLikesSummary(_ context.Context, viewer schemas.UserId, postIds []schemas.PostId) (map[schemas.PostId]schemas.LikesSummary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	summaries := make(map[schemas.PostId]schemas.LikesSummary, len(postIds))
+	for _, postId := range postIds {
+		postLikes := s.likesByPost[postId]
+		_, likedByMe := postLikes[viewer]
+		summaries[postId] = schemas.LikesSummary{
+			Count:     len(postLikes),
+			LikedByMe: likedByMe,
+		}
+	}
+	return summaries, nil
+}
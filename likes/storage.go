@@ -0,0 +1,163 @@
+package likes
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"netwitter/schemas"
+	"time"
+)
+
+// Likes are stored one document per (post, user), so counters are always
+// derived from the documents themselves and stay correct under concurrent likes.
+// They are kept apart from posts to leave post versions untouched.
+
+type LikeInfo struct {
+	PostID    schemas.PostId `bson:"postId"`
+	UserID    schemas.UserId `bson:"userId"`
+	CreatedAt time.Time      `bson:"createdAt"`
+}
+
+type LikesStorage struct {
+	likesCollection *mongo.Collection
+}
+
+func NewStorage(ctx context.Context, mongoUrl, dbName string) *LikesStorage {
+	mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoUrl))
+	if err != nil {
+		panic(fmt.Sprintf("connect to mongo failed: %s", err))
+	}
+
+	likesCollection := mongoClient.Database(dbName).Collection("likes")
+	err = ensureIndexes(ctx, likesCollection)
+	if err != nil {
+		panic(fmt.Sprintf("failed ensure index: %s", err))
+	}
+
+	return &LikesStorage{likesCollection: likesCollection}
+}
+
+func ensureIndexes(ctx context.Context, collection *mongo.Collection) error {
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"postId", 1}, {"userId", 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"userId", 1}, {"postId", 1}},
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *LikesStorage) PutLike(ctx context.Context, userId schemas.UserId, postId schemas.PostId) error {
+	mongoQuery := bson.M{"postId": postId, "userId": string(userId)}
+	mongoCommand := bson.M{
+		"$setOnInsert": &LikeInfo{
+			PostID:    postId,
+			UserID:    userId,
+			CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+		},
+	}
+	mongoOpts := options.Update().SetUpsert(true)
+	_, err := s.likesCollection.UpdateOne(ctx, mongoQuery, mongoCommand, mongoOpts)
+	if err != nil {
+		// concurrent upserts of the same like race on the unique index
+		if mongo.IsDuplicateKeyError(err) {
+			return nil
+		}
+		return fmt.Errorf("like insertion failed: %s", err.Error())
+	}
+	return nil
+}
+
+func (s *LikesStorage) RemoveLike(ctx context.Context, userId schemas.UserId, postId schemas.PostId) error {
+	mongoQuery := bson.M{"postId": postId, "userId": string(userId)}
+	_, err := s.likesCollection.DeleteOne(ctx, mongoQuery)
+	if err != nil {
+		return fmt.Errorf("like removal failed: %s", err.Error())
+	}
+	return nil
+}
+
+func (s *LikesStorage) DeletePost(ctx context.Context, postId schemas.PostId) error {
+	_, err := s.likesCollection.DeleteMany(ctx, bson.M{"postId": postId})
+	if err != nil {
+		return fmt.Errorf("likes removal failed: %s", err.Error())
+	}
+	return nil
+}
+
+func (s *LikesStorage) GetPostLikers(ctx context.Context, postId schemas.PostId) ([]schemas.UserId, error) {
+	mongoOptions := options.Find().SetSort(bson.M{"createdAt": -1})
+	cursor, err := s.likesCollection.Find(ctx, bson.M{"postId": postId}, mongoOptions)
+	if err != nil {
+		return nil, fmt.Errorf("mongo search failed: %s", err.Error())
+	}
+
+	var allPostLikes []*LikeInfo
+	err = cursor.All(ctx, &allPostLikes)
+	if err != nil {
+		return nil, fmt.Errorf("putting likes from mongo failed: %s", err.Error())
+	}
+
+	likers := make([]schemas.UserId, 0, len(allPostLikes))
+	for i := range allPostLikes {
+		likers = append(likers, allPostLikes[i].UserID)
+	}
+	return likers, nil
+}
+
+func (s *LikesStorage) GetLikesSummary(ctx context.Context, viewer schemas.UserId, postIds []schemas.PostId) (map[schemas.PostId]schemas.LikesSummary, error) {
+	summaries := make(map[schemas.PostId]schemas.LikesSummary, len(postIds))
+	if len(postIds) == 0 {
+		return summaries, nil
+	}
+
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.M{"postId": bson.M{"$in": postIds}}}},
+		{{"$group", bson.M{"_id": "$postId", "count": bson.M{"$sum": 1}}}},
+	}
+	cursor, err := s.likesCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("mongo aggregation failed: %s", err.Error())
+	}
+	var counters []struct {
+		PostID schemas.PostId `bson:"_id"`
+		Count  int            `bson:"count"`
+	}
+	err = cursor.All(ctx, &counters)
+	if err != nil {
+		return nil, fmt.Errorf("putting counters from mongo failed: %s", err.Error())
+	}
+	for _, counter := range counters {
+		summaries[counter.PostID] = schemas.LikesSummary{Count: counter.Count}
+	}
+
+	if viewer == "" {
+		return summaries, nil
+	}
+
+	cursor, err = s.likesCollection.Find(ctx, bson.M{"userId": string(viewer), "postId": bson.M{"$in": postIds}})
+	if err != nil {
+		return nil, fmt.Errorf("mongo search failed: %s", err.Error())
+	}
+	var viewerLikes []*LikeInfo
+	err = cursor.All(ctx, &viewerLikes)
+	if err != nil {
+		return nil, fmt.Errorf("putting likes from mongo failed: %s", err.Error())
+	}
+	for _, like := range viewerLikes {
+		summary := summaries[like.PostID]
+		summary.LikedByMe = true
+		summaries[like.PostID] = summary
+	}
+	return summaries, nil
+}
@@ -0,0 +1,123 @@
+package likes
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"netwitter/schemas"
+	"netwitter/storage"
+	"os"
+	"testing"
+)
+
+func TestMemoryLikesStorage(t *testing.T) {
+	runLikesSuite(t, func(t *testing.T) storage.LikesStorage {
+		return NewInMemoryStorage()
+	})
+}
+
+func TestMongoLikesStorage(t *testing.T) {
+	mongoURL := os.Getenv("MONGO_TEST_URL")
+	if mongoURL == "" {
+		t.Skip("MONGO_TEST_URL is not set")
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(ctx)
+
+	runLikesSuite(t, func(t *testing.T) storage.LikesStorage {
+		mongoName := "likestest_" + primitive.NewObjectID().Hex()
+		t.Cleanup(func() {
+			_ = client.Database(mongoName).Drop(ctx)
+		})
+		return NewStorage(ctx, mongoURL, mongoName)
+	})
+}
+
+func runLikesSuite(t *testing.T, newStorage func(t *testing.T) storage.LikesStorage) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s storage.LikesStorage)
+	}{
+		{"Summary", testSummary},
+		{"DeletePost", testDeletePost},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newStorage(t))
+		})
+	}
+}
+
+func putLikes(t *testing.T, s storage.LikesStorage, postId schemas.PostId, users ...schemas.UserId) {
+	t.Helper()
+	for _, userId := range users {
+		err := s.PutLike(context.Background(), userId, postId)
+		if err != nil {
+			t.Fatalf("put like: %v", err)
+		}
+	}
+}
+
+func testSummary(t *testing.T, s storage.LikesStorage) {
+	ctx := context.Background()
+	postId := schemas.PostId(primitive.NewObjectID())
+	putLikes(t, s, postId, "alice", "bob", "alice")
+
+	summaries, err := s.GetLikesSummary(ctx, "alice", []schemas.PostId{postId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary := summaries[postId]; summary.Count != 2 || !summary.LikedByMe {
+		t.Errorf("expected 2 likes by alice among them, got %+v", summary)
+	}
+
+	err = s.RemoveLike(ctx, "alice", postId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summaries, err = s.GetLikesSummary(ctx, "alice", []schemas.PostId{postId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary := summaries[postId]; summary.Count != 1 || summary.LikedByMe {
+		t.Errorf("expected 1 like not by alice, got %+v", summary)
+	}
+}
+
+func testDeletePost(t *testing.T, s storage.LikesStorage) {
+	ctx := context.Background()
+	deletedId := schemas.PostId(primitive.NewObjectID())
+	keptId := schemas.PostId(primitive.NewObjectID())
+	putLikes(t, s, deletedId, "alice", "bob")
+	putLikes(t, s, keptId, "alice")
+
+	err := s.DeletePost(ctx, deletedId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	likers, err := s.GetPostLikers(ctx, deletedId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(likers) != 0 {
+		t.Errorf("expected no likers of deleted post, got %v", likers)
+	}
+	summaries, err := s.GetLikesSummary(ctx, "alice", []schemas.PostId{deletedId, keptId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary := summaries[deletedId]; summary.Count != 0 || summary.LikedByMe {
+		t.Errorf("expected no likes of deleted post, got %+v", summary)
+	}
+	if summary := summaries[keptId]; summary.Count != 1 || !summary.LikedByMe {
+		t.Errorf("expected likes of other posts kept, got %+v", summary)
+	}
+}
@@ -6,11 +6,11 @@ import (
 	"github.com/gorilla/mux"
 	"log"
 	"net/http"
-	"netwitter/feed"
+	"netwitter/auth"
 	"netwitter/handlers"
-	"netwitter/storage/mongostorage"
-	"netwitter/users"
-	"netwitter/workers"
+	"netwitter/idempotency"
+	"netwitter/media"
+	"netwitter/ratelimit"
 	"os"
 	"time"
 )
@@ -23,62 +23,108 @@ func Start() error {
 		return runAsServer()
 	case "WORKER":
 		return runAsWorker()
+	case "STANDALONE":
+		return runAsStandalone()
 	default:
 		panic(fmt.Errorf("unexpected app mode: %s", mode))
 	}
 }
 
 func runAsServer() error {
-	ctx := context.Background()
+	return serveStack(newAppStack(context.Background(), os.Getenv("STORAGE_MODE")))
+}
+
+// runAsStandalone serves with all storages in memory and tasks executed in the same process,
+// nothing is persisted between runs
+func runAsStandalone() error {
+	return serveStack(newAppStack(context.Background(), storageModeInMemory))
+}
 
+func serveStack(stack *appStack) error {
 	serverPort := os.Getenv("SERVER_PORT")
 	if serverPort == "" {
 		serverPort = defaultServerPort
 	}
 
-	mongoURL := os.Getenv("MONGO_URL")
-	if mongoURL == "" {
-		panic(fmt.Errorf("empty mongo url"))
-	}
-	dbName := os.Getenv("MONGO_DBNAME")
-	if dbName == "" {
-		panic(fmt.Errorf("empty mongo dbname"))
-	}
-
-	brokerURL := os.Getenv("REDIS_URL")
-	if brokerURL == "" {
-		panic(fmt.Errorf("nempty broker url"))
+	if stack.isLocal {
+		go func() {
+			log.Println(stack.scheduler.Listen())
+		}()
 	}
+	go runMediaCleanup(stack.mediaManager, mediaCleanupInterval())
+
+	handler := handlers.NewHTTPHandler(stack.postsStorage, *stack.usersManager, stack.feedManager, stack.likesStorage, stack.mediaManager, stack.authManager)
+	router := newRouter(handler)
+	router.Use(auth.Middleware(stack.authManager, allowUserHeader()))
+	router.Use(idempotency.Middleware(stack.idempotency, idempotencyTTL(), idempotentRoutes))
+	router.Use(ratelimit.Middleware(stack.rateLimiter, rateLimitPolicies))
+	return serve(serverPort, router)
+}
 
-	usersStorage := users.NewStorage(ctx, mongoURL, dbName)
-	feedStorage := feed.NewStorage(ctx, mongoURL, dbName)
-
-	scheduler := workers.NewScheduler(brokerURL)
-	postsStorage := mongostorage.NewStorage(mongoURL, dbName, *scheduler)
-	feedManager := feed.NewFeedManager(postsStorage, usersStorage, feedStorage)
-	usersManager := users.NewUsersManager(usersStorage, feedStorage, *scheduler)
-
-	executor := workers.NewPostsTasksExecutor(*feedManager)
+// rateLimitPolicies are counted per user, or per client ip for anonymous requests
+var rateLimitPolicies = map[string]ratelimit.Policy{
+	ratelimit.RouteKey(http.MethodPost, "/api/v1/auth/token"):               {Name: "tokens", Limit: 20, Window: time.Minute},
+	ratelimit.RouteKey(http.MethodPost, "/api/v1/users"):                    {Name: "registrations", Limit: 10, Window: time.Hour},
+	ratelimit.RouteKey(http.MethodPost, "/api/v1/posts"):                    {Name: "posts", Limit: 30, Window: time.Minute},
+	ratelimit.RouteKey(http.MethodPost, "/api/v1/posts/{postId}/repost"):    {Name: "reposts", Limit: 30, Window: time.Minute},
+	ratelimit.RouteKey(http.MethodPost, "/api/v1/media"):                    {Name: "uploads", Limit: 60, Window: time.Hour},
+	ratelimit.RouteKey(http.MethodPost, "/api/v1/users/{userId}/subscribe"): {Name: "subscriptions", Limit: 100, Window: time.Hour},
+}
 
-	err := scheduler.Register(*executor)
-	if err != nil {
-		panic(err)
-	}
+// idempotentRoutes replay responses to retries with the same idempotency key,
+// replays are served before rate limiting, so retries do not use up limits
+var idempotentRoutes = map[string]bool{
+	ratelimit.RouteKey(http.MethodPost, "/api/v1/posts"):                    true,
+	ratelimit.RouteKey(http.MethodPost, "/api/v1/users/{userId}/subscribe"): true,
+}
 
-	handler := handlers.NewHTTPHandler(postsStorage, *usersManager)
+func newRouter(handler *handlers.HTTPHandler) *mux.Router {
 	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/auth/token", handler.HandleIssueToken).Methods(http.MethodPost)
+
 	r.HandleFunc("/api/v1/posts", handler.HandleCreatePost).Methods(http.MethodPost)
 	r.HandleFunc("/api/v1/posts/{postId}", handler.HandleGetPost).Methods(http.MethodGet)
 	r.HandleFunc("/api/v1/posts/{postId}", handler.HandleEditPost).Methods(http.MethodPatch)
+	r.HandleFunc("/api/v1/posts/{postId}", handler.HandleDeletePost).Methods(http.MethodDelete)
+	r.HandleFunc("/api/v1/posts/{postId}/revisions", handler.HandleGetPostRevisions).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/posts/{postId}/replies", handler.HandleGetPostReplies).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/posts/{postId}/thread", handler.HandleGetPostThread).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/posts/{postId}/repost", handler.HandleRepost).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/posts/{postId}/like", handler.HandleLikePost).Methods(http.MethodPut)
+	r.HandleFunc("/api/v1/posts/{postId}/like", handler.HandleUnlikePost).Methods(http.MethodDelete)
+	r.HandleFunc("/api/v1/posts/{postId}/likes", handler.HandleGetPostLikers).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/users", handler.HandleRegisterUser).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/users/{userId}", handler.HandleGetUserProfile).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/users/{userId}", handler.HandleUpdateUserProfile).Methods(http.MethodPatch)
 	r.HandleFunc("/api/v1/users/{userId}/posts", handler.HandleGetUserPosts).Methods(http.MethodGet)
 
+	r.HandleFunc("/api/v1/media", handler.HandleUploadMedia).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/media/{attachmentId}", handler.HandleGetMedia).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/hashtags/{tag}/posts", handler.HandleGetHashtagPosts).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/search/posts", handler.HandleSearchPosts).Methods(http.MethodGet)
+
 	r.HandleFunc("/api/v1/subscriptions", handler.HandleGetUserSubscriptions).Methods(http.MethodGet)
 	r.HandleFunc("/api/v1/subscribers", handler.HandleGetUserSubscribers).Methods(http.MethodGet)
 	r.HandleFunc("/api/v1/users/{userId}/subscribe", handler.HandleSubscribeUser).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/users/{userId}/subscribe", handler.HandleUnsubscribeUser).Methods(http.MethodDelete)
+	r.HandleFunc("/api/v1/follow-requests", handler.HandleGetFollowRequests).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/follow-requests/{userId}/approve", handler.HandleApproveFollowRequest).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/follow-requests/{userId}/reject", handler.HandleRejectFollowRequest).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/blocks", handler.HandleGetBlockedUsers).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/users/{userId}/block", handler.HandleBlockUser).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/users/{userId}/block", handler.HandleUnblockUser).Methods(http.MethodDelete)
+	r.HandleFunc("/api/v1/mutes", handler.HandleGetMutedUsers).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/users/{userId}/mute", handler.HandleMuteUser).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/users/{userId}/mute", handler.HandleUnmuteUser).Methods(http.MethodDelete)
 	r.HandleFunc("/api/v1/feed", handler.HandleGetUserFeed).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/feed/stream", handler.HandleStreamFeed).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/mentions", handler.HandleGetUserMentions).Methods(http.MethodGet)
 
 	r.HandleFunc("/maintenance/ping", handler.HandlePing).Methods(http.MethodGet)
+	return r
+}
 
+func serve(serverPort string, r *mux.Router) error {
 	server := &http.Server{
 		Handler:      r,
 		Addr:         fmt.Sprintf("0.0.0.0:%s", serverPort),
@@ -89,43 +135,29 @@ func runAsServer() error {
 	return server.ListenAndServe()
 }
 
-func runAsWorker() error {
-	ctx := context.Background()
-
-	serverPort := os.Getenv("SERVER_PORT")
-	if serverPort == "" {
-		serverPort = defaultServerPort
+// runMediaCleanup removes orphan uploads every interval, it runs in servers
+// because attachment contents are kept in MEDIA_DIR of the serving process
+func runMediaCleanup(mediaManager *media.MediaManager, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		deleted, err := mediaManager.CleanupOrphans(context.Background())
+		if err != nil {
+			log.Printf("media cleanup failed after %d attachments: %s", deleted, err)
+			continue
+		}
+		if deleted != 0 {
+			log.Printf("media cleanup removed %d attachments", deleted)
+		}
 	}
+}
 
-	mongoURL := os.Getenv("MONGO_URL")
-	if mongoURL == "" {
-		panic(fmt.Errorf("empty mongo url"))
-	}
-	dbName := os.Getenv("MONGO_DBNAME")
-	if dbName == "" {
-		panic(fmt.Errorf("empty mongo dbname"))
-	}
-
-	brokerURL := os.Getenv("REDIS_URL")
-	if brokerURL == "" {
-		panic(fmt.Errorf("nempty broker url"))
-	}
-
-	scheduler := workers.NewScheduler(brokerURL)
-
-	usersStorage := users.NewStorage(ctx, mongoURL, dbName)
-	feedStorage := feed.NewStorage(ctx, mongoURL, dbName)
-	postsStorage := mongostorage.NewStorage(mongoURL, dbName, *scheduler)
-
-	feedManager := feed.NewFeedManager(postsStorage, usersStorage, feedStorage)
-
-	executor := workers.NewPostsTasksExecutor(*feedManager)
-	err := scheduler.Register(*executor)
-	if err != nil {
-		panic(err)
+func runAsWorker() error {
+	stack := newAppStack(context.Background(), os.Getenv("STORAGE_MODE"))
+	if stack.isLocal {
+		panic(fmt.Errorf("worker has nothing to share with in-memory storages"))
 	}
-
-	return scheduler.Listen()
+	return stack.scheduler.Listen()
 }
 
 func main() {
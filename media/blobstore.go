@@ -0,0 +1,92 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"netwitter/storage"
+	"os"
+	"path/filepath"
+)
+
+// BlobStore keeps attachment contents by key, metadata is kept in storage.AttachmentsStorage
+type BlobStore interface {
+	Put(ctx context.Context, key string, content io.Reader) (int64, error)
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// LocalBlobStore keeps a file per blob in a single directory
+type LocalBlobStore struct {
+	dir string
+}
+
+func NewLocalBlobStore(dir string) *LocalBlobStore {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		panic(fmt.Sprintf("failed to create blob directory: %s", err))
+	}
+	return &LocalBlobStore{dir: dir}
+}
+
+func (bs *LocalBlobStore) Put(_ context.Context, key string, content io.Reader) (int64, error) {
+	path, err := bs.path(key)
+	if err != nil {
+		return 0, err
+	}
+
+	// readers never see partially written blobs
+	tmpFile, err := ioutil.TempFile(bs.dir, ".upload-*")
+	if err != nil {
+		return 0, fmt.Errorf("blob creation failed: %s", err.Error())
+	}
+	defer os.Remove(tmpFile.Name())
+
+	size, err := io.Copy(tmpFile, content)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("blob writing failed: %w", err)
+	}
+	err = os.Rename(tmpFile.Name(), path)
+	if err != nil {
+		return 0, fmt.Errorf("blob writing failed: %s", err.Error())
+	}
+	return size, nil
+}
+
+func (bs *LocalBlobStore) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	path, err := bs.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: blob %s", storage.ErrNotFound, key)
+		}
+		return nil, fmt.Errorf("blob opening failed: %s", err.Error())
+	}
+	return file, nil
+}
+
+func (bs *LocalBlobStore) Delete(_ context.Context, key string) error {
+	path, err := bs.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("blob removal failed: %s", err.Error())
+	}
+	return nil
+}
+
+func (bs *LocalBlobStore) path(key string) (string, error) {
+	if key == "" || filepath.Base(key) != key || key[0] == '.' {
+		return "", fmt.Errorf("invalid blob key: %q", key)
+	}
+	return filepath.Join(bs.dir, key), nil
+}
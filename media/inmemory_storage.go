@@ -0,0 +1,136 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"netwitter/schemas"
+	"netwitter/storage"
+	"sync"
+	"time"
+)
+
+type MemoryAttachmentsStorage struct {
+	mu sync.RWMutex
+
+	attachmentById map[schemas.AttachmentId]*schemas.Attachment
+}
+
+func NewInMemoryStorage() *MemoryAttachmentsStorage {
+	return &MemoryAttachmentsStorage{
+		attachmentById: map[schemas.AttachmentId]*schemas.Attachment{},
+	}
+}
+
+func (s *MemoryAttachmentsStorage) PutAttachment(_ context.Context, attachment schemas.Attachment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.attachmentById[attachment.ID]; ok {
+		return fmt.Errorf("%w: attachment %s", storage.ErrCollision, attachment.ID)
+	}
+	s.attachmentById[attachment.ID] = &attachment
+	return nil
+}
+
+func (s *MemoryAttachmentsStorage) GetAttachment(_ context.Context, attachmentId schemas.AttachmentId) (*schemas.Attachment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	attachment, ok := s.attachmentById[attachmentId]
+	if !ok {
+		return nil, fmt.Errorf("%w: attachment %s", storage.ErrNotFound, attachmentId)
+	}
+	result := *attachment
+	return &result, nil
+}
+
+func (s *MemoryAttachmentsStorage) AttachToPost(_ context.Context, attachmentIds []schemas.AttachmentId, postId schemas.PostId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, attachmentId := range attachmentIds {
+		attachment, ok := s.attachmentById[attachmentId]
+		if !ok || attachment.PostID != nil || attachment.AvatarOf != nil {
+			return fmt.Errorf("%w: some of attachments are already used", storage.ErrCollision)
+		}
+	}
+	for _, attachmentId := range attachmentIds {
+		attachedTo := postId
+		s.attachmentById[attachmentId].PostID = &attachedTo
+	}
+	return nil
+}
+
+func (s *MemoryAttachmentsStorage) DetachFromPost(_ context.Context, postId schemas.PostId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, attachment := range s.attachmentById {
+		if attachment.PostID != nil && *attachment.PostID == postId {
+			attachment.PostID = nil
+			attachment.ReleasedAt = releasedNow()
+		}
+	}
+	return nil
+}
+
+func isOrphan(attachment *schemas.Attachment, orphanedBefore time.Time) bool {
+	return attachment.PostID == nil && attachment.AvatarOf == nil && attachment.OrphanedAt().Before(orphanedBefore)
+}
+
+func (s *MemoryAttachmentsStorage) GetOrphanAttachments(_ context.Context, orphanedBefore time.Time) ([]*schemas.Attachment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var orphans []*schemas.Attachment
+	for _, attachment := range s.attachmentById {
+		if isOrphan(attachment, orphanedBefore) {
+			orphan := *attachment
+			orphans = append(orphans, &orphan)
+		}
+	}
+	return orphans, nil
+}
+
+func (s *MemoryAttachmentsStorage) DeleteAttachment(_ context.Context, attachmentId schemas.AttachmentId, orphanedBefore time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attachment, ok := s.attachmentById[attachmentId]
+	if !ok || !isOrphan(attachment, orphanedBefore) {
+		return fmt.Errorf("%w: orphan attachment %s", storage.ErrNotFound, attachmentId)
+	}
+	delete(s.attachmentById, attachmentId)
+	return nil
+}
+
+func (s *MemoryAttachmentsStorage) SetAvatar(_ context.Context, attachmentId schemas.AttachmentId, userId schemas.UserId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attachment, ok := s.attachmentById[attachmentId]
+	if !ok || attachment.PostID != nil || attachment.AvatarOf != nil {
+		return fmt.Errorf("%w: attachment %s is already used", storage.ErrCollision, attachmentId)
+	}
+	avatarOf := userId
+	attachment.AvatarOf = &avatarOf
+	return nil
+}
+
+func (s *MemoryAttachmentsStorage) ReleaseAvatar(_ context.Context, userId schemas.UserId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, attachment := range s.attachmentById {
+		if attachment.AvatarOf != nil && *attachment.AvatarOf == userId {
+			attachment.AvatarOf = nil
+			attachment.ReleasedAt = releasedNow()
+		}
+	}
+	return nil
+}
+
+func releasedNow() *time.Time {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	return &now
+}
@@ -0,0 +1,218 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"net/http"
+	"netwitter/schemas"
+	"netwitter/storage"
+	"strings"
+	"time"
+)
+
+const (
+	MaxBlobSize           = 10 << 20
+	MaxAttachmentsPerPost = 4
+	// OrphanGracePeriod is how long an upload waits for a post to take it
+	OrphanGracePeriod = time.Hour
+)
+
+// allowedContentTypes are checked against sniffed content, not against what the client claims
+var allowedContentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"video/mp4":       true,
+	"application/pdf": true,
+}
+
+var (
+	MediaError            = errors.New("media")
+	ErrTooLarge           = fmt.Errorf("%w.too_large", MediaError)
+	ErrUnsupportedType    = fmt.Errorf("%w.unsupported_type", MediaError)
+	ErrInvalidAttachment  = fmt.Errorf("%w.invalid_attachment", MediaError)
+	ErrTooManyAttachments = fmt.Errorf("%w.too_many_attachments", MediaError)
+	ErrAttachmentUsed     = fmt.Errorf("%w.attachment_used", MediaError)
+)
+
+type MediaManager struct {
+	blobStore          BlobStore
+	attachmentsStorage storage.AttachmentsStorage
+}
+
+func NewMediaManager(blobStore BlobStore, attachmentsStorage storage.AttachmentsStorage) *MediaManager {
+	return &MediaManager{blobStore: blobStore, attachmentsStorage: attachmentsStorage}
+}
+
+func (mm *MediaManager) Upload(ctx context.Context, ownerId schemas.UserId, content io.Reader) (*schemas.Attachment, error) {
+	head := make([]byte, 512)
+	headSize, err := io.ReadFull(content, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("upload reading failed: %w", err)
+	}
+	head = head[:headSize]
+	if headSize == 0 {
+		return nil, fmt.Errorf("%w: empty upload", ErrUnsupportedType)
+	}
+	contentType := http.DetectContentType(head)
+	if !allowedContentTypes[contentType] {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedType, contentType)
+	}
+
+	attachment := schemas.Attachment{
+		ID:          schemas.AttachmentId(primitive.NewObjectID().Hex()),
+		OwnerID:     ownerId,
+		ContentType: contentType,
+		CreatedAt:   time.Now().UTC().Truncate(time.Millisecond),
+	}
+	// one byte over the limit is enough to tell the upload is too large
+	limited := io.LimitReader(io.MultiReader(bytes.NewReader(head), content), MaxBlobSize+1)
+	attachment.Size, err = mm.blobStore.Put(ctx, string(attachment.ID), limited)
+	if err != nil {
+		return nil, err
+	}
+	if attachment.Size > MaxBlobSize {
+		err = mm.blobStore.Delete(ctx, string(attachment.ID))
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: limit is %d bytes", ErrTooLarge, MaxBlobSize)
+	}
+
+	err = mm.attachmentsStorage.PutAttachment(ctx, attachment)
+	if err != nil {
+		// the blob is not referenced by anything yet
+		_ = mm.blobStore.Delete(ctx, string(attachment.ID))
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// Open returns attachment metadata and content, the caller closes the content
+func (mm *MediaManager) Open(ctx context.Context, attachmentId schemas.AttachmentId) (*schemas.Attachment, io.ReadCloser, error) {
+	attachment, err := mm.attachmentsStorage.GetAttachment(ctx, attachmentId)
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := mm.blobStore.Open(ctx, string(attachmentId))
+	if err != nil {
+		return nil, nil, err
+	}
+	return attachment, content, nil
+}
+
+// ResolveAttachments checks that the owner may attach uploads with the ids to a new post
+func (mm *MediaManager) ResolveAttachments(ctx context.Context, ownerId schemas.UserId, rawIds []string) ([]schemas.Attachment, error) {
+	if len(rawIds) > MaxAttachmentsPerPost {
+		return nil, fmt.Errorf("%w: at most %d per post", ErrTooManyAttachments, MaxAttachmentsPerPost)
+	}
+
+	attachments := make([]schemas.Attachment, 0, len(rawIds))
+	seen := map[schemas.AttachmentId]bool{}
+	for _, rawId := range rawIds {
+		attachmentId := schemas.AttachmentId(rawId)
+		if seen[attachmentId] {
+			return nil, fmt.Errorf("%w: %s is repeated", ErrInvalidAttachment, rawId)
+		}
+		seen[attachmentId] = true
+
+		attachment, err := mm.attachmentsStorage.GetAttachment(ctx, attachmentId)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				return nil, fmt.Errorf("%w: %s not found", ErrInvalidAttachment, rawId)
+			}
+			return nil, err
+		}
+		if attachment.OwnerID != ownerId {
+			return nil, fmt.Errorf("%w: %s is not available", ErrInvalidAttachment, rawId)
+		}
+		if attachment.PostID != nil || attachment.AvatarOf != nil {
+			return nil, fmt.Errorf("%w: %s", ErrAttachmentUsed, rawId)
+		}
+		attachments = append(attachments, *attachment)
+	}
+	return attachments, nil
+}
+
+// ResolveAvatar checks that the owner may use an image upload with the id as their avatar
+func (mm *MediaManager) ResolveAvatar(ctx context.Context, ownerId schemas.UserId, rawId string) (*schemas.Attachment, error) {
+	attachment, err := mm.attachmentsStorage.GetAttachment(ctx, schemas.AttachmentId(rawId))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("%w: %s not found", ErrInvalidAttachment, rawId)
+		}
+		return nil, err
+	}
+	if attachment.OwnerID != ownerId || attachment.PostID != nil {
+		return nil, fmt.Errorf("%w: %s is not available", ErrInvalidAttachment, rawId)
+	}
+	if attachment.AvatarOf == nil && !strings.HasPrefix(attachment.ContentType, "image/") {
+		return nil, fmt.Errorf("%w: avatar must be an image", ErrUnsupportedType)
+	}
+	return attachment, nil
+}
+
+// SetAvatar leaves the previous avatar of the user to CleanupOrphans, nil avatarId only removes it
+func (mm *MediaManager) SetAvatar(ctx context.Context, userId schemas.UserId, avatarId *schemas.AttachmentId) error {
+	err := mm.attachmentsStorage.ReleaseAvatar(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if avatarId == nil {
+		return nil
+	}
+	return mm.attachmentsStorage.SetAvatar(ctx, *avatarId, userId)
+}
+
+// ClaimAttachments takes the attachments for a post that is not published yet,
+// ErrAttachmentUsed means another post was faster
+func (mm *MediaManager) ClaimAttachments(ctx context.Context, postId schemas.PostId, attachments []schemas.Attachment) error {
+	attachmentIds := make([]schemas.AttachmentId, len(attachments))
+	for i := range attachments {
+		attachmentIds[i] = attachments[i].ID
+	}
+	err := mm.attachmentsStorage.AttachToPost(ctx, attachmentIds, postId)
+	if errors.Is(err, storage.ErrCollision) {
+		return fmt.Errorf("%w: %s", ErrAttachmentUsed, err.Error())
+	}
+	return err
+}
+
+// ReleasePost leaves attachments of a deleted post to CleanupOrphans
+func (mm *MediaManager) ReleasePost(ctx context.Context, postId schemas.PostId) error {
+	return mm.attachmentsStorage.DetachFromPost(ctx, postId)
+}
+
+// CleanupOrphans removes uploads left unused for OrphanGracePeriod, after upload or after
+// a post or a user released them. Metadata goes first and only while the upload is still
+// unused, so a post taking it meanwhile keeps its blob; a failure may leave a blob
+// without metadata, which nothing serves.
+func (mm *MediaManager) CleanupOrphans(ctx context.Context) (int, error) {
+	orphanedBefore := time.Now().Add(-OrphanGracePeriod)
+	orphans, err := mm.attachmentsStorage.GetOrphanAttachments(ctx, orphanedBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, orphan := range orphans {
+		err = mm.attachmentsStorage.DeleteAttachment(ctx, orphan.ID, orphanedBefore)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return deleted, err
+		}
+		err = mm.blobStore.Delete(ctx, string(orphan.ID))
+		if err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
@@ -0,0 +1,66 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"netwitter/schemas"
+	"netwitter/storage"
+	"testing"
+	"time"
+)
+
+func TestCleanupOrphans(t *testing.T) {
+	ctx := context.Background()
+	blobStore := NewLocalBlobStore(t.TempDir())
+	attachments := NewInMemoryStorage()
+	manager := NewMediaManager(blobStore, attachments)
+
+	uploadedAt := time.Now().Add(-2 * OrphanGracePeriod)
+	orphan := putAttachmentAt(t, attachments, uploadedAt)
+	attached := putAttachmentAt(t, attachments, uploadedAt)
+	released := putAttachmentAt(t, attachments, uploadedAt)
+	for _, attachmentId := range []schemas.AttachmentId{orphan, attached, released} {
+		_, err := blobStore.Put(ctx, string(attachmentId), bytes.NewReader([]byte("blob")))
+		if err != nil {
+			t.Fatalf("put blob: %v", err)
+		}
+	}
+	err := attachments.AttachToPost(ctx, []schemas.AttachmentId{attached}, schemas.NewPostId())
+	if err != nil {
+		t.Fatalf("attach: %v", err)
+	}
+	postId := schemas.NewPostId()
+	err = attachments.AttachToPost(ctx, []schemas.AttachmentId{released}, postId)
+	if err != nil {
+		t.Fatalf("attach: %v", err)
+	}
+	err = manager.ReleasePost(ctx, postId)
+	if err != nil {
+		t.Fatalf("release post: %v", err)
+	}
+
+	deleted, err := manager.CleanupOrphans(ctx)
+	if err != nil {
+		t.Fatalf("cleanup: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("expected 1 orphan deleted, got %d", deleted)
+	}
+	_, _, err = manager.Open(ctx, orphan)
+	if err == nil {
+		t.Error("expected the orphan to be gone")
+	}
+	_, err = blobStore.Open(ctx, string(orphan))
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected the orphan blob to be gone, got %v", err)
+	}
+	for _, attachmentId := range []schemas.AttachmentId{attached, released} {
+		_, content, err := manager.Open(ctx, attachmentId)
+		if err != nil {
+			t.Errorf("expected %s to be kept: %v", attachmentId, err)
+			continue
+		}
+		content.Close()
+	}
+}
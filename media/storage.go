@@ -0,0 +1,168 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"netwitter/schemas"
+	"netwitter/storage"
+	"sort"
+	"time"
+)
+
+type AttachmentsStorage struct {
+	attachmentsCollection *mongo.Collection
+}
+
+func NewStorage(ctx context.Context, mongoUrl, dbName string) *AttachmentsStorage {
+	mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoUrl))
+	if err != nil {
+		panic(fmt.Sprintf("connect to mongo failed: %s", err))
+	}
+
+	attachmentsCollection := mongoClient.Database(dbName).Collection("attachments")
+	err = ensureIndexes(ctx, attachmentsCollection)
+	if err != nil {
+		panic(fmt.Sprintf("failed ensure index: %s", err))
+	}
+
+	return &AttachmentsStorage{attachmentsCollection: attachmentsCollection}
+}
+
+func ensureIndexes(ctx context.Context, collection *mongo.Collection) error {
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"postId", 1}, {"createdAt", 1}},
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *AttachmentsStorage) PutAttachment(ctx context.Context, attachment schemas.Attachment) error {
+	_, err := s.attachmentsCollection.InsertOne(ctx, attachment)
+	if err != nil {
+		return fmt.Errorf("attachment insertion failed: %s", err.Error())
+	}
+	return nil
+}
+
+func (s *AttachmentsStorage) GetAttachment(ctx context.Context, attachmentId schemas.AttachmentId) (*schemas.Attachment, error) {
+	var attachment schemas.Attachment
+	err := s.attachmentsCollection.FindOne(ctx, bson.M{"_id": string(attachmentId)}).Decode(&attachment)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("%w: attachment %s", storage.ErrNotFound, attachmentId)
+		}
+		return nil, fmt.Errorf("failed to extract, cause %s", err.Error())
+	}
+	return &attachment, nil
+}
+
+// AttachToPost claims attachments one by one in id order, so of two posts racing
+// for the same attachments the one that takes the first of them wins
+func (s *AttachmentsStorage) AttachToPost(ctx context.Context, attachmentIds []schemas.AttachmentId, postId schemas.PostId) error {
+	ordered := make([]schemas.AttachmentId, len(attachmentIds))
+	copy(ordered, attachmentIds)
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i] < ordered[j] })
+
+	for _, attachmentId := range ordered {
+		mongoQuery := bson.M{
+			"_id":      string(attachmentId),
+			"postId":   bson.M{"$exists": false},
+			"avatarOf": bson.M{"$exists": false},
+		}
+		result, err := s.attachmentsCollection.UpdateOne(ctx, mongoQuery, bson.M{"$set": bson.M{"postId": postId}})
+		if err == nil && result.ModifiedCount == 1 {
+			continue
+		}
+
+		// gives back the ones taken before
+		_, rollbackErr := s.attachmentsCollection.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ordered}, "postId": postId}, bson.M{"$unset": bson.M{"postId": ""}})
+		if err != nil {
+			return fmt.Errorf("attaching failed: %s", err.Error())
+		}
+		if rollbackErr != nil {
+			return fmt.Errorf("attaching rollback failed: %s", rollbackErr.Error())
+		}
+		return fmt.Errorf("%w: some of attachments are already used", storage.ErrCollision)
+	}
+	return nil
+}
+
+func (s *AttachmentsStorage) DetachFromPost(ctx context.Context, postId schemas.PostId) error {
+	mongoUpdate := bson.M{"$unset": bson.M{"postId": ""}, "$set": bson.M{"releasedAt": releasedNow()}}
+	_, err := s.attachmentsCollection.UpdateMany(ctx, bson.M{"postId": postId}, mongoUpdate)
+	if err != nil {
+		return fmt.Errorf("detaching failed: %s", err.Error())
+	}
+	return nil
+}
+
+// orphanQuery matches attachments unused since before orphanedBefore
+func orphanQuery(orphanedBefore time.Time) bson.M {
+	return bson.M{
+		"postId":   bson.M{"$exists": false},
+		"avatarOf": bson.M{"$exists": false},
+		"$or": bson.A{
+			bson.M{"releasedAt": bson.M{"$exists": false}, "createdAt": bson.M{"$lt": orphanedBefore}},
+			bson.M{"releasedAt": bson.M{"$lt": orphanedBefore}},
+		},
+	}
+}
+
+func (s *AttachmentsStorage) GetOrphanAttachments(ctx context.Context, orphanedBefore time.Time) ([]*schemas.Attachment, error) {
+	cursor, err := s.attachmentsCollection.Find(ctx, orphanQuery(orphanedBefore))
+	if err != nil {
+		return nil, fmt.Errorf("mongo search failed: %s", err.Error())
+	}
+	var orphans []*schemas.Attachment
+	err = cursor.All(ctx, &orphans)
+	if err != nil {
+		return nil, fmt.Errorf("putting attachments from mongo failed: %s", err.Error())
+	}
+	return orphans, nil
+}
+
+// DeleteAttachment checks the attachment is an orphan in the same query, so a post
+// or an avatar taking it meanwhile keeps it
+func (s *AttachmentsStorage) DeleteAttachment(ctx context.Context, attachmentId schemas.AttachmentId, orphanedBefore time.Time) error {
+	mongoQuery := orphanQuery(orphanedBefore)
+	mongoQuery["_id"] = string(attachmentId)
+	result, err := s.attachmentsCollection.DeleteOne(ctx, mongoQuery)
+	if err != nil {
+		return fmt.Errorf("attachment removal failed: %s", err.Error())
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("%w: orphan attachment %s", storage.ErrNotFound, attachmentId)
+	}
+	return nil
+}
+
+func (s *AttachmentsStorage) SetAvatar(ctx context.Context, attachmentId schemas.AttachmentId, userId schemas.UserId) error {
+	mongoQuery := bson.M{
+		"_id":      string(attachmentId),
+		"postId":   bson.M{"$exists": false},
+		"avatarOf": bson.M{"$exists": false},
+	}
+	result, err := s.attachmentsCollection.UpdateOne(ctx, mongoQuery, bson.M{"$set": bson.M{"avatarOf": userId}})
+	if err != nil {
+		return fmt.Errorf("setting avatar failed: %s", err.Error())
+	}
+	if result.ModifiedCount == 0 {
+		return fmt.Errorf("%w: attachment %s is already used", storage.ErrCollision, attachmentId)
+	}
+	return nil
+}
+
+func (s *AttachmentsStorage) ReleaseAvatar(ctx context.Context, userId schemas.UserId) error {
+	mongoUpdate := bson.M{"$unset": bson.M{"avatarOf": ""}, "$set": bson.M{"releasedAt": releasedNow()}}
+	_, err := s.attachmentsCollection.UpdateMany(ctx, bson.M{"avatarOf": userId}, mongoUpdate)
+	if err != nil {
+		return fmt.Errorf("releasing avatar failed: %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"netwitter/schemas"
+	"netwitter/storage"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryAttachmentsStorage(t *testing.T) {
+	runAttachmentsSuite(t, func(t *testing.T) storage.AttachmentsStorage {
+		return NewInMemoryStorage()
+	})
+}
+
+func TestMongoAttachmentsStorage(t *testing.T) {
+	mongoURL := os.Getenv("MONGO_TEST_URL")
+	if mongoURL == "" {
+		t.Skip("MONGO_TEST_URL is not set")
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(ctx)
+
+	runAttachmentsSuite(t, func(t *testing.T) storage.AttachmentsStorage {
+		mongoName := "mediatest_" + primitive.NewObjectID().Hex()
+		t.Cleanup(func() {
+			_ = client.Database(mongoName).Drop(ctx)
+		})
+		return NewStorage(ctx, mongoURL, mongoName)
+	})
+}
+
+func runAttachmentsSuite(t *testing.T, newStorage func(t *testing.T) storage.AttachmentsStorage) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s storage.AttachmentsStorage)
+	}{
+		{"AttachRace", testAttachRace},
+		{"AttachAllOrNothing", testAttachAllOrNothing},
+		{"DetachFromPost", testDetachFromPost},
+		{"DeleteOnlyOrphans", testDeleteOnlyOrphans},
+		{"ReleasedAreOrphansLater", testReleasedAreOrphansLater},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newStorage(t))
+		})
+	}
+}
+
+func putAttachment(t *testing.T, s storage.AttachmentsStorage) schemas.AttachmentId {
+	t.Helper()
+	return putAttachmentAt(t, s, time.Now())
+}
+
+func putAttachmentAt(t *testing.T, s storage.AttachmentsStorage, createdAt time.Time) schemas.AttachmentId {
+	t.Helper()
+	attachment := schemas.Attachment{
+		ID:          schemas.AttachmentId(primitive.NewObjectID().Hex()),
+		OwnerID:     "owner",
+		ContentType: "image/png",
+		Size:        1,
+		CreatedAt:   createdAt.UTC().Truncate(time.Millisecond),
+	}
+	err := s.PutAttachment(context.Background(), attachment)
+	if err != nil {
+		t.Fatalf("put attachment: %v", err)
+	}
+	return attachment.ID
+}
+
+func attachedTo(t *testing.T, s storage.AttachmentsStorage, attachmentId schemas.AttachmentId) *schemas.PostId {
+	t.Helper()
+	attachment, err := s.GetAttachment(context.Background(), attachmentId)
+	if err != nil {
+		t.Fatalf("get attachment: %v", err)
+	}
+	return attachment.PostID
+}
+
+func testAttachRace(t *testing.T, s storage.AttachmentsStorage) {
+	ctx := context.Background()
+	attachmentIds := []schemas.AttachmentId{putAttachment(t, s), putAttachment(t, s)}
+
+	const racers = 8
+	postIds := make([]schemas.PostId, racers)
+	errs := make([]error, racers)
+	var wg sync.WaitGroup
+	for i := 0; i < racers; i++ {
+		postIds[i] = schemas.NewPostId()
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.AttachToPost(ctx, attachmentIds, postIds[i])
+		}(i)
+	}
+	wg.Wait()
+
+	winner := -1
+	for i, err := range errs {
+		switch {
+		case err == nil && winner == -1:
+			winner = i
+		case err == nil:
+			t.Fatalf("posts %d and %d both took the attachments", winner, i)
+		case !errors.Is(err, storage.ErrCollision):
+			t.Fatalf("expected collision, got %v", err)
+		}
+	}
+	if winner == -1 {
+		t.Fatal("no post took the attachments")
+	}
+	for _, attachmentId := range attachmentIds {
+		postId := attachedTo(t, s, attachmentId)
+		if postId == nil || *postId != postIds[winner] {
+			t.Errorf("attachment %s must belong to the winner %s, got %v", attachmentId, postIds[winner].Hex(), postId)
+		}
+	}
+}
+
+func testAttachAllOrNothing(t *testing.T, s storage.AttachmentsStorage) {
+	ctx := context.Background()
+	free := putAttachment(t, s)
+	taken := putAttachment(t, s)
+	err := s.AttachToPost(ctx, []schemas.AttachmentId{taken}, schemas.NewPostId())
+	if err != nil {
+		t.Fatalf("attach: %v", err)
+	}
+
+	err = s.AttachToPost(ctx, []schemas.AttachmentId{free, taken}, schemas.NewPostId())
+	if !errors.Is(err, storage.ErrCollision) {
+		t.Fatalf("expected collision, got %v", err)
+	}
+	if postId := attachedTo(t, s, free); postId != nil {
+		t.Errorf("failed attaching must not keep %s, got post %s", free, postId.Hex())
+	}
+}
+
+func testDetachFromPost(t *testing.T, s storage.AttachmentsStorage) {
+	ctx := context.Background()
+	attachmentId := putAttachment(t, s)
+	postId := schemas.NewPostId()
+	err := s.AttachToPost(ctx, []schemas.AttachmentId{attachmentId}, postId)
+	if err != nil {
+		t.Fatalf("attach: %v", err)
+	}
+
+	err = s.DetachFromPost(ctx, postId)
+	if err != nil {
+		t.Fatalf("detach: %v", err)
+	}
+	if attachedTo(t, s, attachmentId) != nil {
+		t.Error("detached attachment must be free")
+	}
+	err = s.AttachToPost(ctx, []schemas.AttachmentId{attachmentId}, schemas.NewPostId())
+	if err != nil {
+		t.Errorf("detached attachment must be attachable again: %v", err)
+	}
+}
+
+func assertOrphans(t *testing.T, s storage.AttachmentsStorage, orphanedBefore time.Time, expected ...schemas.AttachmentId) {
+	t.Helper()
+	orphans, err := s.GetOrphanAttachments(context.Background(), orphanedBefore)
+	if err != nil {
+		t.Fatalf("get orphans: %v", err)
+	}
+	found := map[schemas.AttachmentId]bool{}
+	for _, orphan := range orphans {
+		found[orphan.ID] = true
+	}
+	if len(found) != len(expected) {
+		t.Errorf("expected %d orphans, got %d", len(expected), len(found))
+	}
+	for _, attachmentId := range expected {
+		if !found[attachmentId] {
+			t.Errorf("expected %s to be an orphan", attachmentId)
+		}
+	}
+}
+
+func testDeleteOnlyOrphans(t *testing.T, s storage.AttachmentsStorage) {
+	ctx := context.Background()
+	uploadedAt := time.Now().Add(-2 * time.Hour)
+	orphan := putAttachmentAt(t, s, uploadedAt)
+	attached := putAttachmentAt(t, s, uploadedAt)
+	avatar := putAttachmentAt(t, s, uploadedAt)
+	fresh := putAttachment(t, s)
+	orphanedBefore := time.Now().Add(-time.Hour)
+	assertOrphans(t, s, orphanedBefore, orphan, attached, avatar)
+
+	// taken after the orphans were listed
+	err := s.AttachToPost(ctx, []schemas.AttachmentId{attached}, schemas.NewPostId())
+	if err != nil {
+		t.Fatalf("attach: %v", err)
+	}
+	err = s.SetAvatar(ctx, avatar, "owner")
+	if err != nil {
+		t.Fatalf("set avatar: %v", err)
+	}
+
+	for _, attachmentId := range []schemas.AttachmentId{attached, avatar, fresh} {
+		err = s.DeleteAttachment(ctx, attachmentId, orphanedBefore)
+		if !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("expected %s to be kept, got %v", attachmentId, err)
+		}
+		_, err = s.GetAttachment(ctx, attachmentId)
+		if err != nil {
+			t.Errorf("get kept attachment %s: %v", attachmentId, err)
+		}
+	}
+	err = s.DeleteAttachment(ctx, orphan, orphanedBefore)
+	if err != nil {
+		t.Fatalf("delete orphan: %v", err)
+	}
+	_, err = s.GetAttachment(ctx, orphan)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected deleted orphan to be gone, got %v", err)
+	}
+}
+
+func testReleasedAreOrphansLater(t *testing.T, s storage.AttachmentsStorage) {
+	ctx := context.Background()
+	uploadedAt := time.Now().Add(-2 * time.Hour)
+	detached := putAttachmentAt(t, s, uploadedAt)
+	avatar := putAttachmentAt(t, s, uploadedAt)
+	postId := schemas.NewPostId()
+	err := s.AttachToPost(ctx, []schemas.AttachmentId{detached}, postId)
+	if err != nil {
+		t.Fatalf("attach: %v", err)
+	}
+	err = s.SetAvatar(ctx, avatar, "owner")
+	if err != nil {
+		t.Fatalf("set avatar: %v", err)
+	}
+
+	err = s.DetachFromPost(ctx, postId)
+	if err != nil {
+		t.Fatalf("detach: %v", err)
+	}
+	err = s.ReleaseAvatar(ctx, "owner")
+	if err != nil {
+		t.Fatalf("release avatar: %v", err)
+	}
+
+	// the grace period starts over on release
+	orphanedBefore := time.Now().Add(-time.Hour)
+	assertOrphans(t, s, orphanedBefore)
+	err = s.DeleteAttachment(ctx, detached, orphanedBefore)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected released attachment to be kept, got %v", err)
+	}
+	assertOrphans(t, s, time.Now().Add(time.Second), detached, avatar)
+}
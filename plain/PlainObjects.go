@@ -8,32 +8,61 @@ import (
 
 const DefaultPageSize = 10
 
+// GetUserPostsPageData is a page request, Token is empty for the first page.
+// Zero Size means the size the token was issued with, or the default one.
+// A page of items newer than a Newer cursor holds the oldest of them, newest first,
+// and is followed by a head page data, whose Pending is the number of items left.
 type GetUserPostsPageData struct {
-	LastSeenID string
-	Size       int
+	Token   string
+	Size    int
+	Pending int
 }
 
-func CorrectDestruct(pageData GetUserPostsPageData) (*schemas.PostId, int, error) {
+func CorrectDestruct(pageData GetUserPostsPageData) (*Cursor, int, error) {
 	size := pageData.Size
-	switch {
-	case size < 0:
+	if size < 0 {
 		return nil, 0, fmt.Errorf("page size must not be negative: %d", size)
-	case size == 0:
-		size = DefaultPageSize
 	}
 
-	var firstPostID *schemas.PostId
-	if rawID := pageData.LastSeenID; rawID != "" {
-		postID, err := schemas.IDFromRawString(pageData.LastSeenID)
+	var cursor *Cursor
+	if pageData.Token != "" {
+		var err error
+		cursor, err = DecodeCursor(pageData.Token)
 		if err != nil {
-			return nil, 0, fmt.Errorf("invalid post id: %s", err.Error())
+			return nil, 0, err
+		}
+		if size == 0 {
+			size = cursor.Size
 		}
-		firstPostID = &postID
 	}
+	if size == 0 {
+		size = DefaultPageSize
+	}
+
+	return cursor, size, nil
+}
+
+// PostOptions carries optional relations of a newly created post
+type PostOptions struct {
+	// ID is reserved beforehand when something must reference the post before it is published
+	ID          *schemas.PostId
+	ParentID    *schemas.PostId
+	QuotedID    *schemas.PostId
+	Attachments []schemas.Attachment
+}
 
-	return firstPostID, size, nil
+// ProfileUpdate carries changed profile fields only, an empty AvatarID removes the avatar
+type ProfileUpdate struct {
+	DisplayName *string
+	Bio         *string
+	AvatarID    *schemas.AttachmentId
+	Private     *bool
 }
 
+// PostsIterator gives nil once posts are over or reading failed, Err tells which of them.
+// Iterators hold storage resources until closed.
 type PostsIterator interface {
 	GetNextPost(ctx context.Context) *schemas.Post
+	Err() error
+	Close(ctx context.Context) error
 }
@@ -0,0 +1,148 @@
+package plain
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"netwitter/schemas"
+	"time"
+)
+
+// Page tokens are base64url of: version byte, payload, truncated HMAC-SHA256 of both.
+// Clients can neither read nor forge them, and tokens of an older layout are rejected.
+const (
+	tokenVersion = 2
+	tokenMacSize = 16
+)
+
+var ErrInvalidToken = errors.New("invalid page token")
+
+var tokenSecret = newTokenSecret()
+
+func newTokenSecret() []byte {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		panic(fmt.Sprintf("failed to generate token secret: %s", err))
+	}
+	return secret
+}
+
+// SetTokenSecret makes tokens valid across processes sharing the secret,
+// by default tokens are valid only in the process that issued them
+func SetTokenSecret(secret []byte) {
+	tokenSecret = secret
+}
+
+func tokenMac(data []byte) []byte {
+	mac := hmac.New(sha256.New, tokenSecret)
+	mac.Write(data)
+	return mac.Sum(nil)[:tokenMacSize]
+}
+
+func SignToken(payload []byte) string {
+	data := append([]byte{tokenVersion}, payload...)
+	return base64.RawURLEncoding.EncodeToString(append(data, tokenMac(data)...))
+}
+
+func VerifyToken(token string) ([]byte, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(raw) < 1+tokenMacSize {
+		return nil, ErrInvalidToken
+	}
+	data, mac := raw[:len(raw)-tokenMacSize], raw[len(raw)-tokenMacSize:]
+	if !hmac.Equal(mac, tokenMac(data)) {
+		return nil, ErrInvalidToken
+	}
+	if data[0] != tokenVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidToken, data[0])
+	}
+	return data[1:], nil
+}
+
+// Cursor is a position in a list ordered by SortKey and then by ID, both descending.
+// Lists ordered by ID only leave SortKey zero. The item at the cursor itself
+// is not required to exist, so pages stay stable when it is deleted.
+// A Newer cursor asks for items newer than the position instead of older ones.
+type Cursor struct {
+	SortKey int64
+	ID      schemas.PostId
+	Size    int
+	Newer   bool
+}
+
+const cursorPayloadSize = 8 + schemas.LEN + 4 + 1
+
+func (c Cursor) Encode() string {
+	payload := make([]byte, cursorPayloadSize)
+	binary.BigEndian.PutUint64(payload, uint64(c.SortKey))
+	copy(payload[8:], c.ID[:])
+	binary.BigEndian.PutUint32(payload[8+schemas.LEN:], uint32(c.Size))
+	if c.Newer {
+		payload[cursorPayloadSize-1] = 1
+	}
+	return SignToken(payload)
+}
+
+func DecodeCursor(token string) (*Cursor, error) {
+	payload, err := VerifyToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) != cursorPayloadSize {
+		return nil, ErrInvalidToken
+	}
+	cursor := &Cursor{
+		SortKey: int64(binary.BigEndian.Uint64(payload)),
+		Size:    int(binary.BigEndian.Uint32(payload[8+schemas.LEN:])),
+		Newer:   payload[cursorPayloadSize-1] == 1,
+	}
+	copy(cursor.ID[:], payload[8:8+schemas.LEN])
+	return cursor, nil
+}
+
+// After reports whether an item with sortKey and id goes after the cursor
+func (c *Cursor) After(sortKey int64, id schemas.PostId) bool {
+	return sortKey < c.SortKey || sortKey == c.SortKey && id.Hex() < c.ID.Hex()
+}
+
+// Before reports whether an item with sortKey and id goes before the cursor, that is newer than it
+func (c *Cursor) Before(sortKey int64, id schemas.PostId) bool {
+	return sortKey > c.SortKey || sortKey == c.SortKey && id.Hex() > c.ID.Hex()
+}
+
+// NextPage is the page data of the page going after the item with sortKey and id
+func NextPage(sortKey int64, id schemas.PostId, size int) *GetUserPostsPageData {
+	return &GetUserPostsPageData{
+		Token: Cursor{SortKey: sortKey, ID: id, Size: size}.Encode(),
+		Size:  size,
+	}
+}
+
+// HeadPage is the page data of items newer than the item with sortKey and id,
+// pending is the number of such items known at the moment
+func HeadPage(sortKey int64, id schemas.PostId, size int, pending int) *GetUserPostsPageData {
+	return &GetUserPostsPageData{
+		Token:   Cursor{SortKey: sortKey, ID: id, Size: size, Newer: true}.Encode(),
+		Size:    size,
+		Pending: pending,
+	}
+}
+
+// HeadPageAt is the page data of items newer than the moment t, for lists having no items to start from
+func HeadPageAt(t time.Time, size int) *GetUserPostsPageData {
+	return HeadPage(TimeSortKey(t), schemas.FirstIDAt(t), size, 0)
+}
+
+// TimeSortKey is the sort key of lists ordered by time, storages keep times with millisecond precision
+func TimeSortKey(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func TimeFromSortKey(sortKey int64) time.Time {
+	return time.Unix(0, sortKey*int64(time.Millisecond)).UTC()
+}
@@ -0,0 +1,96 @@
+package plain
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"netwitter/schemas"
+	"testing"
+)
+
+// withTokenSecret signs tokens of the test with the secret
+func withTokenSecret(t *testing.T, secret string) {
+	previous := tokenSecret
+	SetTokenSecret([]byte(secret))
+	t.Cleanup(func() {
+		SetTokenSecret(previous)
+	})
+}
+
+func assertInvalidToken(t *testing.T, err error) {
+	t.Helper()
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected invalid token error, got %v", err)
+	}
+}
+
+func TestSignVerifyToken(t *testing.T) {
+	withTokenSecret(t, "test secret")
+	payload := []byte("payload")
+
+	got, err := VerifyToken(SignToken(payload))
+	if err != nil {
+		t.Fatalf("verify token: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("expected payload %q, got %q", payload, got)
+	}
+}
+
+func TestVerifyTamperedToken(t *testing.T) {
+	withTokenSecret(t, "test secret")
+	raw, _ := base64.RawURLEncoding.DecodeString(SignToken([]byte("payload")))
+
+	for _, i := range []int{1, len(raw) - tokenMacSize - 1, len(raw) - 1} {
+		tampered := append([]byte(nil), raw...)
+		tampered[i] ^= 1
+		_, err := VerifyToken(base64.RawURLEncoding.EncodeToString(tampered))
+		assertInvalidToken(t, err)
+	}
+}
+
+func TestVerifyTokenOfOtherVersion(t *testing.T) {
+	withTokenSecret(t, "test secret")
+	// a well signed token of another layout
+	data := []byte{tokenVersion - 1, 'p'}
+	token := base64.RawURLEncoding.EncodeToString(append(data, tokenMac(data)...))
+
+	_, err := VerifyToken(token)
+	assertInvalidToken(t, err)
+}
+
+func TestVerifyMalformedToken(t *testing.T) {
+	withTokenSecret(t, "test secret")
+	token := SignToken([]byte("payload"))
+
+	for _, malformed := range []string{"", "!!!", "not a token", token[:len(token)-1], token[:tokenMacSize], token + "="} {
+		_, err := VerifyToken(malformed)
+		assertInvalidToken(t, err)
+	}
+}
+
+func TestVerifyTokenOfOtherSecret(t *testing.T) {
+	withTokenSecret(t, "first secret")
+	token := SignToken([]byte("payload"))
+
+	SetTokenSecret([]byte("second secret"))
+	_, err := VerifyToken(token)
+	assertInvalidToken(t, err)
+}
+
+func TestCursorEncodeDecode(t *testing.T) {
+	withTokenSecret(t, "test secret")
+	cursor := Cursor{SortKey: 1234, ID: schemas.NewPostId(), Size: 7, Newer: true}
+
+	got, err := DecodeCursor(cursor.Encode())
+	if err != nil {
+		t.Fatalf("decode cursor: %v", err)
+	}
+	if *got != cursor {
+		t.Errorf("expected %+v, got %+v", cursor, *got)
+	}
+
+	// well signed tokens of other lists are not cursors
+	_, err = DecodeCursor(SignToken([]byte("payload")))
+	assertInvalidToken(t, err)
+}
@@ -0,0 +1,62 @@
+package plain
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"netwitter/schemas"
+)
+
+// SearchQuery matches posts containing any of words of Text, AuthorID narrows it when set
+type SearchQuery struct {
+	Text     string
+	AuthorID schemas.UserId
+}
+
+// SearchPageData continues relevance ordered results, Cursor is empty for the first page
+type SearchPageData struct {
+	Cursor string
+	Size   int
+}
+
+// SearchCursor is the last seen result, results go by Score and then by ID descending
+type SearchCursor struct {
+	Score float64
+	ID    schemas.PostId
+}
+
+func (c SearchCursor) Encode() string {
+	payload := make([]byte, 8+schemas.LEN)
+	binary.BigEndian.PutUint64(payload, math.Float64bits(c.Score))
+	copy(payload[8:], c.ID[:])
+	return SignToken(payload)
+}
+
+// After reports whether a result with score and id goes after the cursor
+func (c SearchCursor) After(score float64, id schemas.PostId) bool {
+	return score < c.Score || score == c.Score && id.Hex() < c.ID.Hex()
+}
+
+func CorrectDestructSearch(pageData SearchPageData) (*SearchCursor, int, error) {
+	size := pageData.Size
+	switch {
+	case size < 0:
+		return nil, 0, fmt.Errorf("page size must not be negative: %d", size)
+	case size == 0:
+		size = DefaultPageSize
+	}
+
+	if pageData.Cursor == "" {
+		return nil, size, nil
+	}
+	payload, err := VerifyToken(pageData.Cursor)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(payload) != 8+schemas.LEN {
+		return nil, 0, ErrInvalidToken
+	}
+	cursor := &SearchCursor{Score: math.Float64frombits(binary.BigEndian.Uint64(payload))}
+	copy(cursor.ID[:], payload[8:])
+	return cursor, size, nil
+}
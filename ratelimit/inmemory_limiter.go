@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often counters of ended windows are dropped
+const sweepInterval = time.Minute
+
+type memoryCounter struct {
+	count   int
+	resetAt time.Time
+}
+
+// MemoryLimiter counts requests within the process, it is meant for single binary runs
+// and as a fallback of RedisLimiter
+type MemoryLimiter struct {
+	mu sync.Mutex
+
+	counters  map[string]*memoryCounter
+	nextSweep time.Time
+}
+
+func NewInMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		counters: map[string]*memoryCounter{},
+	}
+}
+
+func (l *MemoryLimiter) Allow(_ context.Context, subject string, policy Policy) (Decision, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.After(l.nextSweep) {
+		l.sweep(now)
+	}
+
+	key := counterKey(subject, policy)
+	counter, ok := l.counters[key]
+	if !ok || !now.Before(counter.resetAt) {
+		counter = &memoryCounter{resetAt: now.Add(policy.Window)}
+		l.counters[key] = counter
+	}
+	counter.count++
+	return decide(policy, counter.count, counter.resetAt.Sub(now)), nil
+}
+
+func (l *MemoryLimiter) sweep(now time.Time) {
+	for key, counter := range l.counters {
+		if !now.Before(counter.resetAt) {
+			delete(l.counters, key)
+		}
+	}
+	l.nextSweep = now.Add(sweepInterval)
+}
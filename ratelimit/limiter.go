@@ -0,0 +1,46 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+const keyPrefix = "ntwt:ratelimit:"
+
+// Policy lets Limit requests of a subject in every Window, windows are fixed
+// and start with the first request. Name separates counters of policies.
+type Policy struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+}
+
+// Decision is the state of the subject counter after a request
+type Decision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// ResetAfter is when the window ends and the counter starts over
+	ResetAfter time.Duration
+}
+
+type Limiter interface {
+	Allow(ctx context.Context, subject string, policy Policy) (Decision, error)
+}
+
+func decide(policy Policy, count int, resetAfter time.Duration) Decision {
+	remaining := policy.Limit - count
+	if remaining < 0 {
+		remaining = 0
+	}
+	return Decision{
+		Allowed:    count <= policy.Limit,
+		Limit:      policy.Limit,
+		Remaining:  remaining,
+		ResetAfter: resetAfter,
+	}
+}
+
+func counterKey(subject string, policy Policy) string {
+	return keyPrefix + policy.Name + ":" + subject
+}
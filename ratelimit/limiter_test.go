@@ -0,0 +1,159 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	runLimiterSuite(t, func(t *testing.T) Limiter {
+		return NewInMemoryLimiter()
+	})
+}
+
+func TestRedisLimiter(t *testing.T) {
+	redisURL := os.Getenv("REDIS_TEST_URL")
+	if redisURL == "" {
+		t.Skip("REDIS_TEST_URL is not set")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: redisURL})
+	defer client.Close()
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+	runLimiterSuite(t, func(t *testing.T) Limiter {
+		return NewRedisLimiter(client, failingLimiter{t})
+	})
+}
+
+// TestRedisLimiterFallback runs the suite against a redis nobody listens to
+func TestRedisLimiterFallback(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialTimeout: 100 * time.Millisecond})
+	defer client.Close()
+	runLimiterSuite(t, func(t *testing.T) Limiter {
+		return NewRedisLimiter(client, NewInMemoryLimiter())
+	})
+}
+
+// failingLimiter fails tests that must not fall back
+type failingLimiter struct {
+	t *testing.T
+}
+
+func (l failingLimiter) Allow(context.Context, string, Policy) (Decision, error) {
+	l.t.Error("limiter fell back to local counters")
+	return Decision{}, nil
+}
+
+func runLimiterSuite(t *testing.T, newLimiter func(t *testing.T) Limiter) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, l Limiter)
+	}{
+		{"WindowBoundary", testWindowBoundary},
+		{"SeparateCounters", testSeparateCounters},
+		{"ConcurrentRequests", testConcurrentRequests},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newLimiter(t))
+		})
+	}
+}
+
+// testSubject keeps counters of every test apart in a shared redis
+func testSubject() string {
+	return "user:" + primitive.NewObjectID().Hex()
+}
+
+func allow(t *testing.T, l Limiter, subject string, policy Policy) Decision {
+	t.Helper()
+	decision, err := l.Allow(context.Background(), subject, policy)
+	if err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+	return decision
+}
+
+func testWindowBoundary(t *testing.T, l Limiter) {
+	policy := Policy{Name: "boundary", Limit: 2, Window: 300 * time.Millisecond}
+	subject := testSubject()
+
+	start := time.Now()
+	for i, expected := range []Decision{{Allowed: true, Remaining: 1}, {Allowed: true, Remaining: 0}, {Allowed: false, Remaining: 0}, {Allowed: false, Remaining: 0}} {
+		decision := allow(t, l, subject, policy)
+		if decision.Allowed != expected.Allowed || decision.Remaining != expected.Remaining || decision.Limit != policy.Limit {
+			t.Errorf("request %d: expected allowed %v with %d remaining, got %+v", i, expected.Allowed, expected.Remaining, decision)
+		}
+		if decision.ResetAfter <= 0 || decision.ResetAfter > policy.Window-time.Since(start)+10*time.Millisecond {
+			t.Errorf("request %d: window must end within the first request window, resets after %s", i, decision.ResetAfter)
+		}
+	}
+
+	time.Sleep(policy.Window - time.Since(start) + 50*time.Millisecond)
+	decision := allow(t, l, subject, policy)
+	if !decision.Allowed || decision.Remaining != 1 {
+		t.Errorf("new window must start over, got %+v", decision)
+	}
+}
+
+func testSeparateCounters(t *testing.T, l Limiter) {
+	policy := Policy{Name: "separate", Limit: 1, Window: time.Minute}
+	otherPolicy := Policy{Name: "other", Limit: 1, Window: time.Minute}
+	subject := testSubject()
+
+	if !allow(t, l, subject, policy).Allowed {
+		t.Fatal("first request must be allowed")
+	}
+	if allow(t, l, subject, policy).Allowed {
+		t.Error("request over the limit must be denied")
+	}
+	if !allow(t, l, testSubject(), policy).Allowed {
+		t.Error("other subject must have its own counter")
+	}
+	if !allow(t, l, subject, otherPolicy).Allowed {
+		t.Error("other policy must have its own counter")
+	}
+}
+
+func testConcurrentRequests(t *testing.T, l Limiter) {
+	const workers = 16
+	const requests = 25
+	policy := Policy{Name: "concurrent", Limit: 100, Window: time.Minute}
+	subject := testSubject()
+
+	allowed := make([]int, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < requests; j++ {
+				decision, err := l.Allow(context.Background(), subject, policy)
+				if err != nil {
+					t.Errorf("allow: %v", err)
+					return
+				}
+				if decision.Allowed {
+					allowed[i]++
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	total := 0
+	for _, count := range allowed {
+		total += count
+	}
+	if total != policy.Limit {
+		t.Errorf("expected %d allowed requests of %d, got %d", policy.Limit, workers*requests, total)
+	}
+}
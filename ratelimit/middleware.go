@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"github.com/gorilla/mux"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"netwitter/auth"
+	"strconv"
+	"time"
+)
+
+// RouteKey names a route in policies of Middleware, like "POST /api/v1/posts"
+func RouteKey(method string, pathTemplate string) string {
+	return method + " " + pathTemplate
+}
+
+// Middleware limits requests to routes with a policy, keyed by RouteKey of the matched
+// route. Requests are counted per authenticated user, anonymous ones per client ip,
+// so it goes after auth.Middleware. Limited responses get RateLimit-* headers,
+// rejected ones 429 with Retry-After.
+func Middleware(limiter Limiter, policies map[string]Policy) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			route := mux.CurrentRoute(r)
+			if route == nil {
+				next.ServeHTTP(rw, r)
+				return
+			}
+			pathTemplate, err := route.GetPathTemplate()
+			if err != nil {
+				next.ServeHTTP(rw, r)
+				return
+			}
+			policy, ok := policies[RouteKey(r.Method, pathTemplate)]
+			if !ok {
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			decision, err := limiter.Allow(r.Context(), requestSubject(r), policy)
+			if err != nil {
+				// limits are not worth failing requests for
+				log.Printf("Rate limiting failed: %s", err)
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			resetSeconds := strconv.Itoa(ceilSeconds(decision.ResetAfter))
+			rw.Header().Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+			rw.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+			rw.Header().Set("RateLimit-Reset", resetSeconds)
+			if !decision.Allowed {
+				rw.Header().Set("Retry-After", resetSeconds)
+				http.Error(rw, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+func requestSubject(r *http.Request) string {
+	if userId := auth.UserFromContext(r.Context()); userId != "" {
+		return "user:" + string(userId)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,131 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"netwitter/auth"
+	"netwitter/schemas"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var testPolicies = map[string]Policy{
+	RouteKey(http.MethodPost, "/api/v1/posts/{postId}/repost"): {Name: "reposts", Limit: 2, Window: time.Minute},
+}
+
+func newTestRouter(limiter Limiter) *mux.Router {
+	router := mux.NewRouter()
+	ok := func(rw http.ResponseWriter, r *http.Request) {}
+	router.HandleFunc("/api/v1/posts/{postId}/repost", ok).Methods(http.MethodPost)
+	router.HandleFunc("/api/v1/posts/{postId}", ok).Methods(http.MethodGet)
+	router.Use(Middleware(limiter, testPolicies))
+	return router
+}
+
+// request calls the router as the user, or anonymously from the ip when the user is empty
+func request(router *mux.Router, method string, target string, userId schemas.UserId, ip string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	r.RemoteAddr = ip + ":34567"
+	if userId != "" {
+		r = r.WithContext(auth.WithUser(r.Context(), userId))
+	}
+	rw := httptest.NewRecorder()
+	router.ServeHTTP(rw, r)
+	return rw
+}
+
+func repost(router *mux.Router, userId schemas.UserId, ip string) int {
+	return request(router, http.MethodPost, "/api/v1/posts/1/repost", userId, ip).Code
+}
+
+func TestMiddlewareHeaders(t *testing.T) {
+	router := newTestRouter(NewInMemoryLimiter())
+
+	for remaining := 1; remaining >= 0; remaining-- {
+		rw := request(router, http.MethodPost, "/api/v1/posts/1/repost", "user", "10.0.0.1")
+		if rw.Code != http.StatusOK {
+			t.Fatalf("expected %d, got %d", http.StatusOK, rw.Code)
+		}
+		if rw.Header().Get("RateLimit-Limit") != "2" || rw.Header().Get("RateLimit-Remaining") != strconv.Itoa(remaining) {
+			t.Errorf("expected limit 2 with %d remaining, got headers %v", remaining, rw.Header())
+		}
+		if rw.Header().Get("Retry-After") != "" {
+			t.Error("allowed request must not get Retry-After")
+		}
+	}
+
+	rw := request(router, http.MethodPost, "/api/v1/posts/2/repost", "user", "10.0.0.1")
+	if rw.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected %d, got %d", http.StatusTooManyRequests, rw.Code)
+	}
+	if rw.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("expected nothing remaining, got %q", rw.Header().Get("RateLimit-Remaining"))
+	}
+	retryAfter, err := strconv.Atoi(rw.Header().Get("Retry-After"))
+	if err != nil || retryAfter < 1 || retryAfter > 60 {
+		t.Errorf("expected Retry-After within the window, got %q", rw.Header().Get("Retry-After"))
+	}
+	if rw.Header().Get("RateLimit-Reset") != rw.Header().Get("Retry-After") {
+		t.Errorf("RateLimit-Reset must match Retry-After, got %q", rw.Header().Get("RateLimit-Reset"))
+	}
+}
+
+func TestMiddlewareSubjects(t *testing.T) {
+	router := newTestRouter(NewInMemoryLimiter())
+	for i := 0; i < 2; i++ {
+		if code := repost(router, "user", "10.0.0.1"); code != http.StatusOK {
+			t.Fatalf("expected %d, got %d", http.StatusOK, code)
+		}
+	}
+
+	cases := []struct {
+		name   string
+		userId schemas.UserId
+		ip     string
+		code   int
+	}{
+		{"SameUserOtherIp", "user", "10.0.0.2", http.StatusTooManyRequests},
+		{"OtherUserSameIp", "other", "10.0.0.1", http.StatusOK},
+		{"AnonymousSameIp", "", "10.0.0.1", http.StatusOK},
+		{"AnonymousSameIpAgain", "", "10.0.0.1", http.StatusOK},
+		{"AnonymousSameIpOverLimit", "", "10.0.0.1", http.StatusTooManyRequests},
+		{"AnonymousOtherIp", "", "10.0.0.2", http.StatusOK},
+	}
+	for _, c := range cases {
+		if code := repost(router, c.userId, c.ip); code != c.code {
+			t.Errorf("%s: expected %d, got %d", c.name, c.code, code)
+		}
+	}
+}
+
+func TestMiddlewareUnlimitedRoute(t *testing.T) {
+	router := newTestRouter(NewInMemoryLimiter())
+	for i := 0; i < 5; i++ {
+		rw := request(router, http.MethodGet, "/api/v1/posts/1", "user", "10.0.0.1")
+		if rw.Code != http.StatusOK {
+			t.Fatalf("expected %d, got %d", http.StatusOK, rw.Code)
+		}
+		if rw.Header().Get("RateLimit-Limit") != "" {
+			t.Fatal("route without a policy must not get RateLimit headers")
+		}
+	}
+}
+
+type brokenLimiter struct{}
+
+func (brokenLimiter) Allow(context.Context, string, Policy) (Decision, error) {
+	return Decision{}, errors.New("broken")
+}
+
+func TestMiddlewareLimiterError(t *testing.T) {
+	router := newTestRouter(brokenLimiter{})
+	for i := 0; i < 5; i++ {
+		if code := repost(router, "user", "10.0.0.1"); code != http.StatusOK {
+			t.Fatalf("failing limiter must let requests through, got %d", code)
+		}
+	}
+}
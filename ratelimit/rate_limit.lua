@@ -0,0 +1,15 @@
+local key = KEYS[1]
+local windowMs = tonumber(ARGV[1])
+
+-- Counts the request and returns the count with the time left in the window.
+-- The count is taken before the caller compares it with the limit, so denied
+-- requests are counted too. The window starts with the first request and only
+-- that one sets the expiry, so later requests never extend the window.
+
+local count = redis.call("INCR", key)
+local ttlMs = redis.call("PTTL", key)
+if ttlMs < 0 then
+    redis.call("PEXPIRE", key, windowMs)
+    ttlMs = windowMs
+end
+return {count, ttlMs}
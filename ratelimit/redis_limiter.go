@@ -0,0 +1,42 @@
+package ratelimit
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"log"
+	"time"
+)
+
+//go:embed rate_limit.lua
+var rateLimitSource string
+var rateLimitScript = redis.NewScript(rateLimitSource)
+
+// RedisLimiter keeps counters in redis, so limits hold across server replicas.
+// While redis fails, requests are counted by the fallback of every replica on its own.
+type RedisLimiter struct {
+	client   *redis.Client
+	fallback Limiter
+}
+
+func NewRedisLimiter(client *redis.Client, fallback Limiter) *RedisLimiter {
+	return &RedisLimiter{client: client, fallback: fallback}
+}
+
+func (l *RedisLimiter) Allow(ctx context.Context, subject string, policy Policy) (Decision, error) {
+	keys := []string{counterKey(subject, policy)}
+	returned, err := rateLimitScript.Run(ctx, l.client, keys, policy.Window.Milliseconds()).Result()
+	if err != nil {
+		log.Printf("Rate limiting falls back to local counters: redis error: %s", err)
+		return l.fallback.Allow(ctx, subject, policy)
+	}
+
+	counter, ok := returned.([]interface{})
+	if !ok || len(counter) != 2 {
+		return Decision{}, fmt.Errorf("unexpected rate limit script result: %v", returned)
+	}
+	count, _ := counter[0].(int64)
+	ttlMs, _ := counter[1].(int64)
+	return decide(policy, int(count), time.Duration(ttlMs)*time.Millisecond), nil
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
 )
 
 type PostId primitive.ObjectID
@@ -42,3 +43,12 @@ func IDFromRawString(s string) (PostId, error) {
 func (id PostId) Hex() string {
 	return primitive.ObjectID(id).Hex()
 }
+
+func NewPostId() PostId {
+	return PostId(primitive.NewObjectID())
+}
+
+// FirstIDAt is not greater than ids of posts created in the second of t or later
+func FirstIDAt(t time.Time) PostId {
+	return PostId(primitive.NewObjectIDFromTimestamp(t))
+}
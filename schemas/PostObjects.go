@@ -14,23 +14,110 @@ type Post struct {
 	Content        Text      `bson:"text"`
 	CreatedAt      time.Time `bson:"createdAt"`
 	LastModifiedAt time.Time `bson:"lastModifiedAt"`
+	ParentID       *PostId   `bson:"parentId,omitempty"`
+	QuotedID       *PostId   `bson:"quotedId,omitempty"`
+	Mentions       []Mention `bson:"mentions,omitempty"`
+	Hashtags       []Hashtag `bson:"hashtags,omitempty"`
+	// Attachments are copies of attachment metadata taken at post creation
+	Attachments []Attachment `bson:"attachments,omitempty"`
+	// RepostedBy is set only on posts taken from someone's feed
+	RepostedBy UserId `bson:"repostedBy,omitempty"`
 }
 
 type PostData struct {
-	ID             string `json:"id"`
-	Content        Text   `json:"text"`
-	AuthorID       string `json:"authorId"`
-	CreatedAt      string `json:"createdAt"`
-	LastModifiedAt string `json:"lastModifiedAt"`
+	ID             string           `json:"id"`
+	Version        int              `json:"version"`
+	Content        Text             `json:"text"`
+	AuthorID       string           `json:"authorId"`
+	CreatedAt      string           `json:"createdAt"`
+	LastModifiedAt string           `json:"lastModifiedAt"`
+	ParentID       string           `json:"parentId,omitempty"`
+	QuotedID       string           `json:"quotedId,omitempty"`
+	RepostedBy     string           `json:"repostedBy,omitempty"`
+	Mentions       []MentionData    `json:"mentions,omitempty"`
+	Hashtags       []Hashtag        `json:"hashtags,omitempty"`
+	Attachments    []AttachmentData `json:"attachments,omitempty"`
+	LikeCount      int              `json:"likeCount"`
+	LikedByMe      bool             `json:"likedByMe"`
+}
+
+// LikesSummary is what a viewer sees about likes of a post
+type LikesSummary struct {
+	Count     int
+	LikedByMe bool
+}
+
+func (pd *PostData) SetLikes(summary LikesSummary) {
+	pd.LikeCount = summary.Count
+	pd.LikedByMe = summary.LikedByMe
 }
 
 func (p *Post) ToPostData() PostData {
-	return PostData{
+	postData := PostData{
 		ID:             p.ID.ToBase64URL(),
+		Version:        p.Version,
 		Content:        p.Content,
 		AuthorID:       string(p.AuthorID),
 		CreatedAt:      p.CreatedAt.UTC().Format(time.RFC3339),
 		LastModifiedAt: p.LastModifiedAt.UTC().Format(time.RFC3339),
+		RepostedBy:     string(p.RepostedBy),
+		Hashtags:       p.Hashtags,
+	}
+	if p.ParentID != nil {
+		postData.ParentID = p.ParentID.ToBase64URL()
+	}
+	if p.QuotedID != nil {
+		postData.QuotedID = p.QuotedID.ToBase64URL()
+	}
+	for i := range p.Attachments {
+		postData.Attachments = append(postData.Attachments, p.Attachments[i].ToAttachmentData())
+	}
+	for _, mention := range p.Mentions {
+		postData.Mentions = append(postData.Mentions, mention.ToMentionData())
+	}
+	return postData
+}
+
+// PostThreadData is a conversation tree rooted at Post
+type PostThreadData struct {
+	Post    PostData         `json:"post"`
+	Replies []PostThreadData `json:"replies"`
+}
+
+// PostRevision is a snapshot of a post taken right before it was edited
+type PostRevision struct {
+	ID             PostId    `bson:"_id"`
+	PostID         PostId    `bson:"postId"`
+	Version        int       `bson:"version"`
+	Content        Text      `bson:"text"`
+	LastModifiedAt time.Time `bson:"lastModifiedAt"`
+}
+
+type PostRevisionData struct {
+	ID             string `json:"id"`
+	PostID         string `json:"postId"`
+	Version        int    `json:"version"`
+	Content        Text   `json:"text"`
+	LastModifiedAt string `json:"lastModifiedAt"`
+}
+
+func (p *Post) NewRevision(id PostId) *PostRevision {
+	return &PostRevision{
+		ID:             id,
+		PostID:         p.ID,
+		Version:        p.Version,
+		Content:        p.Content,
+		LastModifiedAt: p.LastModifiedAt,
+	}
+}
+
+func (r *PostRevision) ToPostRevisionData() PostRevisionData {
+	return PostRevisionData{
+		ID:             r.ID.ToBase64URL(),
+		PostID:         r.PostID.ToBase64URL(),
+		Version:        r.Version,
+		Content:        r.Content,
+		LastModifiedAt: r.LastModifiedAt.UTC().Format(time.RFC3339),
 	}
 }
 
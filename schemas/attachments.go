@@ -0,0 +1,49 @@
+package schemas
+
+import (
+	"time"
+)
+
+// AttachmentURLPrefix is where attachment contents are served from
+const AttachmentURLPrefix = "/api/v1/media/"
+
+type AttachmentId string
+
+// Attachment is an uploaded blob, it lives on its own until a post takes it
+type Attachment struct {
+	ID          AttachmentId `bson:"_id"`
+	OwnerID     UserId       `bson:"ownerId"`
+	ContentType string       `bson:"contentType"`
+	Size        int64        `bson:"size"`
+	CreatedAt   time.Time    `bson:"createdAt"`
+	// PostID is nil while no post uses the attachment
+	PostID *PostId `bson:"postId,omitempty"`
+	// AvatarOf is set while the attachment is an avatar of the user, it is not taken by posts then
+	AvatarOf *UserId `bson:"avatarOf,omitempty"`
+	// ReleasedAt is when a post or a user last left the attachment
+	ReleasedAt *time.Time `bson:"releasedAt,omitempty"`
+}
+
+// OrphanedAt is when the attachment was left unused, uploads are unused from the start
+func (a *Attachment) OrphanedAt() time.Time {
+	if a.ReleasedAt != nil {
+		return *a.ReleasedAt
+	}
+	return a.CreatedAt
+}
+
+type AttachmentData struct {
+	ID          string `json:"id"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	URL         string `json:"url"`
+}
+
+func (a *Attachment) ToAttachmentData() AttachmentData {
+	return AttachmentData{
+		ID:          string(a.ID),
+		ContentType: a.ContentType,
+		Size:        a.Size,
+		URL:         AttachmentURLPrefix + string(a.ID),
+	}
+}
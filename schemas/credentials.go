@@ -0,0 +1,12 @@
+package schemas
+
+import (
+	"time"
+)
+
+// Credentials let a user get access tokens, passwords are kept only as bcrypt hashes
+type Credentials struct {
+	UserID       UserId    `bson:"_id"`
+	PasswordHash []byte    `bson:"passwordHash"`
+	CreatedAt    time.Time `bson:"createdAt"`
+}
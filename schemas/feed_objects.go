@@ -0,0 +1,13 @@
+package schemas
+
+import (
+	"time"
+)
+
+// FeedEntry is a position of a post in a personal feed, the post itself is read from posts storage
+type FeedEntry struct {
+	PostID     PostId
+	AuthorID   UserId
+	AddedAt    time.Time
+	RepostedBy UserId
+}
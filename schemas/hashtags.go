@@ -0,0 +1,29 @@
+package schemas
+
+import (
+	"regexp"
+	"strings"
+)
+
+var hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_])#([\p{L}\p{N}_]+)`)
+
+// Hashtag is kept normalized: lowercase and without the leading '#'
+type Hashtag string
+
+func NormalizeHashtag(raw string) Hashtag {
+	return Hashtag(strings.ToLower(strings.TrimPrefix(raw, "#")))
+}
+
+// ExtractHashtags returns distinct hashtags in order of appearance, nil if there are none
+func ExtractHashtags(text Text) []Hashtag {
+	var hashtags []Hashtag
+	seen := map[Hashtag]bool{}
+	for _, match := range hashtagPattern.FindAllStringSubmatch(string(text), -1) {
+		hashtag := NormalizeHashtag(match[1])
+		if !seen[hashtag] {
+			seen[hashtag] = true
+			hashtags = append(hashtags, hashtag)
+		}
+	}
+	return hashtags
+}
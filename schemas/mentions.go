@@ -0,0 +1,46 @@
+package schemas
+
+import (
+	"regexp"
+	"unicode/utf8"
+)
+
+// mentionPattern matches @userId not glued to a preceding word, so emails are not mentions
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_])(@([\p{L}\p{N}_-]+))`)
+
+// Mention is a reference to a user inside post text.
+// Offset and Length are counted in characters and cover the leading '@'.
+type Mention struct {
+	UserID UserId `bson:"userId"`
+	Offset int    `bson:"offset"`
+	Length int    `bson:"length"`
+}
+
+type MentionData struct {
+	UserID string `json:"userId"`
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+}
+
+// ExtractMentions returns mentions in order of appearance, nil if there are none
+func ExtractMentions(text Text) []Mention {
+	rawText := string(text)
+	var mentions []Mention
+	for _, match := range mentionPattern.FindAllStringSubmatchIndex(rawText, -1) {
+		start, end := match[2], match[3]
+		mentions = append(mentions, Mention{
+			UserID: UserId(rawText[match[4]:match[5]]),
+			Offset: utf8.RuneCountInString(rawText[:start]),
+			Length: utf8.RuneCountInString(rawText[start:end]),
+		})
+	}
+	return mentions
+}
+
+func (m Mention) ToMentionData() MentionData {
+	return MentionData{
+		UserID: string(m.UserID),
+		Offset: m.Offset,
+		Length: m.Length,
+	}
+}
@@ -1,5 +1,9 @@
 package schemas
 
+import (
+	"time"
+)
+
 type usersList struct {
 	Users []string `json:"users"`
 }
@@ -15,3 +19,37 @@ func UsersListFromUsers(users []UserId) usersList {
 		Users: ul,
 	}
 }
+
+// User is a registered profile, ID is the handle other users refer to
+type User struct {
+	ID          UserId        `bson:"_id"`
+	DisplayName string        `bson:"displayName"`
+	Bio         string        `bson:"bio"`
+	AvatarID    *AttachmentId `bson:"avatarId,omitempty"`
+	// Private accounts approve every subscriber, only those see their posts
+	Private   bool      `bson:"private"`
+	CreatedAt time.Time `bson:"createdAt"`
+}
+
+type UserData struct {
+	Handle      string `json:"handle"`
+	DisplayName string `json:"displayName"`
+	Bio         string `json:"bio"`
+	AvatarURL   string `json:"avatarUrl,omitempty"`
+	Private     bool   `json:"private"`
+	CreatedAt   string `json:"createdAt"`
+}
+
+func (u *User) ToUserData() UserData {
+	userData := UserData{
+		Handle:      string(u.ID),
+		DisplayName: u.DisplayName,
+		Bio:         u.Bio,
+		Private:     u.Private,
+		CreatedAt:   u.CreatedAt.UTC().Format(time.RFC3339),
+	}
+	if u.AvatarID != nil {
+		userData.AvatarURL = AttachmentURLPrefix + string(*u.AvatarID)
+	}
+	return userData
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"log"
+	"netwitter/auth"
+	"netwitter/feed"
+	"netwitter/idempotency"
+	"netwitter/likes"
+	"netwitter/media"
+	"netwitter/plain"
+	"netwitter/ratelimit"
+	"netwitter/storage"
+	"netwitter/storage/inmemory"
+	"netwitter/storage/mongostorage"
+	"netwitter/storage/rediscached"
+	"netwitter/users"
+	"netwitter/workers"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	storageModeInMemory = "inmemory"
+	storageModeMongo    = "mongo"
+	storageModeCached   = "cached"
+
+	defaultStorageMode    = storageModeMongo
+	defaultCacheTTL       = time.Minute
+	defaultMediaDir       = "data/media"
+	defaultTokenTTL       = 24 * time.Hour
+	defaultIdempotencyTTL = 24 * time.Hour
+	// orphan uploads are looked for that often, they are removed after media.OrphanGracePeriod
+	defaultMediaCleanupInterval = 10 * time.Minute
+	// feedHeadSize entries of every feed are kept in redis in cached mode
+	feedHeadSize = 100
+	// authors with more subscribers are pulled to feeds instead of pushed
+	defaultFanoutThreshold = 10000
+	// shorter secrets are placeholders like "change-me" or too easy to guess
+	minSecretLength = 16
+)
+
+// appStack is what both server and worker are built of
+type appStack struct {
+	scheduler    workers.Scheduler
+	postsStorage storage.Storage
+	usersStorage storage.UsersStorage
+	profiles     storage.ProfilesStorage
+	feedStorage  storage.FeedStorage
+	likesStorage storage.LikesStorage
+	mediaStorage storage.AttachmentsStorage
+	credentials  storage.CredentialsStorage
+	feedEvents   feed.EventBus
+	rateLimiter  ratelimit.Limiter
+	idempotency  idempotency.Store
+	feedManager  *feed.FeedManager
+	usersManager *users.UsersManager
+	mediaManager *media.MediaManager
+	authManager  *auth.AuthManager
+	// isLocal means tasks are executed by the serving process itself
+	isLocal bool
+}
+
+// newAppStack builds storages for STORAGE_MODE:
+// inmemory - everything in process memory, tasks are executed locally;
+// mongo - mongo storages, tasks are sent to workers and feed events to servers through redis,
+// rate limit counters and idempotent responses are kept in redis too;
+// cached - mongo posts and feed storages behind redis cache with CACHE_TTL.
+// Attachment contents are kept in MEDIA_DIR in every mode, orphan uploads are removed
+// every MEDIA_CLEANUP_INTERVAL.
+// Posts of authors with more than FANOUT_THRESHOLD subscribers are not pushed to feeds.
+// Page tokens are signed with CURSOR_SECRET and access tokens with AUTH_SECRET
+// for TOKEN_TTL, both must be set and shared by all servers.
+// Responses to requests with idempotency keys are replayed for IDEMPOTENCY_TTL.
+func newAppStack(ctx context.Context, storageMode string) *appStack {
+	if storageMode == "" {
+		storageMode = defaultStorageMode
+	}
+	setCursorSecret()
+
+	stack := &appStack{}
+	switch storageMode {
+	case storageModeInMemory:
+		scheduler := workers.NewLocalScheduler()
+		stack.scheduler = scheduler
+		stack.isLocal = true
+		stack.postsStorage = inmemory.NewInMemoryStorage(scheduler)
+		stack.usersStorage = users.NewInMemoryStorage()
+		stack.profiles = users.NewInMemoryProfilesStorage()
+		stack.feedStorage = feed.NewInMemoryStorage()
+		stack.likesStorage = likes.NewInMemoryStorage()
+		stack.mediaStorage = media.NewInMemoryStorage()
+		stack.credentials = auth.NewInMemoryStorage()
+		stack.feedEvents = feed.NewLocalEventBus()
+		stack.rateLimiter = ratelimit.NewInMemoryLimiter()
+		stack.idempotency = idempotency.NewInMemoryStore()
+	case storageModeMongo, storageModeCached:
+		mongoURL := os.Getenv("MONGO_URL")
+		if mongoURL == "" {
+			panic(fmt.Errorf("empty mongo url"))
+		}
+		dbName := os.Getenv("MONGO_DBNAME")
+		if dbName == "" {
+			panic(fmt.Errorf("empty mongo dbname"))
+		}
+		redisURL := os.Getenv("REDIS_URL")
+		if redisURL == "" {
+			panic(fmt.Errorf("empty broker url"))
+		}
+
+		scheduler := workers.NewMachineryScheduler(redisURL)
+		stack.scheduler = scheduler
+		stack.postsStorage = mongostorage.NewStorage(mongoURL, dbName, scheduler)
+		stack.usersStorage = users.NewStorage(ctx, mongoURL, dbName)
+		stack.profiles = users.NewProfilesStorage(ctx, mongoURL, dbName)
+		stack.feedStorage = feed.NewStorage(ctx, mongoURL, dbName)
+		stack.likesStorage = likes.NewStorage(ctx, mongoURL, dbName)
+		stack.mediaStorage = media.NewStorage(ctx, mongoURL, dbName)
+		stack.credentials = auth.NewStorage(ctx, mongoURL, dbName)
+
+		redisClient := redis.NewClient(&redis.Options{Addr: redisURL})
+		stack.feedEvents = feed.NewRedisEventBus(redisClient)
+		stack.rateLimiter = ratelimit.NewRedisLimiter(redisClient, ratelimit.NewInMemoryLimiter())
+		stack.idempotency = idempotency.NewRedisStore(redisClient)
+		if storageMode == storageModeCached {
+			stack.postsStorage = rediscached.NewCachedStorage(stack.postsStorage, redisClient, cacheTTL())
+			stack.feedStorage = rediscached.NewCachedFeedStorage(stack.feedStorage, redisClient, feedHeadSize, cacheTTL())
+		}
+	default:
+		panic(fmt.Errorf("unexpected storage mode: %s", storageMode))
+	}
+	log.Printf("Storage mode: %s", storageMode)
+
+	stack.feedManager = feed.NewFeedManager(stack.postsStorage, stack.usersStorage, stack.feedStorage, stack.feedEvents, fanoutThreshold())
+	stack.usersManager = users.NewUsersManager(stack.usersStorage, stack.profiles, stack.scheduler)
+	stack.mediaManager = media.NewMediaManager(media.NewLocalBlobStore(mediaDir()), stack.mediaStorage)
+	stack.authManager = auth.NewAuthManager(stack.credentials, authSecret(), tokenTTL())
+
+	executor := workers.NewPostsTasksExecutor(*stack.feedManager)
+	err := stack.scheduler.Register(*executor)
+	if err != nil {
+		panic(err)
+	}
+	return stack
+}
+
+func cacheTTL() time.Duration {
+	rawTTL := os.Getenv("CACHE_TTL")
+	if rawTTL == "" {
+		return defaultCacheTTL
+	}
+	ttl, err := time.ParseDuration(rawTTL)
+	if err != nil {
+		panic(fmt.Errorf("invalid cache ttl: %w", err))
+	}
+	return ttl
+}
+
+func mediaDir() string {
+	dir := os.Getenv("MEDIA_DIR")
+	if dir == "" {
+		return defaultMediaDir
+	}
+	return dir
+}
+
+func mediaCleanupInterval() time.Duration {
+	rawInterval := os.Getenv("MEDIA_CLEANUP_INTERVAL")
+	if rawInterval == "" {
+		return defaultMediaCleanupInterval
+	}
+	interval, err := time.ParseDuration(rawInterval)
+	if err != nil {
+		panic(fmt.Errorf("invalid media cleanup interval: %w", err))
+	}
+	if interval <= 0 {
+		panic(fmt.Errorf("media cleanup interval must be positive, got %s", interval))
+	}
+	return interval
+}
+
+func fanoutThreshold() int {
+	rawThreshold := os.Getenv("FANOUT_THRESHOLD")
+	if rawThreshold == "" {
+		return defaultFanoutThreshold
+	}
+	threshold, err := strconv.Atoi(rawThreshold)
+	if err != nil {
+		panic(fmt.Errorf("invalid fanout threshold: %w", err))
+	}
+	return threshold
+}
+
+func setCursorSecret() {
+	plain.SetTokenSecret(requiredSecret("CURSOR_SECRET"))
+}
+
+// requiredSecret refuses to start with a secret that is unset or a placeholder
+func requiredSecret(name string) []byte {
+	secret := os.Getenv(name)
+	if secret == "" {
+		panic(fmt.Errorf("%s is not set", name))
+	}
+	if len(secret) < minSecretLength {
+		panic(fmt.Errorf("%s must be a random value of at least %d characters", name, minSecretLength))
+	}
+	return []byte(secret)
+}
+
+func authSecret() []byte {
+	return requiredSecret("AUTH_SECRET")
+}
+
+func tokenTTL() time.Duration {
+	rawTTL := os.Getenv("TOKEN_TTL")
+	if rawTTL == "" {
+		return defaultTokenTTL
+	}
+	ttl, err := time.ParseDuration(rawTTL)
+	if err != nil {
+		panic(fmt.Errorf("invalid token ttl: %w", err))
+	}
+	return ttl
+}
+
+func idempotencyTTL() time.Duration {
+	rawTTL := os.Getenv("IDEMPOTENCY_TTL")
+	if rawTTL == "" {
+		return defaultIdempotencyTTL
+	}
+	ttl, err := time.ParseDuration(rawTTL)
+	if err != nil {
+		panic(fmt.Errorf("invalid idempotency ttl: %w", err))
+	}
+	return ttl
+}
+
+// allowUserHeader keeps trusting the user id header for internal test traffic,
+// anyone can act as any user then, so it is never enabled by default
+func allowUserHeader() bool {
+	allowed := os.Getenv("AUTH_ALLOW_USER_HEADER") == "true"
+	if allowed {
+		log.Printf("AUTH_ALLOW_USER_HEADER is set, %s header is trusted without a token", auth.UserIdHeader)
+	}
+	return allowed
+}
@@ -0,0 +1,107 @@
+package inmemory
+
+import (
+	"context"
+	"netwitter/plain"
+	"netwitter/schemas"
+	"sort"
+)
+
+// postList keeps posts sorted by id in ascending order
+type postList []*schemas.Post
+
+func (pl postList) insert(post *schemas.Post) postList {
+	pl = append(pl, post)
+	for i := len(pl) - 1; i > 0 && pl[i].ID.Hex() < pl[i-1].ID.Hex(); i-- {
+		pl[i-1], pl[i] = pl[i], pl[i-1]
+	}
+	return pl
+}
+
+func (pl postList) remove(postId schemas.PostId) postList {
+	for i := range pl {
+		if pl[i].ID == postId {
+			return append(pl[:i], pl[i+1:]...)
+		}
+	}
+	return pl
+}
+
+// page returns copies of posts newest first, starting right after the page cursor
+func (pl postList) page(pageData plain.GetUserPostsPageData) ([]*schemas.Post, *plain.GetUserPostsPageData, error) {
+	cursor, size, err := plain.CorrectDestruct(pageData)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cursor != nil && cursor.Newer {
+		return pl.newerPage(cursor, size)
+	}
+
+	end := len(pl)
+	if cursor != nil {
+		end = sort.Search(len(pl), func(i int) bool {
+			return pl[i].ID.Hex() >= cursor.ID.Hex()
+		})
+	}
+
+	start := MaxInt(0, end-size)
+	pack := make([]*schemas.Post, 0, end-start)
+	for i := end - 1; i >= start; i-- {
+		pack = append(pack, pl[i].Copy())
+	}
+
+	var nextPageToken *plain.GetUserPostsPageData
+	if start > 0 {
+		nextPageToken = plain.NextPage(0, pl[start].ID, size)
+	}
+	return pack, nextPageToken, nil
+}
+
+// newerPage returns copies of size posts going right before the cursor, newest first
+func (pl postList) newerPage(cursor *plain.Cursor, size int) ([]*schemas.Post, *plain.GetUserPostsPageData, error) {
+	start := sort.Search(len(pl), func(i int) bool {
+		return pl[i].ID.Hex() > cursor.ID.Hex()
+	})
+	end := MinInt(len(pl), start+size)
+
+	pack := make([]*schemas.Post, 0, end-start)
+	for i := end - 1; i >= start; i-- {
+		pack = append(pack, pl[i].Copy())
+	}
+
+	if len(pack) == 0 {
+		return pack, plain.HeadPage(0, cursor.ID, size, 0), nil
+	}
+	return pack, plain.HeadPage(0, pack[0].ID, size, len(pl)-end), nil
+}
+
+// snapshot copies posts so they can be iterated without holding the storage lock
+func (pl postList) snapshot() *postsIterator {
+	posts := make([]*schemas.Post, len(pl))
+	for i := range pl {
+		posts[i] = pl[i].Copy()
+	}
+	return &postsIterator{posts: posts}
+}
+
+type postsIterator struct {
+	posts []*schemas.Post
+}
+
+func (pi *postsIterator) GetNextPost(_ context.Context) *schemas.Post {
+	if len(pi.posts) == 0 {
+		return nil
+	}
+	post := pi.posts[0]
+	pi.posts = pi.posts[1:]
+	return post
+}
+
+func (pi *postsIterator) Err() error {
+	return nil
+}
+
+func (pi *postsIterator) Close(_ context.Context) error {
+	pi.posts = nil
+	return nil
+}
@@ -0,0 +1,54 @@
+package inmemory
+
+import (
+	"netwitter/schemas"
+	"strings"
+	"unicode"
+)
+
+// searchIndex is an inverted index from words to term frequencies in posts
+type searchIndex map[string]map[schemas.PostId]int
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func (si searchIndex) add(post *schemas.Post) {
+	for _, word := range tokenize(string(post.Content)) {
+		frequencies, ok := si[word]
+		if !ok {
+			frequencies = map[schemas.PostId]int{}
+			si[word] = frequencies
+		}
+		frequencies[post.ID]++
+	}
+}
+
+func (si searchIndex) remove(post *schemas.Post) {
+	for _, word := range tokenize(string(post.Content)) {
+		delete(si[word], post.ID)
+		if len(si[word]) == 0 {
+			delete(si, word)
+		}
+	}
+}
+
+// scores ranks posts containing any word of text by term frequencies weighted with the
+// number of times the word is in text. Like scores of mongo text search, they do not
+// depend on other posts, so search cursors stay valid while posts are written.
+func (si searchIndex) scores(text string) map[schemas.PostId]float64 {
+	weights := map[string]int{}
+	for _, word := range tokenize(text) {
+		weights[word]++
+	}
+
+	scores := map[schemas.PostId]float64{}
+	for word, weight := range weights {
+		for postId, frequency := range si[word] {
+			scores[postId] += float64(frequency * weight)
+		}
+	}
+	return scores
+}
@@ -6,46 +6,87 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"netwitter/plain"
 	"netwitter/schemas"
+	"netwitter/storage"
+	"netwitter/workers"
 	"sort"
 	"sync"
 	"time"
 )
 
+// MemoryStorage publishes the same tasks as persistent storages do.
+// Tasks are published after the lock is released, so a local scheduler
+// is free to read the storage while running them.
 type MemoryStorage struct {
-	mu sync.RWMutex
+	mu        sync.RWMutex
+	scheduler workers.Scheduler
 
-	postById     map[schemas.PostId]*schemas.Post
-	postByAuthor map[schemas.UserId][]*schemas.Post
+	postById        map[schemas.PostId]*schemas.Post
+	postByAuthor    map[schemas.UserId]postList
+	repliesByParent map[schemas.PostId]postList
+	mentionsByUser  map[schemas.UserId]postList
+	postsByHashtag  map[schemas.Hashtag]postList
+	searchIndex     searchIndex
+	revisionsByPost map[schemas.PostId][]*schemas.PostRevision
+	repostsByPost   map[schemas.PostId]map[schemas.UserId]time.Time
+	repostsByUser   map[schemas.UserId]map[schemas.PostId]time.Time
 }
 
-func NewInMemoryStorage() *MemoryStorage {
+func NewInMemoryStorage(scheduler workers.Scheduler) *MemoryStorage {
 	return &MemoryStorage{
-		postById:     map[schemas.PostId]*schemas.Post{},
-		postByAuthor: map[schemas.UserId][]*schemas.Post{},
+		scheduler:       scheduler,
+		postById:        map[schemas.PostId]*schemas.Post{},
+		postByAuthor:    map[schemas.UserId]postList{},
+		repliesByParent: map[schemas.PostId]postList{},
+		mentionsByUser:  map[schemas.UserId]postList{},
+		postsByHashtag:  map[schemas.Hashtag]postList{},
+		searchIndex:     searchIndex{},
+		revisionsByPost: map[schemas.PostId][]*schemas.PostRevision{},
+		repostsByPost:   map[schemas.PostId]map[schemas.UserId]time.Time{},
+		repostsByUser:   map[schemas.UserId]map[schemas.PostId]time.Time{},
 	}
 }
-func (s *MemoryStorage) PutPost(_ context.Context, userId schemas.UserId, text schemas.Text) (*schemas.Post, error) {
+
+func (s *MemoryStorage) PutPost(_ context.Context, userId schemas.UserId, text schemas.Text, opts plain.PostOptions) (*schemas.Post, error) {
+	newPost := s.putPost(userId, text, opts)
+	err := s.scheduler.PublishSpreadPostOverSubs(userId, newPost.ID)
+	if err != nil {
+		return nil, err
+	}
+	return newPost, nil
+}
+
+func (s *MemoryStorage) putPost(userId schemas.UserId, text schemas.Text, opts plain.PostOptions) *schemas.Post {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	postId := schemas.NewPostId()
+	if opts.ID != nil {
+		postId = *opts.ID
+	}
+	now := s.Now()
 	newPost := &schemas.Post{
-		ID:        schemas.PostId(primitive.NewObjectID()),
-		AuthorID:  userId,
-		Content:   text,
-		CreatedAt: time.Now(),
+		ID:             postId,
+		AuthorID:       userId,
+		Content:        text,
+		CreatedAt:      now,
+		LastModifiedAt: now,
+		ParentID:       opts.ParentID,
+		QuotedID:       opts.QuotedID,
+		Mentions:       schemas.ExtractMentions(text),
+		Hashtags:       schemas.ExtractHashtags(text),
+		Attachments:    opts.Attachments,
 	}
 
 	s.postById[newPost.ID] = newPost
-	userPostList := s.postByAuthor[userId]
-	userPostList = append(userPostList, newPost)
-	for i := len(userPostList) - 1; i > 0 && userPostList[i].ID.Hex() < userPostList[i-1].ID.Hex(); i-- {
-		userPostList[i-1], userPostList[i] = userPostList[i], userPostList[i-1]
+	s.postByAuthor[userId] = s.postByAuthor[userId].insert(newPost)
+	if newPost.ParentID != nil {
+		s.repliesByParent[*newPost.ParentID] = s.repliesByParent[*newPost.ParentID].insert(newPost)
 	}
-	s.postByAuthor[userId] = userPostList
+	s.indexText(newPost)
 
 	var result schemas.Post
 	result = *newPost
-	return &result, nil
+	return &result
 }
 
 func (s *MemoryStorage) GetPost(_ context.Context, postId schemas.PostId) (*schemas.Post, error) {
@@ -54,7 +95,7 @@ func (s *MemoryStorage) GetPost(_ context.Context, postId schemas.PostId) (*sche
 
 	post, ok := s.postById[postId]
 	if !ok {
-		return nil, fmt.Errorf("not found: %s", postId)
+		return nil, fmt.Errorf("%w: %s", storage.ErrNotFound, postId)
 	}
 
 	var result schemas.Post
@@ -63,60 +104,326 @@ func (s *MemoryStorage) GetPost(_ context.Context, postId schemas.PostId) (*sche
 }
 
 func (s *MemoryStorage) GetUserPosts(_ context.Context, authorID schemas.UserId, pageData plain.GetUserPostsPageData) ([]*schemas.Post, *plain.GetUserPostsPageData, error) {
-	lastSeenID, size, err := plain.CorrectDestruct(pageData)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.postByAuthor[authorID].page(pageData)
+}
+
+func (s *MemoryStorage) GetPostReplies(_ context.Context, postId schemas.PostId, pageData plain.GetUserPostsPageData) ([]*schemas.Post, *plain.GetUserPostsPageData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.repliesByParent[postId].page(pageData)
+}
+
+func (s *MemoryStorage) GetUserMentions(_ context.Context, userId schemas.UserId, pageData plain.GetUserPostsPageData) ([]*schemas.Post, *plain.GetUserPostsPageData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mentionsByUser[userId].page(pageData)
+}
+
+func (s *MemoryStorage) GetHashtagPosts(_ context.Context, hashtag schemas.Hashtag, pageData plain.GetUserPostsPageData) ([]*schemas.Post, *plain.GetUserPostsPageData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.postsByHashtag[hashtag].page(pageData)
+}
+
+func (s *MemoryStorage) SearchPosts(_ context.Context, query plain.SearchQuery, pageData plain.SearchPageData) ([]*schemas.Post, *plain.SearchPageData, error) {
+	cursor, size, err := plain.CorrectDestructSearch(pageData)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	userPostList := s.postByAuthor[authorID]
 
-	lastSeenIndex := len(userPostList)
-	if lastSeenID != nil {
-		lastSeenIndex = sort.Search(len(userPostList), func(i int) bool {
-			return userPostList[i].ID.Hex() >= lastSeenID.Hex()
-		})
-		if lastSeenIndex == len(userPostList) || userPostList[lastSeenIndex].ID != *lastSeenID {
-			return nil, nil, fmt.Errorf("incorrect page token: %s", lastSeenID)
+	type result struct {
+		post  *schemas.Post
+		score float64
+	}
+	var results []result
+	for postId, score := range s.searchIndex.scores(query.Text) {
+		post := s.postById[postId]
+		if query.AuthorID != "" && post.AuthorID != query.AuthorID {
+			continue
 		}
+		if cursor != nil && !cursor.After(score, postId) {
+			continue
+		}
+		results = append(results, result{post: post, score: score})
 	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return results[i].post.ID.Hex() > results[j].post.ID.Hex()
+	})
 
-	nextPackStart := MaxInt(0, lastSeenIndex-1)
-	nextPackEnd := MaxInt(0, nextPackStart+1-size)
-	packSize := lastSeenIndex - nextPackEnd
+	var nextPage *plain.SearchPageData
+	if len(results) > size {
+		last := results[size-1]
+		nextPage = &plain.SearchPageData{
+			Cursor: plain.SearchCursor{Score: last.score, ID: last.post.ID}.Encode(),
+			Size:   size,
+		}
+		results = results[:size]
+	}
 
-	pack := make([]*schemas.Post, packSize)
-	for i := 0; i < packSize; i++ {
-		pack[i] = userPostList[nextPackStart-i].Copy()
+	posts := make([]*schemas.Post, len(results))
+	for i := range results {
+		posts[i] = results[i].post.Copy()
 	}
+	return posts, nextPage, nil
+}
 
-	var nextPageToken *plain.GetUserPostsPageData
-	if nextPackEnd > 0 {
-		nextPageToken = &plain.GetUserPostsPageData{
-			LastSeenID: userPostList[nextPackEnd].ID.ToBase64URL(),
-			Size:       size,
+// indexText puts post once to the timeline of every mentioned user and every hashtag
+// and makes it searchable
+func (s *MemoryStorage) indexText(post *schemas.Post) {
+	s.searchIndex.add(post)
+	for _, userId := range mentionedUsers(post) {
+		s.mentionsByUser[userId] = s.mentionsByUser[userId].insert(post)
+	}
+	for _, hashtag := range post.Hashtags {
+		s.postsByHashtag[hashtag] = s.postsByHashtag[hashtag].insert(post)
+	}
+}
+
+func (s *MemoryStorage) unindexText(post *schemas.Post) {
+	s.searchIndex.remove(post)
+	for _, userId := range mentionedUsers(post) {
+		s.mentionsByUser[userId] = s.mentionsByUser[userId].remove(post.ID)
+	}
+	for _, hashtag := range post.Hashtags {
+		s.postsByHashtag[hashtag] = s.postsByHashtag[hashtag].remove(post.ID)
+	}
+}
+
+func mentionedUsers(post *schemas.Post) []schemas.UserId {
+	seen := map[schemas.UserId]bool{}
+	var users []schemas.UserId
+	for _, mention := range post.Mentions {
+		if !seen[mention.UserID] {
+			seen[mention.UserID] = true
+			users = append(users, mention.UserID)
 		}
 	}
-	return pack, nextPageToken, nil
+	return users
 }
 
-func (s *MemoryStorage) EditPost(ctx context.Context, postId schemas.PostId, authorId schemas.UserId, text schemas.Text) (*schemas.Post, error) {
+func (s *MemoryStorage) GetAllPostsFromUser(_ context.Context, authorId schemas.UserId) (plain.PostsIterator, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.postByAuthor[authorId].snapshot(), nil
+}
+
+func (s *MemoryStorage) GetAllRepliesToPost(_ context.Context, postId schemas.PostId) (plain.PostsIterator, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.repliesByParent[postId].snapshot(), nil
+}
+
+func (s *MemoryStorage) EditPost(_ context.Context, postId schemas.PostId, authorId schemas.UserId, text schemas.Text) (*schemas.Post, error) {
+	editedPost, err := s.editPost(postId, authorId, text)
+	if err != nil {
+		return nil, err
+	}
+	err = s.scheduler.PublishSpreadPostOverSubs(editedPost.AuthorID, editedPost.ID)
+	if err != nil {
+		return nil, err
+	}
+	return editedPost, nil
+}
+
+func (s *MemoryStorage) editPost(postId schemas.PostId, authorId schemas.UserId, text schemas.Text) (*schemas.Post, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	post, ok := s.postById[postId]
-	if !ok {
-		return nil, fmt.Errorf("not found: %s", postId)
+	if !ok || post.AuthorID != authorId {
+		return nil, fmt.Errorf("%w: %s", storage.ErrNotFound, postId)
 	}
+	// revisions are appended in ascending id order like posts of an author
+	revision := post.NewRevision(schemas.PostId(primitive.NewObjectID()))
+	s.revisionsByPost[postId] = append(s.revisionsByPost[postId], revision)
+
+	s.unindexText(post)
 	post.Content = text
-	post.LastModifiedAt = time.Now()
+	post.Mentions = schemas.ExtractMentions(text)
+	post.Hashtags = schemas.ExtractHashtags(text)
+	post.LastModifiedAt = s.Now()
+	post.Version++
+	s.indexText(post)
 	return post.Copy(), nil
 }
 
+func (s *MemoryStorage) DeletePost(_ context.Context, postId schemas.PostId, authorId schemas.UserId) error {
+	err := s.deletePost(postId, authorId)
+	if err != nil {
+		return err
+	}
+	return s.scheduler.PublishRetractPostFromSubs(authorId, postId)
+}
+
+func (s *MemoryStorage) deletePost(postId schemas.PostId, authorId schemas.UserId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	post, ok := s.postById[postId]
+	if !ok || post.AuthorID != authorId {
+		return fmt.Errorf("%w: %s", storage.ErrNotFound, postId)
+	}
+	delete(s.postById, postId)
+	delete(s.revisionsByPost, postId)
+	for reposter := range s.repostsByPost[postId] {
+		delete(s.repostsByUser[reposter], postId)
+	}
+	delete(s.repostsByPost, postId)
+
+	s.postByAuthor[authorId] = s.postByAuthor[authorId].remove(postId)
+	s.unindexText(post)
+	if post.ParentID != nil {
+		s.repliesByParent[*post.ParentID] = s.repliesByParent[*post.ParentID].remove(postId)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) PutRepost(_ context.Context, userId schemas.UserId, postId schemas.PostId) error {
+	err := s.putRepost(userId, postId)
+	if err != nil {
+		return err
+	}
+	return s.scheduler.PublishSpreadRepostOverSubs(userId, postId)
+}
+
+func (s *MemoryStorage) putRepost(userId schemas.UserId, postId schemas.PostId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.postById[postId]; !ok {
+		return fmt.Errorf("%w: %s", storage.ErrNotFound, postId)
+	}
+	reposters, ok := s.repostsByPost[postId]
+	if !ok {
+		reposters = map[schemas.UserId]time.Time{}
+		s.repostsByPost[postId] = reposters
+	}
+	if _, ok = reposters[userId]; ok {
+		return fmt.Errorf("%w: %s already reposted %s", storage.ErrCollision, userId, postId)
+	}
+	reposters[userId] = s.Now()
+
+	reposted, ok := s.repostsByUser[userId]
+	if !ok {
+		reposted = map[schemas.PostId]time.Time{}
+		s.repostsByUser[userId] = reposted
+	}
+	reposted[postId] = reposters[userId]
+	return nil
+}
+
+func (s *MemoryStorage) GetUserReposts(_ context.Context, userId schemas.UserId, pageData plain.GetUserPostsPageData) ([]*schemas.FeedEntry, *plain.GetUserPostsPageData, error) {
+	cursor, size, err := plain.CorrectDestruct(pageData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.RLock()
+	reposts := make([]*schemas.FeedEntry, 0, len(s.repostsByUser[userId]))
+	for postId, repostedAt := range s.repostsByUser[userId] {
+		reposts = append(reposts, &schemas.FeedEntry{
+			PostID:     postId,
+			AuthorID:   s.postById[postId].AuthorID,
+			AddedAt:    repostedAt,
+			RepostedBy: userId,
+		})
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(reposts, func(i, j int) bool {
+		if !reposts[i].AddedAt.Equal(reposts[j].AddedAt) {
+			return reposts[i].AddedAt.After(reposts[j].AddedAt)
+		}
+		return reposts[i].PostID.Hex() > reposts[j].PostID.Hex()
+	})
+
+	if cursor != nil && cursor.Newer {
+		// reposts newer than the cursor go before it, the oldest of them are taken
+		end := sort.Search(len(reposts), func(i int) bool {
+			return !cursor.Before(plain.TimeSortKey(reposts[i].AddedAt), reposts[i].PostID)
+		})
+		start := MaxInt(0, end-size)
+		reposts = reposts[start:end]
+		if len(reposts) == 0 {
+			return reposts, plain.HeadPage(cursor.SortKey, cursor.ID, size, 0), nil
+		}
+		return reposts, plain.HeadPage(plain.TimeSortKey(reposts[0].AddedAt), reposts[0].PostID, size, start), nil
+	}
+
+	if cursor != nil {
+		start := sort.Search(len(reposts), func(i int) bool {
+			return cursor.After(plain.TimeSortKey(reposts[i].AddedAt), reposts[i].PostID)
+		})
+		reposts = reposts[start:]
+	}
+
+	var nextPage *plain.GetUserPostsPageData
+	if len(reposts) > size {
+		last := reposts[size-1]
+		nextPage = plain.NextPage(plain.TimeSortKey(last.AddedAt), last.PostID, size)
+		reposts = reposts[:size]
+	}
+	return reposts, nextPage, nil
+}
+
+func (s *MemoryStorage) GetPostRevisions(_ context.Context, postId schemas.PostId, pageData plain.GetUserPostsPageData) ([]*schemas.PostRevision, *plain.GetUserPostsPageData, error) {
+	cursor, size, err := plain.CorrectDestruct(pageData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cursor != nil && cursor.Newer {
+		return nil, nil, fmt.Errorf("%w: newer revisions are not listed", plain.ErrInvalidToken)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	revisionList := s.revisionsByPost[postId]
+
+	end := len(revisionList)
+	if cursor != nil {
+		end = sort.Search(len(revisionList), func(i int) bool {
+			return revisionList[i].ID.Hex() >= cursor.ID.Hex()
+		})
+	}
+
+	start := MaxInt(0, end-size)
+	pack := make([]*schemas.PostRevision, 0, end-start)
+	for i := end - 1; i >= start; i-- {
+		revision := *revisionList[i]
+		pack = append(pack, &revision)
+	}
+
+	var nextPageToken *plain.GetUserPostsPageData
+	if start > 0 {
+		nextPageToken = plain.NextPage(0, revisionList[start].ID, size)
+	}
+	return pack, nextPageToken, nil
+}
+
+// Now has the same precision as mongo storage, so posts are alike in both storages
+func (s *MemoryStorage) Now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
 func MaxInt(a int, b int) int {
 	if a > b {
 		return a
 	}
 	return b
 }
+
+func MinInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
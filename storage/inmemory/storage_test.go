@@ -0,0 +1,13 @@
+package inmemory
+
+import (
+	"netwitter/storage"
+	"netwitter/storage/storagetest"
+	"testing"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storagetest.RunStorageSuite(t, func(t *testing.T) storage.Storage {
+		return NewInMemoryStorage(storagetest.NopScheduler{})
+	})
+}
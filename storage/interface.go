@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"netwitter/plain"
 	"netwitter/schemas"
+	"time"
 )
 
 var (
@@ -15,20 +16,94 @@ var (
 )
 
 type Storage interface {
-	PutPost(ctx context.Context, userId schemas.UserId, text schemas.Text) (*schemas.Post, error)
+	PutPost(ctx context.Context, userId schemas.UserId, text schemas.Text, opts plain.PostOptions) (*schemas.Post, error)
 	GetPost(ctx context.Context, postId schemas.PostId) (*schemas.Post, error)
 	EditPost(ctx context.Context, postId schemas.PostId, authorId schemas.UserId, text schemas.Text) (*schemas.Post, error)
+	DeletePost(ctx context.Context, postId schemas.PostId, authorId schemas.UserId) error
+	PutRepost(ctx context.Context, userId schemas.UserId, postId schemas.PostId) error
+	// GetUserReposts pages reposts of the user like a personal feed, AddedAt is the repost time
+	GetUserReposts(ctx context.Context, userId schemas.UserId, pageData plain.GetUserPostsPageData) (_ []*schemas.FeedEntry, nextPage *plain.GetUserPostsPageData, _ error)
 	GetUserPosts(ctx context.Context, authorID schemas.UserId, pageData plain.GetUserPostsPageData) (_ []*schemas.Post, nextPage *plain.GetUserPostsPageData, _ error)
 	GetAllPostsFromUser(ctx context.Context, authorId schemas.UserId) (plain.PostsIterator, error)
+	GetPostReplies(ctx context.Context, postId schemas.PostId, pageData plain.GetUserPostsPageData) (_ []*schemas.Post, nextPage *plain.GetUserPostsPageData, _ error)
+	GetAllRepliesToPost(ctx context.Context, postId schemas.PostId) (plain.PostsIterator, error)
+	GetUserMentions(ctx context.Context, userId schemas.UserId, pageData plain.GetUserPostsPageData) (_ []*schemas.Post, nextPage *plain.GetUserPostsPageData, _ error)
+	GetHashtagPosts(ctx context.Context, hashtag schemas.Hashtag, pageData plain.GetUserPostsPageData) (_ []*schemas.Post, nextPage *plain.GetUserPostsPageData, _ error)
+	SearchPosts(ctx context.Context, query plain.SearchQuery, pageData plain.SearchPageData) (_ []*schemas.Post, nextPage *plain.SearchPageData, _ error)
+	GetPostRevisions(ctx context.Context, postId schemas.PostId, pageData plain.GetUserPostsPageData) (_ []*schemas.PostRevision, nextPage *plain.GetUserPostsPageData, _ error)
 }
 
 type UsersStorage interface {
 	MakeSubscription(ctx context.Context, subscriber schemas.UserId, to schemas.UserId) error
+	RemoveSubscription(ctx context.Context, subscriber schemas.UserId, from schemas.UserId) error
 	GetUserSubscriptions(ctx context.Context, userId schemas.UserId) ([]schemas.UserId, error)
 	GetUserSubscribers(ctx context.Context, userId schemas.UserId) ([]schemas.UserId, error)
+	IsSubscribed(ctx context.Context, subscriber schemas.UserId, to schemas.UserId) (bool, error)
+	CountUserSubscribers(ctx context.Context, userId schemas.UserId) (int, error)
+	// MarkPulledAuthor makes posts of the author pulled on feed reads for good
+	MarkPulledAuthor(ctx context.Context, authorId schemas.UserId) error
+	IsPulledAuthor(ctx context.Context, authorId schemas.UserId) (bool, error)
+	// GetPulledSubscriptions returns marked pulled authors the user is subscribed to
+	GetPulledSubscriptions(ctx context.Context, userId schemas.UserId) ([]schemas.UserId, error)
+	PutBlock(ctx context.Context, blocker schemas.UserId, blocked schemas.UserId) error
+	RemoveBlock(ctx context.Context, blocker schemas.UserId, blocked schemas.UserId) error
+	GetBlockedUsers(ctx context.Context, userId schemas.UserId) ([]schemas.UserId, error)
+	// IsBlockedBetween tells whether either of the users blocked the other
+	IsBlockedBetween(ctx context.Context, first schemas.UserId, second schemas.UserId) (bool, error)
+	PutMute(ctx context.Context, muter schemas.UserId, muted schemas.UserId) error
+	RemoveMute(ctx context.Context, muter schemas.UserId, muted schemas.UserId) error
+	GetMutedUsers(ctx context.Context, userId schemas.UserId) ([]schemas.UserId, error)
+	PutFollowRequest(ctx context.Context, requester schemas.UserId, target schemas.UserId) error
+	// RemoveFollowRequest fails with ErrNotFound when there is no such request
+	RemoveFollowRequest(ctx context.Context, requester schemas.UserId, target schemas.UserId) error
+	GetFollowRequests(ctx context.Context, target schemas.UserId) ([]schemas.UserId, error)
+}
+
+type ProfilesStorage interface {
+	// PutProfile fails with ErrCollision when the handle is taken
+	PutProfile(ctx context.Context, user schemas.User) error
+	GetProfile(ctx context.Context, userId schemas.UserId) (*schemas.User, error)
+	UpdateProfile(ctx context.Context, userId schemas.UserId, update plain.ProfileUpdate) (*schemas.User, error)
+	DeleteProfile(ctx context.Context, userId schemas.UserId) error
+}
+
+type LikesStorage interface {
+	PutLike(ctx context.Context, userId schemas.UserId, postId schemas.PostId) error
+	RemoveLike(ctx context.Context, userId schemas.UserId, postId schemas.PostId) error
+	// DeletePost removes all likes of a deleted post
+	DeletePost(ctx context.Context, postId schemas.PostId) error
+	GetPostLikers(ctx context.Context, postId schemas.PostId) ([]schemas.UserId, error)
+	GetLikesSummary(ctx context.Context, viewer schemas.UserId, postIds []schemas.PostId) (map[schemas.PostId]schemas.LikesSummary, error)
+}
+
+type AttachmentsStorage interface {
+	PutAttachment(ctx context.Context, attachment schemas.Attachment) error
+	GetAttachment(ctx context.Context, attachmentId schemas.AttachmentId) (*schemas.Attachment, error)
+	// AttachToPost takes all of the attachments or none of them, ErrCollision when any is already used
+	AttachToPost(ctx context.Context, attachmentIds []schemas.AttachmentId, postId schemas.PostId) error
+	DetachFromPost(ctx context.Context, postId schemas.PostId) error
+	GetOrphanAttachments(ctx context.Context, orphanedBefore time.Time) ([]*schemas.Attachment, error)
+	// DeleteAttachment removes the attachment only while it is still unused since before orphanedBefore,
+	// ErrNotFound otherwise
+	DeleteAttachment(ctx context.Context, attachmentId schemas.AttachmentId, orphanedBefore time.Time) error
+	// SetAvatar takes only an attachment neither a post nor another avatar uses
+	SetAvatar(ctx context.Context, attachmentId schemas.AttachmentId, userId schemas.UserId) error
+	ReleaseAvatar(ctx context.Context, userId schemas.UserId) error
+}
+
+type CredentialsStorage interface {
+	// PutCredentials fails with ErrCollision when the user already has credentials
+	PutCredentials(ctx context.Context, credentials schemas.Credentials) error
+	GetCredentials(ctx context.Context, userId schemas.UserId) (*schemas.Credentials, error)
 }
 
 type FeedStorage interface {
 	PutPostToFeed(ctx context.Context, userId schemas.UserId, post schemas.Post) error
-	GetUserFeed(ctx context.Context, userId schemas.UserId, data plain.GetUserPostsPageData) ([]*schemas.Post, *plain.GetUserPostsPageData, error)
+	PutRepostToFeed(ctx context.Context, userId schemas.UserId, post schemas.Post, reposter schemas.UserId) error
+	RemovePostFromFeeds(ctx context.Context, postId schemas.PostId) error
+	// RemoveAuthorFromFeed removes posts of the author and posts the author reposted, posts of
+	// the author reposted by others stay. Removed reposts are returned, so the posts the user
+	// still gets from their authors can be put back.
+	RemoveAuthorFromFeed(ctx context.Context, userId schemas.UserId, authorId schemas.UserId) ([]*schemas.FeedEntry, error)
+	GetUserFeed(ctx context.Context, userId schemas.UserId, data plain.GetUserPostsPageData) ([]*schemas.FeedEntry, *plain.GetUserPostsPageData, error)
 }
@@ -10,15 +10,48 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"netwitter/plain"
 	"netwitter/schemas"
+	netstorage "netwitter/storage"
 	"netwitter/workers"
 	"time"
 )
 
-const collName = "posts"
+const (
+	collName          = "posts"
+	revisionsCollName = "revisions"
+	repostsCollName   = "reposts"
+)
 
 type storage struct {
-	postsCollection *mongo.Collection
-	scheduler       workers.Scheduler
+	postsCollection     *mongo.Collection
+	revisionsCollection *mongo.Collection
+	repostsCollection   *mongo.Collection
+	scheduler           workers.Scheduler
+}
+
+type searchResult struct {
+	schemas.Post `bson:",inline"`
+	Score        float64 `bson:"score"`
+}
+
+type repostInfo struct {
+	UserID    schemas.UserId `bson:"userId"`
+	PostID    schemas.PostId `bson:"postId"`
+	CreatedAt time.Time      `bson:"createdAt"`
+}
+
+// repostEntry is a repost joined with the author of the reposted post
+type repostEntry struct {
+	repostInfo `bson:",inline"`
+	AuthorID   schemas.UserId `bson:"authorId"`
+}
+
+func (r *repostEntry) toFeedEntry() *schemas.FeedEntry {
+	return &schemas.FeedEntry{
+		PostID:     r.PostID,
+		AuthorID:   r.AuthorID,
+		AddedAt:    r.CreatedAt,
+		RepostedBy: r.UserID,
+	}
 }
 
 func NewStorage(mongoURL string, mongoName string, scheduler workers.Scheduler) *storage {
@@ -28,33 +61,96 @@ func NewStorage(mongoURL string, mongoName string, scheduler workers.Scheduler)
 		panic(err)
 	}
 
-	postsCollection := client.Database(mongoName).Collection(collName)
-
-	ensureIndexes(ctx, postsCollection)
-
-	return &storage{
-		postsCollection: postsCollection,
-		scheduler:       scheduler,
+	s := &storage{
+		postsCollection:     client.Database(mongoName).Collection(collName),
+		revisionsCollection: client.Database(mongoName).Collection(revisionsCollName),
+		repostsCollection:   client.Database(mongoName).Collection(repostsCollName),
+		scheduler:           scheduler,
 	}
+	s.ensureIndexes(ctx)
+
+	return s
 }
 
-func ensureIndexes(ctx context.Context, collection *mongo.Collection) {
+func (s *storage) ensureIndexes(ctx context.Context) {
 	indexModels := mongo.IndexModel{
 		Keys: bson.D{{"authorId", 1}, {"_id", -1}},
 	}
-	_, err := collection.Indexes().CreateOne(ctx, indexModels)
+	_, err := s.postsCollection.Indexes().CreateOne(ctx, indexModels)
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+
+	_, err = s.postsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"hashtags", 1}, {"_id", -1}},
+	})
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+
+	// the only text index a collection may have
+	_, err = s.postsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"text", "text"}},
+	})
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+
+	_, err = s.postsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"parentId", 1}, {"_id", -1}},
+	})
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+
+	// multikey index, a post is found once for every mentioned user
+	_, err = s.postsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"mentions.userId", 1}, {"_id", -1}},
+	})
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+
+	_, err = s.revisionsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"postId", 1}, {"_id", -1}},
+	})
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+
+	_, err = s.repostsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"postId", 1}, {"userId", 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		panic(fmt.Errorf("failed to ensure indexes %w", err))
+	}
+
+	_, err = s.repostsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"userId", 1}, {"createdAt", -1}, {"postId", -1}},
+	})
 	if err != nil {
 		panic(fmt.Errorf("failed to ensure indexes %w", err))
 	}
 }
 
-func (s *storage) PutPost(ctx context.Context, userId schemas.UserId, text schemas.Text) (*schemas.Post, error) {
+func (s *storage) PutPost(ctx context.Context, userId schemas.UserId, text schemas.Text, opts plain.PostOptions) (*schemas.Post, error) {
+	postId := schemas.NewPostId()
+	if opts.ID != nil {
+		postId = *opts.ID
+	}
+	now := s.Now()
 	newPost := &schemas.Post{
-		ID:             schemas.PostId(primitive.NewObjectID()),
+		ID:             postId,
 		AuthorID:       userId,
 		Content:        text,
-		CreatedAt:      s.Now(),
-		LastModifiedAt: s.Now(),
+		CreatedAt:      now,
+		LastModifiedAt: now,
+		ParentID:       opts.ParentID,
+		QuotedID:       opts.QuotedID,
+		Mentions:       schemas.ExtractMentions(text),
+		Hashtags:       schemas.ExtractHashtags(text),
+		Attachments:    opts.Attachments,
 	}
 
 	_, err := s.postsCollection.InsertOne(ctx, newPost)
@@ -73,7 +169,7 @@ func (s *storage) GetPost(ctx context.Context, postId schemas.PostId) (*schemas.
 	err := s.postsCollection.FindOne(ctx, bson.M{"_id": postId}).Decode(&post)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			return nil, fmt.Errorf("not found post %s, cause %s", postId, err.Error())
+			return nil, fmt.Errorf("%w: post %s, cause %s", netstorage.ErrNotFound, postId, err.Error())
 		}
 		return nil, fmt.Errorf("failed to extract, cause %s", err.Error())
 	}
@@ -81,78 +177,190 @@ func (s *storage) GetPost(ctx context.Context, postId schemas.PostId) (*schemas.
 }
 
 func (s *storage) GetUserPosts(ctx context.Context, authorID schemas.UserId, pageData plain.GetUserPostsPageData) ([]*schemas.Post, *plain.GetUserPostsPageData, error) {
-	lastSeenID, size, err := plain.CorrectDestruct(pageData)
+	return s.findPostsPage(ctx, bson.M{"authorId": string(authorID)}, pageData)
+}
+
+func (s *storage) GetPostReplies(ctx context.Context, postId schemas.PostId, pageData plain.GetUserPostsPageData) ([]*schemas.Post, *plain.GetUserPostsPageData, error) {
+	return s.findPostsPage(ctx, bson.M{"parentId": postId}, pageData)
+}
+
+func (s *storage) GetHashtagPosts(ctx context.Context, hashtag schemas.Hashtag, pageData plain.GetUserPostsPageData) ([]*schemas.Post, *plain.GetUserPostsPageData, error) {
+	return s.findPostsPage(ctx, bson.M{"hashtags": string(hashtag)}, pageData)
+}
+
+func (s *storage) SearchPosts(ctx context.Context, query plain.SearchQuery, pageData plain.SearchPageData) ([]*schemas.Post, *plain.SearchPageData, error) {
+	cursor, size, err := plain.CorrectDestructSearch(pageData)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	mongoFilter := bson.M{"authorId": string(authorID)}
-	if lastSeenID != nil {
-		mongoFilter["_id"] = bson.M{"$lte": *lastSeenID}
+	textFilter := bson.M{"$text": bson.M{"$search": query.Text}}
+	if query.AuthorID != "" {
+		textFilter["authorId"] = string(query.AuthorID)
 	}
-	optionsLimit := int64(size + 2) // with redundant previous and next
-	filterOptions := &options.FindOptions{
-		Limit: &optionsLimit,
-		Sort:  bson.M{"_id": -1},
+	pipeline := mongo.Pipeline{
+		{{"$match", textFilter}},
+		{{"$addFields", bson.M{"score": bson.M{"$meta": "textScore"}}}},
+	}
+	if cursor != nil {
+		pipeline = append(pipeline, bson.D{{"$match", bson.M{"$or": bson.A{
+			bson.M{"score": bson.M{"$lt": cursor.Score}},
+			bson.M{"score": cursor.Score, "_id": bson.M{"$lt": cursor.ID}},
+		}}}})
 	}
-	cursor, err := s.postsCollection.Find(ctx, mongoFilter, filterOptions)
+	pipeline = append(pipeline,
+		bson.D{{"$sort", bson.D{{"score", -1}, {"_id", -1}}}},
+		bson.D{{"$limit", size + 1}},
+	)
+
+	mongoCursor, err := s.postsCollection.Aggregate(ctx, pipeline)
 	if err != nil {
 		return nil, nil, fmt.Errorf("search failed: %s", err.Error())
 	}
-	var postList []*schemas.Post
-	if err = cursor.All(ctx, &postList); err != nil {
+	var results []*searchResult
+	if err = mongoCursor.All(ctx, &results); err != nil {
 		return nil, nil, fmt.Errorf("posts mapping failed: %s", err.Error())
 	}
 
-	if lastSeenID != nil && len(postList) == 0 {
-		return nil, nil, fmt.Errorf("something went wrong(no posts but lastseen not null)")
+	var nextPage *plain.SearchPageData
+	if len(results) > size {
+		last := results[size-1]
+		nextPage = &plain.SearchPageData{
+			Cursor: plain.SearchCursor{Score: last.Score, ID: last.ID}.Encode(),
+			Size:   size,
+		}
+		results = results[:size]
+	}
+
+	posts := make([]*schemas.Post, len(results))
+	for i := range results {
+		posts[i] = &results[i].Post
 	}
+	return posts, nextPage, nil
+}
 
-	if lastSeenID != nil && postList[0].ID != *lastSeenID {
-		return nil, nil, fmt.Errorf("something went wrong(lastseen missmatch): %s", *lastSeenID)
+func (s *storage) GetUserMentions(ctx context.Context, userId schemas.UserId, pageData plain.GetUserPostsPageData) ([]*schemas.Post, *plain.GetUserPostsPageData, error) {
+	return s.findPostsPage(ctx, bson.M{"mentions.userId": string(userId)}, pageData)
+}
+
+// findPostsPage returns posts matching mongoFilter newest first, starting right after pageData.Token
+func (s *storage) findPostsPage(ctx context.Context, mongoFilter bson.M, pageData plain.GetUserPostsPageData) ([]*schemas.Post, *plain.GetUserPostsPageData, error) {
+	cursor, size, err := plain.CorrectDestruct(pageData)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cursor != nil && cursor.Newer {
+		return s.findNewerPostsPage(ctx, mongoFilter, cursor, size)
 	}
 
-	if lastSeenID != nil {
-		postList = postList[1:]
+	if cursor != nil {
+		mongoFilter["_id"] = bson.M{"$lt": cursor.ID}
+	}
+	optionsLimit := int64(size + 1) // with redundant next
+	filterOptions := &options.FindOptions{
+		Limit: &optionsLimit,
+		Sort:  bson.M{"_id": -1},
+	}
+	mongoCursor, err := s.postsCollection.Find(ctx, mongoFilter, filterOptions)
+	if err != nil {
+		return nil, nil, fmt.Errorf("search failed: %s", err.Error())
+	}
+	var postList []*schemas.Post
+	if err = mongoCursor.All(ctx, &postList); err != nil {
+		return nil, nil, fmt.Errorf("posts mapping failed: %s", err.Error())
 	}
 
 	var nextPage *plain.GetUserPostsPageData
 	if len(postList) > size {
 		//page is overfilled, there is next element [...]+
-		nextPage = &plain.GetUserPostsPageData{
-			LastSeenID: postList[size-1].ID.ToBase64URL(),
-			Size:       size,
-		}
+		nextPage = plain.NextPage(0, postList[size-1].ID, size)
 		postList = postList[:size]
 	}
 
 	return postList, nextPage, nil
 }
 
+// findNewerPostsPage returns size posts matching mongoFilter going right before the cursor, newest first
+func (s *storage) findNewerPostsPage(ctx context.Context, mongoFilter bson.M, cursor *plain.Cursor, size int) ([]*schemas.Post, *plain.GetUserPostsPageData, error) {
+	mongoFilter["_id"] = bson.M{"$gt": cursor.ID}
+	optionsLimit := int64(size)
+	filterOptions := &options.FindOptions{
+		Limit: &optionsLimit,
+		Sort:  bson.M{"_id": 1},
+	}
+	mongoCursor, err := s.postsCollection.Find(ctx, mongoFilter, filterOptions)
+	if err != nil {
+		return nil, nil, fmt.Errorf("search failed: %s", err.Error())
+	}
+	var postList []*schemas.Post
+	if err = mongoCursor.All(ctx, &postList); err != nil {
+		return nil, nil, fmt.Errorf("posts mapping failed: %s", err.Error())
+	}
+
+	if len(postList) == 0 {
+		return postList, plain.HeadPage(0, cursor.ID, size, 0), nil
+	}
+
+	// counted after the page is read, so posts put meanwhile are pending too
+	newerCount, err := s.postsCollection.CountDocuments(ctx, mongoFilter)
+	if err != nil {
+		return nil, nil, fmt.Errorf("count failed: %s", err.Error())
+	}
+	pending := int(newerCount) - len(postList)
+	if pending < 0 {
+		pending = 0
+	}
+
+	for i, j := 0, len(postList)-1; i < j; i, j = i+1, j-1 {
+		postList[i], postList[j] = postList[j], postList[i]
+	}
+	return postList, plain.HeadPage(0, postList[0].ID, size, pending), nil
+}
+
 func (s *storage) EditPost(ctx context.Context, postId schemas.PostId, authorId schemas.UserId, text schemas.Text) (*schemas.Post, error) {
-	mongoSelector := bson.D{{"_id", postId}}
+	now := s.Now()
+	mentions := schemas.ExtractMentions(text)
+	hashtags := schemas.ExtractHashtags(text)
+	mongoSelector := bson.D{{"_id", postId}, {"authorId", string(authorId)}}
 	mongoCommand := bson.D{
 		{
 			"$set", bson.D{
 				{"text", text},
-				{"lastModifiedAt", s.Now()},
+				{"mentions", mentions},
+				{"hashtags", hashtags},
+				{"lastModifiedAt", now},
 			},
 		},
 		{
 			"$inc", bson.D{{"version", 1}},
 		},
 	}
-	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	// previous state is returned to be kept as a revision
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
 	result := s.postsCollection.FindOneAndUpdate(ctx, mongoSelector, mongoCommand, opts)
 
-	var editedPost schemas.Post
-	err := result.Decode(&editedPost)
+	var previousPost schemas.Post
+	err := result.Decode(&previousPost)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			return nil, fmt.Errorf("not found:%s", postId)
+			return nil, fmt.Errorf("%w: %s", netstorage.ErrNotFound, postId)
 		}
 		return nil, fmt.Errorf("mongo error:%s", err.Error())
 	}
+
+	revision := previousPost.NewRevision(schemas.PostId(primitive.NewObjectID()))
+	_, err = s.revisionsCollection.InsertOne(ctx, revision)
+	if err != nil {
+		return nil, fmt.Errorf("revision insertion failed: %s", err.Error())
+	}
+
+	editedPost := previousPost
+	editedPost.Content = text
+	editedPost.Mentions = mentions
+	editedPost.Hashtags = hashtags
+	editedPost.LastModifiedAt = now
+	editedPost.Version++
+
 	err = s.scheduler.PublishSpreadPostOverSubs(editedPost.AuthorID, editedPost.ID)
 	if err != nil {
 		return nil, err
@@ -160,24 +368,194 @@ func (s *storage) EditPost(ctx context.Context, postId schemas.PostId, authorId
 	return &editedPost, nil
 }
 
+func (s *storage) DeletePost(ctx context.Context, postId schemas.PostId, authorId schemas.UserId) error {
+	mongoSelector := bson.M{"_id": postId, "authorId": string(authorId)}
+	result, err := s.postsCollection.DeleteOne(ctx, mongoSelector)
+	if err != nil {
+		return fmt.Errorf("mongo error:%s", err.Error())
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("%w: %s", netstorage.ErrNotFound, postId)
+	}
+	_, err = s.revisionsCollection.DeleteMany(ctx, bson.M{"postId": postId})
+	if err != nil {
+		return fmt.Errorf("mongo error:%s", err.Error())
+	}
+	_, err = s.repostsCollection.DeleteMany(ctx, bson.M{"postId": postId})
+	if err != nil {
+		return fmt.Errorf("mongo error:%s", err.Error())
+	}
+	return s.scheduler.PublishRetractPostFromSubs(authorId, postId)
+}
+
+// PutRepost remembers the repost and spreads it, reposting a post twice is a collision
+func (s *storage) PutRepost(ctx context.Context, userId schemas.UserId, postId schemas.PostId) error {
+	repost := &repostInfo{
+		UserID:    userId,
+		PostID:    postId,
+		CreatedAt: s.Now(),
+	}
+	_, err := s.repostsCollection.InsertOne(ctx, repost)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("%w: %s already reposted %s", netstorage.ErrCollision, userId, postId)
+		}
+		return fmt.Errorf("repost insertion failed: %s", err.Error())
+	}
+	return s.scheduler.PublishSpreadRepostOverSubs(userId, postId)
+}
+
+func (s *storage) GetUserReposts(ctx context.Context, userId schemas.UserId, pageData plain.GetUserPostsPageData) ([]*schemas.FeedEntry, *plain.GetUserPostsPageData, error) {
+	cursor, size, err := plain.CorrectDestruct(pageData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filter := bson.M{"userId": string(userId)}
+	order := -1
+	limit := size + 1 // with redundant next
+	if cursor != nil {
+		cursorTime := plain.TimeFromSortKey(cursor.SortKey)
+		operator := "$lt"
+		if cursor.Newer {
+			operator, order, limit = "$gt", 1, size
+		}
+		filter["$or"] = bson.A{
+			bson.M{"createdAt": bson.M{operator: cursorTime}},
+			bson.M{"createdAt": cursorTime, "postId": bson.M{operator: cursor.ID}},
+		}
+	}
+	pipeline := mongo.Pipeline{
+		{{"$match", filter}},
+		{{"$sort", bson.D{{"createdAt", order}, {"postId", order}}}},
+		{{"$limit", limit}},
+		{{"$lookup", bson.M{
+			"from":         collName,
+			"localField":   "postId",
+			"foreignField": "_id",
+			"as":           "post",
+		}}},
+		{{"$unwind", "$post"}},
+		{{"$addFields", bson.M{"authorId": "$post.authorId"}}},
+	}
+
+	mongoCursor, err := s.repostsCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, nil, fmt.Errorf("mongo search failed: %s", err.Error())
+	}
+	var reposts []*repostEntry
+	if err = mongoCursor.All(ctx, &reposts); err != nil {
+		return nil, nil, fmt.Errorf("reposts mapping failed: %s", err.Error())
+	}
+
+	entries := make([]*schemas.FeedEntry, 0, len(reposts))
+	if cursor != nil && cursor.Newer {
+		// the oldest newer reposts are found, they are returned newest first
+		if len(reposts) == 0 {
+			return entries, plain.HeadPage(cursor.SortKey, cursor.ID, size, 0), nil
+		}
+		newerCount, err := s.repostsCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			return nil, nil, fmt.Errorf("mongo count failed: %s", err.Error())
+		}
+		pending := int(newerCount) - len(reposts)
+		if pending < 0 {
+			pending = 0
+		}
+		for i := len(reposts) - 1; i >= 0; i-- {
+			entries = append(entries, reposts[i].toFeedEntry())
+		}
+		head := entries[0]
+		return entries, plain.HeadPage(plain.TimeSortKey(head.AddedAt), head.PostID, size, pending), nil
+	}
+
+	var nextPage *plain.GetUserPostsPageData
+	if len(reposts) > size {
+		last := reposts[size-1]
+		nextPage = plain.NextPage(plain.TimeSortKey(last.CreatedAt), last.PostID, size)
+		reposts = reposts[:size]
+	}
+	for i := range reposts {
+		entries = append(entries, reposts[i].toFeedEntry())
+	}
+	return entries, nextPage, nil
+}
+
+func (s *storage) GetPostRevisions(ctx context.Context, postId schemas.PostId, pageData plain.GetUserPostsPageData) ([]*schemas.PostRevision, *plain.GetUserPostsPageData, error) {
+	cursor, size, err := plain.CorrectDestruct(pageData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cursor != nil && cursor.Newer {
+		return nil, nil, fmt.Errorf("%w: newer revisions are not listed", plain.ErrInvalidToken)
+	}
+
+	mongoFilter := bson.M{"postId": postId}
+	if cursor != nil {
+		mongoFilter["_id"] = bson.M{"$lt": cursor.ID}
+	}
+	optionsLimit := int64(size + 1) // with redundant next
+	filterOptions := &options.FindOptions{
+		Limit: &optionsLimit,
+		Sort:  bson.M{"_id": -1},
+	}
+	mongoCursor, err := s.revisionsCollection.Find(ctx, mongoFilter, filterOptions)
+	if err != nil {
+		return nil, nil, fmt.Errorf("search failed: %s", err.Error())
+	}
+	var revisionList []*schemas.PostRevision
+	if err = mongoCursor.All(ctx, &revisionList); err != nil {
+		return nil, nil, fmt.Errorf("revisions mapping failed: %s", err.Error())
+	}
+
+	var nextPage *plain.GetUserPostsPageData
+	if len(revisionList) > size {
+		nextPage = plain.NextPage(0, revisionList[size-1].ID, size)
+		revisionList = revisionList[:size]
+	}
+
+	return revisionList, nextPage, nil
+}
+
 type MongoPostsIterator struct {
 	cursor *mongo.Cursor
+	err    error
 }
 
 func (mpi *MongoPostsIterator) GetNextPost(ctx context.Context) *schemas.Post {
+	if mpi.err != nil {
+		return nil
+	}
 	hasNext := mpi.cursor.Next(ctx)
 	if !hasNext {
+		if mpi.cursor.Err() != nil {
+			mpi.err = fmt.Errorf("mongo iteration failed: %s", mpi.cursor.Err().Error())
+		}
 		return nil
 	}
 
 	var post schemas.Post
 	err := mpi.cursor.Decode(&post)
 	if err != nil {
+		mpi.err = fmt.Errorf("posts mapping failed: %s", err.Error())
 		return nil
 	}
 	return &post
 }
 
+func (mpi *MongoPostsIterator) Err() error {
+	return mpi.err
+}
+
+func (mpi *MongoPostsIterator) Close(ctx context.Context) error {
+	err := mpi.cursor.Close(ctx)
+	if err != nil {
+		return fmt.Errorf("mongo cursor closing failed: %s", err.Error())
+	}
+	return nil
+}
+
 func (s *storage) GetAllPostsFromUser(ctx context.Context, authorId schemas.UserId) (plain.PostsIterator, error) {
 	mongoFilter := bson.M{"authorId": string(authorId)}
 
@@ -189,6 +567,18 @@ func (s *storage) GetAllPostsFromUser(ctx context.Context, authorId schemas.User
 	return &MongoPostsIterator{cursor: cursor}, nil
 }
 
+func (s *storage) GetAllRepliesToPost(ctx context.Context, postId schemas.PostId) (plain.PostsIterator, error) {
+	mongoFilter := bson.M{"parentId": postId}
+	findOptions := options.Find().SetSort(bson.M{"_id": 1})
+
+	cursor, err := s.postsCollection.Find(ctx, mongoFilter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MongoPostsIterator{cursor: cursor}, nil
+}
+
 func (s *storage) Now() time.Time {
 	return time.Now().UTC().Truncate(time.Millisecond)
 }
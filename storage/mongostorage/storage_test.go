@@ -0,0 +1,35 @@
+package mongostorage
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	netstorage "netwitter/storage"
+	"netwitter/storage/storagetest"
+	"os"
+	"testing"
+)
+
+func TestMongoStorage(t *testing.T) {
+	mongoURL := os.Getenv("MONGO_TEST_URL")
+	if mongoURL == "" {
+		t.Skip("MONGO_TEST_URL is not set")
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(ctx)
+
+	// every test gets a database of its own
+	storagetest.RunStorageSuite(t, func(t *testing.T) netstorage.Storage {
+		mongoName := "storagetest_" + primitive.NewObjectID().Hex()
+		t.Cleanup(func() {
+			_ = client.Database(mongoName).Drop(ctx)
+		})
+		return NewStorage(mongoURL, mongoName, storagetest.NopScheduler{})
+	})
+}
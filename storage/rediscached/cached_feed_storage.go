@@ -0,0 +1,290 @@
+package rediscached
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"netwitter/plain"
+	"netwitter/schemas"
+	"netwitter/storage"
+	"sort"
+	"time"
+)
+
+const (
+	feedKeyPrefix      = "ntwt:feed:"
+	feedItemsKeyPrefix = "ntwt:feeditems:"
+	feedMetaKeyPrefix  = "ntwt:feedmeta:"
+	feedPostKeyPrefix  = "ntwt:feedpost:"
+	// items field marking a loaded head, post ids never take it
+	feedLoadedField = "loaded"
+	// the post may be put to more heads while it is removed from the known ones
+	removePostAttempts = 3
+)
+
+//go:embed feed_fill.lua
+var feedFillSource string
+var feedFillScript = redis.NewScript(feedFillSource)
+
+//go:embed feed_put.lua
+var feedPutSource string
+var feedPutScript = redis.NewScript(feedPutSource)
+
+//go:embed feed_remove_post.lua
+var feedRemovePostSource string
+var feedRemovePostScript = redis.NewScript(feedRemovePostSource)
+
+// CachedFeedStorage keeps up to headSize newest entries of every read feed in redis:
+// a sorted set of post ids scored by feed position, a hash of entries and a meta hash.
+// The head is loaded while its items hold feedLoadedField and expires ttl after it is
+// filled, a set of users of every cached post outlives all heads the post is put to.
+// Meta "vers" is bumped by every write, a head read from the persistent storage is
+// stored only if no write happened meanwhile, like redisgeneral.SetWithFreshness
+// keeps only the freshest values. Meta "truncated" tells the persistent feed goes
+// on past the head, pages crossing its end are read from the persistent storage.
+type CachedFeedStorage struct {
+	persistentStorage storage.FeedStorage
+	client            *redis.Client
+	headSize          int
+	ttl               time.Duration
+}
+
+func NewCachedFeedStorage(persistentStorage storage.FeedStorage, client *redis.Client, headSize int, ttl time.Duration) *CachedFeedStorage {
+	return &CachedFeedStorage{
+		persistentStorage: persistentStorage,
+		client:            client,
+		headSize:          headSize,
+		ttl:               ttl,
+	}
+}
+
+// cachedFeedHead is a head of a feed, entries go newest first
+type cachedFeedHead struct {
+	entries   []*schemas.FeedEntry
+	truncated bool
+}
+
+func (cs *CachedFeedStorage) PutPostToFeed(ctx context.Context, userId schemas.UserId, post schemas.Post) error {
+	err := cs.persistentStorage.PutPostToFeed(ctx, userId, post)
+	if err != nil {
+		return err
+	}
+
+	entry := &schemas.FeedEntry{
+		PostID:   post.ID,
+		AuthorID: post.AuthorID,
+		AddedAt:  post.CreatedAt,
+	}
+	rawEntry, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("marshalling failed: %s", err.Error())
+	}
+	keys := []string{feedKeyPrefix + string(userId), feedItemsKeyPrefix + string(userId), feedMetaKeyPrefix + string(userId), feedPostKeyPrefix + post.ID.Hex()}
+	argv := []interface{}{post.ID.Hex(), plain.TimeSortKey(post.CreatedAt), rawEntry, cs.headSize, cs.ttl.Milliseconds(), string(userId)}
+	err = feedPutScript.Run(ctx, cs.client, keys, argv...).Err()
+	if err != nil {
+		return fmt.Errorf("redis error: %s", err.Error())
+	}
+	return nil
+}
+
+// PutRepostToFeed drops the head, the repost position is chosen by the persistent storage
+func (cs *CachedFeedStorage) PutRepostToFeed(ctx context.Context, userId schemas.UserId, post schemas.Post, reposter schemas.UserId) error {
+	err := cs.persistentStorage.PutRepostToFeed(ctx, userId, post, reposter)
+	if err != nil {
+		return err
+	}
+	return cs.invalidate(ctx, userId)
+}
+
+func (cs *CachedFeedStorage) RemovePostFromFeeds(ctx context.Context, postId schemas.PostId) error {
+	err := cs.persistentStorage.RemovePostFromFeeds(ctx, postId)
+	if err != nil {
+		return err
+	}
+
+	// scripts touch only keys they are given, so users of the post are read first
+	postUsersKey := feedPostKeyPrefix + postId.Hex()
+	for attempt := 0; attempt < removePostAttempts; attempt++ {
+		users, err := cs.client.SMembers(ctx, postUsersKey).Result()
+		if err != nil {
+			return fmt.Errorf("redis error: %s", err.Error())
+		}
+		if len(users) == 0 {
+			return nil
+		}
+
+		keys := []string{postUsersKey}
+		argv := []interface{}{postId.Hex(), cs.ttl.Milliseconds()}
+		for _, user := range users {
+			keys = append(keys, feedKeyPrefix+user, feedItemsKeyPrefix+user, feedMetaKeyPrefix+user)
+			argv = append(argv, user)
+		}
+		remaining, err := feedRemovePostScript.Run(ctx, cs.client, keys, argv...).Int()
+		if err != nil {
+			return fmt.Errorf("redis error: %s", err.Error())
+		}
+		if remaining == 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("post %s is still being put to cached feeds", postId.Hex())
+}
+
+func (cs *CachedFeedStorage) RemoveAuthorFromFeed(ctx context.Context, userId schemas.UserId, authorId schemas.UserId) ([]*schemas.FeedEntry, error) {
+	reposts, err := cs.persistentStorage.RemoveAuthorFromFeed(ctx, userId, authorId)
+	if err != nil {
+		return nil, err
+	}
+	return reposts, cs.invalidate(ctx, userId)
+}
+
+func (cs *CachedFeedStorage) invalidate(ctx context.Context, userId schemas.UserId) error {
+	metaKey := feedMetaKeyPrefix + string(userId)
+	_, err := cs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(ctx, metaKey, "vers", 1)
+		pipe.PExpire(ctx, metaKey, cs.ttl)
+		pipe.Del(ctx, feedKeyPrefix+string(userId), feedItemsKeyPrefix+string(userId))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("redis error: %s", err.Error())
+	}
+	return nil
+}
+
+func (cs *CachedFeedStorage) GetUserFeed(ctx context.Context, userId schemas.UserId, data plain.GetUserPostsPageData) ([]*schemas.FeedEntry, *plain.GetUserPostsPageData, error) {
+	cursor, size, err := plain.CorrectDestruct(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if size > cs.headSize {
+		return cs.persistentStorage.GetUserFeed(ctx, userId, data)
+	}
+
+	head, err := cs.getHead(ctx, userId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cursor != nil && cursor.Newer {
+		end := sort.Search(len(head.entries), func(i int) bool {
+			return !cursor.Before(plain.TimeSortKey(head.entries[i].AddedAt), head.entries[i].PostID)
+		})
+		if end == len(head.entries) && head.truncated {
+			// the cursor is past the head end, so are some of newer entries
+			return cs.persistentStorage.GetUserFeed(ctx, userId, data)
+		}
+		start := 0
+		if end > size {
+			start = end - size
+		}
+		newer := head.entries[start:end]
+		if len(newer) == 0 {
+			return newer, plain.HeadPage(cursor.SortKey, cursor.ID, size, 0), nil
+		}
+		return newer, plain.HeadPage(plain.TimeSortKey(newer[0].AddedAt), newer[0].PostID, size, start), nil
+	}
+
+	start := 0
+	if cursor != nil {
+		start = sort.Search(len(head.entries), func(i int) bool {
+			return cursor.After(plain.TimeSortKey(head.entries[i].AddedAt), head.entries[i].PostID)
+		})
+	}
+
+	rest := head.entries[start:]
+	if len(rest) <= size && head.truncated {
+		// the head cannot tell what goes after its end
+		return cs.persistentStorage.GetUserFeed(ctx, userId, data)
+	}
+
+	var nextPageToken *plain.GetUserPostsPageData
+	if len(rest) > size {
+		rest = rest[:size]
+		last := rest[size-1]
+		nextPageToken = plain.NextPage(plain.TimeSortKey(last.AddedAt), last.PostID, size)
+	}
+	return rest, nextPageToken, nil
+}
+
+func (cs *CachedFeedStorage) getHead(ctx context.Context, userId schemas.UserId) (*cachedFeedHead, error) {
+	// meta and items are read at once, so the head is never seen half invalidated
+	var metaCmd *redis.SliceCmd
+	var itemsCmd *redis.StringStringMapCmd
+	_, err := cs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		metaCmd = pipe.HMGet(ctx, feedMetaKeyPrefix+string(userId), "vers", "truncated")
+		itemsCmd = pipe.HGetAll(ctx, feedItemsKeyPrefix+string(userId))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %s", err.Error())
+	}
+	meta := metaCmd.Val()
+	rawItems := itemsCmd.Val()
+	if _, loaded := rawItems[feedLoadedField]; loaded {
+		delete(rawItems, feedLoadedField)
+		return decodeHead(rawItems, meta[1] == "1")
+	}
+
+	readVersion := "0"
+	if meta[0] != nil {
+		readVersion = meta[0].(string)
+	}
+	entries, nextPage, err := cs.persistentStorage.GetUserFeed(ctx, userId, plain.GetUserPostsPageData{Size: cs.headSize})
+	if err != nil {
+		return nil, err
+	}
+	head := &cachedFeedHead{entries: entries, truncated: nextPage != nil}
+
+	err = cs.storeHead(ctx, userId, readVersion, head)
+	if err != nil {
+		return nil, err
+	}
+	return head, nil
+}
+
+func decodeHead(rawItems map[string]string, truncated bool) (*cachedFeedHead, error) {
+	entries := make([]*schemas.FeedEntry, 0, len(rawItems))
+	for _, rawItem := range rawItems {
+		var entry schemas.FeedEntry
+		err := json.Unmarshal([]byte(rawItem), &entry)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect json:%s", err.Error())
+		}
+		entries = append(entries, &entry)
+	}
+	// the same order as sorted set members have
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].AddedAt.Equal(entries[j].AddedAt) {
+			return entries[i].AddedAt.After(entries[j].AddedAt)
+		}
+		return entries[i].PostID.Hex() > entries[j].PostID.Hex()
+	})
+	return &cachedFeedHead{entries: entries, truncated: truncated}, nil
+}
+
+func (cs *CachedFeedStorage) storeHead(ctx context.Context, userId schemas.UserId, readVersion string, head *cachedFeedHead) error {
+	truncated := 0
+	if head.truncated {
+		truncated = 1
+	}
+	keys := []string{feedKeyPrefix + string(userId), feedItemsKeyPrefix + string(userId), feedMetaKeyPrefix + string(userId)}
+	argv := []interface{}{readVersion, truncated, cs.ttl.Milliseconds(), string(userId)}
+	for _, entry := range head.entries {
+		rawEntry, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("marshalling failed: %s", err.Error())
+		}
+		keys = append(keys, feedPostKeyPrefix+entry.PostID.Hex())
+		argv = append(argv, entry.PostID.Hex(), plain.TimeSortKey(entry.AddedAt), rawEntry)
+	}
+
+	err := feedFillScript.Run(ctx, cs.client, keys, argv...).Err()
+	if err != nil {
+		return fmt.Errorf("redis error: %s", err.Error())
+	}
+	return nil
+}
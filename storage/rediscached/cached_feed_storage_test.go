@@ -0,0 +1,223 @@
+package rediscached
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"netwitter/feed"
+	"netwitter/plain"
+	"netwitter/schemas"
+	"os"
+	"testing"
+	"time"
+)
+
+const testHeadSize = 3
+
+func newTestCachedFeed(t *testing.T) *CachedFeedStorage {
+	redisURL := os.Getenv("REDIS_TEST_URL")
+	if redisURL == "" {
+		t.Skip("REDIS_TEST_URL is not set")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: redisURL})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+	return NewCachedFeedStorage(feed.NewInMemoryStorage(), client, testHeadSize, time.Minute)
+}
+
+// newFeedUser keeps keys of every test apart in a shared redis
+func newFeedUser() schemas.UserId {
+	return schemas.UserId("feeduser_" + primitive.NewObjectID().Hex())
+}
+
+func newFeedPost(author schemas.UserId, at time.Time) schemas.Post {
+	return schemas.Post{ID: schemas.NewPostId(), AuthorID: author, CreatedAt: at.UTC().Truncate(time.Millisecond)}
+}
+
+func readFeed(t *testing.T, s *CachedFeedStorage, userId schemas.UserId) []*schemas.FeedEntry {
+	t.Helper()
+	entries, _, err := s.GetUserFeed(context.Background(), userId, plain.GetUserPostsPageData{Size: testHeadSize})
+	if err != nil {
+		t.Fatalf("get feed: %v", err)
+	}
+	return entries
+}
+
+func assertFeedPosts(t *testing.T, entries []*schemas.FeedEntry, expected ...schemas.Post) {
+	t.Helper()
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %d", len(expected), len(entries))
+	}
+	for i := range expected {
+		if entries[i].PostID != expected[i].ID {
+			t.Errorf("entry %d: expected post %s, got %s", i, expected[i].ID.Hex(), entries[i].PostID.Hex())
+		}
+	}
+}
+
+func isHeadLoaded(t *testing.T, s *CachedFeedStorage, userId schemas.UserId) bool {
+	t.Helper()
+	loaded, err := s.client.HExists(context.Background(), feedItemsKeyPrefix+string(userId), feedLoadedField).Result()
+	if err != nil {
+		t.Fatalf("redis error: %v", err)
+	}
+	return loaded
+}
+
+func TestCachedFeedPutUpdatesHead(t *testing.T) {
+	s := newTestCachedFeed(t)
+	ctx := context.Background()
+	reader := newFeedUser()
+	start := time.Now()
+
+	first := newFeedPost("author", start)
+	if err := s.PutPostToFeed(ctx, reader, first); err != nil {
+		t.Fatalf("put post: %v", err)
+	}
+	assertFeedPosts(t, readFeed(t, s, reader), first)
+	if !isHeadLoaded(t, s, reader) {
+		t.Fatal("read head must be cached")
+	}
+
+	second := newFeedPost("author", start.Add(time.Millisecond))
+	if err := s.PutPostToFeed(ctx, reader, second); err != nil {
+		t.Fatalf("put post: %v", err)
+	}
+	assertFeedPosts(t, readFeed(t, s, reader), second, first)
+}
+
+func TestCachedFeedStaleHeadIsNotStored(t *testing.T) {
+	s := newTestCachedFeed(t)
+	ctx := context.Background()
+	reader := newFeedUser()
+	start := time.Now()
+
+	first := newFeedPost("author", start)
+	if err := s.PutPostToFeed(ctx, reader, first); err != nil {
+		t.Fatalf("put post: %v", err)
+	}
+	// the head is read at version 1 and a write goes before it is stored
+	stale := &cachedFeedHead{entries: []*schemas.FeedEntry{{PostID: first.ID, AuthorID: first.AuthorID, AddedAt: first.CreatedAt}}}
+	second := newFeedPost("author", start.Add(time.Millisecond))
+	if err := s.PutPostToFeed(ctx, reader, second); err != nil {
+		t.Fatalf("put post: %v", err)
+	}
+	if err := s.storeHead(ctx, reader, "1", stale); err != nil {
+		t.Fatalf("store head: %v", err)
+	}
+
+	if isHeadLoaded(t, s, reader) {
+		t.Fatal("head read before the last write must not be stored")
+	}
+	assertFeedPosts(t, readFeed(t, s, reader), second, first)
+}
+
+func TestCachedFeedRemovePost(t *testing.T) {
+	s := newTestCachedFeed(t)
+	ctx := context.Background()
+	readers := []schemas.UserId{newFeedUser(), newFeedUser()}
+	kept := newFeedPost("author", time.Now())
+	removed := newFeedPost("author", time.Now().Add(time.Millisecond))
+
+	for _, reader := range readers {
+		for _, post := range []schemas.Post{kept, removed} {
+			if err := s.PutPostToFeed(ctx, reader, post); err != nil {
+				t.Fatalf("put post: %v", err)
+			}
+		}
+		assertFeedPosts(t, readFeed(t, s, reader), removed, kept)
+	}
+
+	if err := s.RemovePostFromFeeds(ctx, removed.ID); err != nil {
+		t.Fatalf("remove post: %v", err)
+	}
+	for _, reader := range readers {
+		if !isHeadLoaded(t, s, reader) {
+			t.Error("removal must keep the head")
+		}
+		assertFeedPosts(t, readFeed(t, s, reader), kept)
+	}
+	users, err := s.client.SMembers(ctx, feedPostKeyPrefix+removed.ID.Hex()).Result()
+	if err != nil {
+		t.Fatalf("redis error: %v", err)
+	}
+	if len(users) != 0 {
+		t.Errorf("removed post must not be tracked, got users %v", users)
+	}
+}
+
+func TestCachedFeedInvalidation(t *testing.T) {
+	s := newTestCachedFeed(t)
+	ctx := context.Background()
+	reader := newFeedUser()
+	start := time.Now()
+	own := newFeedPost("author", start)
+	other := newFeedPost("other", start.Add(time.Millisecond))
+	for _, post := range []schemas.Post{own, other} {
+		if err := s.PutPostToFeed(ctx, reader, post); err != nil {
+			t.Fatalf("put post: %v", err)
+		}
+	}
+	readFeed(t, s, reader)
+
+	if err := s.PutRepostToFeed(ctx, reader, own, "reposter"); err != nil {
+		t.Fatalf("put repost: %v", err)
+	}
+	if isHeadLoaded(t, s, reader) {
+		t.Fatal("repost must drop the head")
+	}
+	entries := readFeed(t, s, reader)
+	assertFeedPosts(t, entries, own, other)
+	if entries[0].RepostedBy != "reposter" {
+		t.Errorf("expected repost by reposter, got %q", entries[0].RepostedBy)
+	}
+
+	if _, err := s.RemoveAuthorFromFeed(ctx, reader, "other"); err != nil {
+		t.Fatalf("remove author: %v", err)
+	}
+	if isHeadLoaded(t, s, reader) {
+		t.Fatal("author removal must drop the head")
+	}
+	assertFeedPosts(t, readFeed(t, s, reader), own)
+}
+
+func TestCachedFeedPostUsersOutliveHead(t *testing.T) {
+	s := newTestCachedFeed(t)
+	ctx := context.Background()
+	reader := newFeedUser()
+	start := time.Now()
+
+	first := newFeedPost("author", start)
+	if err := s.PutPostToFeed(ctx, reader, first); err != nil {
+		t.Fatalf("put post: %v", err)
+	}
+	readFeed(t, s, reader)
+	// later puts must not keep the head past the users set of the first post
+	time.Sleep(20 * time.Millisecond)
+	second := newFeedPost("author", start.Add(time.Millisecond))
+	if err := s.PutPostToFeed(ctx, reader, second); err != nil {
+		t.Fatalf("put post: %v", err)
+	}
+	assertFeedPosts(t, readFeed(t, s, reader), second, first)
+
+	for _, key := range []string{feedKeyPrefix + string(reader), feedItemsKeyPrefix + string(reader)} {
+		headTTL, err := s.client.PTTL(ctx, key).Result()
+		if err != nil {
+			t.Fatalf("redis error: %v", err)
+		}
+		for _, post := range []schemas.Post{first, second} {
+			usersTTL, err := s.client.PTTL(ctx, feedPostKeyPrefix+post.ID.Hex()).Result()
+			if err != nil {
+				t.Fatalf("redis error: %v", err)
+			}
+			if usersTTL < headTTL {
+				t.Errorf("users of post %s expire in %v, before %s in %v", post.ID.Hex(), usersTTL, key, headTTL)
+			}
+		}
+	}
+}
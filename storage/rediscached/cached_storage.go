@@ -9,6 +9,7 @@ import (
 	"netwitter/storage"
 	"netwitter/storage/rediscached/redisgeneral"
 	"reflect"
+	"sort"
 	"time"
 )
 
@@ -41,8 +42,8 @@ func NewCachedStorage(persistentStorage storage.Storage, client *redis.Client, c
 	}
 }
 
-func (cs *CachedStorage) PutPost(ctx context.Context, userId schemas.UserId, text schemas.Text) (*schemas.Post, error) {
-	post, err := cs.persistentStorage.PutPost(ctx, userId, text)
+func (cs *CachedStorage) PutPost(ctx context.Context, userId schemas.UserId, text schemas.Text, opts plain.PostOptions) (*schemas.Post, error) {
+	post, err := cs.persistentStorage.PutPost(ctx, userId, text, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -97,42 +98,89 @@ func (cs *CachedStorage) EditPost(ctx context.Context, postId schemas.PostId, au
 	return editedPost, nil
 }
 
-func (cs *CachedStorage) GetUserPosts(ctx context.Context, authorID schemas.UserId, pageData plain.GetUserPostsPageData) (_ []*schemas.Post, nextPage *plain.GetUserPostsPageData, _ error) {
-	if pageData.LastSeenID != "" || pageData.Size < 0 || pageData.Size > plain.DefaultPageSize {
-		return cs.persistentStorage.GetUserPosts(ctx, authorID, pageData)
+func (cs *CachedStorage) DeletePost(ctx context.Context, postId schemas.PostId, authorId schemas.UserId) error {
+	err := cs.persistentStorage.DeletePost(ctx, postId, authorId)
+	if err != nil {
+		return err
 	}
-	if pageData.Size == 0 {
-		pageData.Size = plain.DefaultPageSize
+	err = cs.postCache.Delete(ctx, cs.getKeyForPost(postId))
+	if err != nil {
+		return err
 	}
+	return cs.firstPostsPackCache.Delete(ctx, cs.getKeyForFPP(authorId))
+}
 
-	// first page of user posts is cached
-	fppKey := cs.getKeyForFPP(authorID)
-	rawCached, found, err := cs.firstPostsPackCache.Get(ctx, fppKey)
+func (cs *CachedStorage) PutRepost(ctx context.Context, userId schemas.UserId, postId schemas.PostId) error {
+	return cs.persistentStorage.PutRepost(ctx, userId, postId)
+}
 
+func (cs *CachedStorage) GetUserReposts(ctx context.Context, userId schemas.UserId, pageData plain.GetUserPostsPageData) ([]*schemas.FeedEntry, *plain.GetUserPostsPageData, error) {
+	return cs.persistentStorage.GetUserReposts(ctx, userId, pageData)
+}
+
+func (cs *CachedStorage) GetUserPosts(ctx context.Context, authorID schemas.UserId, pageData plain.GetUserPostsPageData) (_ []*schemas.Post, nextPage *plain.GetUserPostsPageData, _ error) {
+	cursor, size, err := plain.CorrectDestruct(pageData)
 	if err != nil {
 		return nil, nil, err
 	}
-	if found {
-		// Hey bro, nice compiler
-		var cppRef CachedPostsPack
-		cppRef = rawCached.(CachedPostsPack)
-		return cs.constructPageDataFromCachedData(&cppRef, pageData)
+	if cursor != nil && !cursor.Newer || size > plain.DefaultPageSize {
+		return cs.persistentStorage.GetUserPosts(ctx, authorID, pageData)
 	}
 
-	firstPage, nextPage, err := cs.persistentStorage.GetUserPosts(ctx, authorID, plain.GetUserPostsPageData{LastSeenID: pageData.LastSeenID})
+	// first page of user posts is cached, newer posts are mostly found in it too
+	fppKey := cs.getKeyForFPP(authorID)
+	rawCached, found, err := cs.firstPostsPackCache.Get(ctx, fppKey)
 	if err != nil {
 		return nil, nil, err
 	}
+	if !found {
+		firstPage, nextPage, err := cs.persistentStorage.GetUserPosts(ctx, authorID, plain.GetUserPostsPageData{})
+		if err != nil {
+			return nil, nil, err
+		}
 
-	rawCached, err = cs.firstPostsPackCache.SetWithFreshness(ctx, fppKey, &CachedPostsPack{firstPage, nextPage})
-	if err != nil {
-		return nil, nil, err
+		rawCached, err = cs.firstPostsPackCache.SetWithFreshness(ctx, fppKey, &CachedPostsPack{firstPage, nextPage})
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
 	// Hey bro, nice compiler
 	var cppRef CachedPostsPack
 	cppRef = rawCached.(CachedPostsPack)
-	return cs.constructPageDataFromCachedData(&cppRef, pageData)
+	posts, nextPage, ok := cs.constructPageDataFromCachedData(&cppRef, cursor, size)
+	if !ok {
+		return cs.persistentStorage.GetUserPosts(ctx, authorID, pageData)
+	}
+	return posts, nextPage, nil
+}
+
+func (cs *CachedStorage) GetAllPostsFromUser(ctx context.Context, authorId schemas.UserId) (plain.PostsIterator, error) {
+	return cs.persistentStorage.GetAllPostsFromUser(ctx, authorId)
+}
+
+func (cs *CachedStorage) GetPostReplies(ctx context.Context, postId schemas.PostId, pageData plain.GetUserPostsPageData) ([]*schemas.Post, *plain.GetUserPostsPageData, error) {
+	return cs.persistentStorage.GetPostReplies(ctx, postId, pageData)
+}
+
+func (cs *CachedStorage) GetAllRepliesToPost(ctx context.Context, postId schemas.PostId) (plain.PostsIterator, error) {
+	return cs.persistentStorage.GetAllRepliesToPost(ctx, postId)
+}
+
+func (cs *CachedStorage) GetHashtagPosts(ctx context.Context, hashtag schemas.Hashtag, pageData plain.GetUserPostsPageData) ([]*schemas.Post, *plain.GetUserPostsPageData, error) {
+	return cs.persistentStorage.GetHashtagPosts(ctx, hashtag, pageData)
+}
+
+func (cs *CachedStorage) SearchPosts(ctx context.Context, query plain.SearchQuery, pageData plain.SearchPageData) ([]*schemas.Post, *plain.SearchPageData, error) {
+	return cs.persistentStorage.SearchPosts(ctx, query, pageData)
+}
+
+func (cs *CachedStorage) GetUserMentions(ctx context.Context, userId schemas.UserId, pageData plain.GetUserPostsPageData) ([]*schemas.Post, *plain.GetUserPostsPageData, error) {
+	return cs.persistentStorage.GetUserMentions(ctx, userId, pageData)
+}
+
+func (cs *CachedStorage) GetPostRevisions(ctx context.Context, postId schemas.PostId, pageData plain.GetUserPostsPageData) ([]*schemas.PostRevision, *plain.GetUserPostsPageData, error) {
+	return cs.persistentStorage.GetPostRevisions(ctx, postId, pageData)
 }
 
 func (cs *CachedStorage) getKeyForPost(postID schemas.PostId) string {
@@ -143,14 +191,34 @@ func (cs *CachedStorage) getKeyForFPP(userId schemas.UserId) string {
 	return fmt.Sprintf("ntwt:fppack:%s", userId)
 }
 
-func (cs *CachedStorage) constructPageDataFromCachedData(cachedPage *CachedPostsPack, page plain.GetUserPostsPageData) ([]*schemas.Post, *plain.GetUserPostsPageData, error) {
+// constructPageDataFromCachedData builds the first page or the page of posts newer than cursor,
+// it is not ok when newer posts go past the cached ones
+func (cs *CachedStorage) constructPageDataFromCachedData(cachedPage *CachedPostsPack, cursor *plain.Cursor, size int) ([]*schemas.Post, *plain.GetUserPostsPageData, bool) {
+	if cursor != nil {
+		end := sort.Search(len(cachedPage.Posts), func(i int) bool {
+			return cachedPage.Posts[i].ID.Hex() <= cursor.ID.Hex()
+		})
+		if end == len(cachedPage.Posts) && cachedPage.NextPageData != nil {
+			return nil, nil, false
+		}
+		start := 0
+		if end > size {
+			start = end - size
+		}
+		newer := cachedPage.Posts[start:end]
+		if len(newer) == 0 {
+			return newer, plain.HeadPage(0, cursor.ID, size, 0), true
+		}
+		return newer, plain.HeadPage(0, newer[0].ID, size, start), true
+	}
+
 	switch {
-	case len(cachedPage.Posts) == page.Size:
-		return cachedPage.Posts, cachedPage.NextPageData, nil
-	case len(cachedPage.Posts) < page.Size:
-		return cachedPage.Posts, nil, nil
-	case len(cachedPage.Posts) > page.Size:
-		return cachedPage.Posts[:page.Size], &plain.GetUserPostsPageData{LastSeenID: cachedPage.Posts[page.Size-1].ID.ToBase64URL(), Size: page.Size}, nil
+	case len(cachedPage.Posts) == size:
+		return cachedPage.Posts, cachedPage.NextPageData, true
+	case len(cachedPage.Posts) < size:
+		return cachedPage.Posts, nil, true
+	case len(cachedPage.Posts) > size:
+		return cachedPage.Posts[:size], plain.NextPage(0, cachedPage.Posts[size-1].ID, size), true
 	default:
 		panic("wtf")
 	}
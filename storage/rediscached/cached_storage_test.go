@@ -0,0 +1,30 @@
+package rediscached
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"netwitter/storage"
+	"netwitter/storage/inmemory"
+	"netwitter/storage/storagetest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCachedStorage(t *testing.T) {
+	redisURL := os.Getenv("REDIS_TEST_URL")
+	if redisURL == "" {
+		t.Skip("REDIS_TEST_URL is not set")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: redisURL})
+	defer client.Close()
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	storagetest.RunStorageSuite(t, func(t *testing.T) storage.Storage {
+		persistentStorage := inmemory.NewInMemoryStorage(storagetest.NopScheduler{})
+		return NewCachedStorage(persistentStorage, client, time.Minute)
+	})
+}
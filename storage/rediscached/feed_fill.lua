@@ -0,0 +1,34 @@
+local feedKey, itemsKey, metaKey = KEYS[1], KEYS[2], KEYS[3]
+local readVersion = tonumber(ARGV[1])
+local truncated = ARGV[2]
+local ttlMs = ARGV[3]
+local user = ARGV[4]
+
+-- The head was read from the persistent storage at readVersion. Any write
+-- since then bumped the version, so the head may miss it and is not stored.
+
+local currentVersion = tonumber(redis.call("HGET", metaKey, "vers") or "0")
+if currentVersion ~= readVersion then
+    return 0
+end
+
+redis.call("DEL", feedKey, itemsKey)
+-- items of a loaded head keep a marker, so an empty head is loaded too
+redis.call("HSET", itemsKey, "loaded", 1)
+-- entries go as (member, score, item) triples, post users keys follow the three feed keys
+for i = 5, #ARGV, 3 do
+    local member, score, item = ARGV[i], ARGV[i + 1], ARGV[i + 2]
+    redis.call("ZADD", feedKey, score, member)
+    redis.call("HSET", itemsKey, member, item)
+    local postUsersKey = KEYS[4 + (i - 5) / 3]
+    redis.call("SADD", postUsersKey, user)
+end
+redis.call("HSET", metaKey, "vers", currentVersion, "truncated", truncated)
+redis.call("PEXPIRE", feedKey, ttlMs)
+redis.call("PEXPIRE", itemsKey, ttlMs)
+redis.call("PEXPIRE", metaKey, ttlMs)
+-- users sets expire after the head
+for i = 4, #KEYS do
+    redis.call("PEXPIRE", KEYS[i], ttlMs)
+end
+return 1
@@ -0,0 +1,53 @@
+local feedKey, itemsKey, metaKey, postUsersKey = KEYS[1], KEYS[2], KEYS[3], KEYS[4]
+local member = ARGV[1]
+local score = tonumber(ARGV[2])
+local item = ARGV[3]
+local headSize = tonumber(ARGV[4])
+local ttlMs = ARGV[5]
+local user = ARGV[6]
+
+-- Every write bumps the version, so heads read before it are not stored.
+-- Meta lives as long as the head, so the version is never reset under a reader.
+-- The head is not extended, it expires ttl after it is filled. Users sets of its
+-- posts are extended by every put and fill, so they outlive every head of the post.
+
+redis.call("HINCRBY", metaKey, "vers", 1)
+redis.call("PEXPIRE", metaKey, ttlMs)
+if redis.call("HEXISTS", itemsKey, "loaded") == 0 then
+    return 0
+end
+-- the persistent storage keeps positions of present items
+if redis.call("ZSCORE", feedKey, member) then
+    return 0
+end
+
+if redis.call("HGET", metaKey, "truncated") == "1" then
+    local oldest = redis.call("ZRANGE", feedKey, 0, 0, "WITHSCORES")
+    if oldest[2] ~= nil then
+        local oldestScore = tonumber(oldest[2])
+        if score == oldestScore then
+            -- the item may go either side of the head border
+            redis.call("DEL", feedKey, itemsKey)
+            return 0
+        end
+        if score < oldestScore then
+            return 0
+        end
+    end
+end
+
+redis.call("ZADD", feedKey, score, member)
+redis.call("HSET", itemsKey, member, item)
+redis.call("SADD", postUsersKey, user)
+redis.call("PEXPIRE", postUsersKey, ttlMs)
+
+local overflow = redis.call("ZCARD", feedKey) - headSize
+if overflow > 0 then
+    local evicted = redis.call("ZRANGE", feedKey, 0, overflow - 1)
+    redis.call("ZREMRANGEBYRANK", feedKey, 0, overflow - 1)
+    redis.call("HDEL", itemsKey, unpack(evicted))
+    redis.call("HSET", metaKey, "truncated", 1)
+end
+-- the feed set is gone once the last member is removed, the recreated one expires with items
+redis.call("PEXPIRE", feedKey, redis.call("PTTL", itemsKey))
+return 1
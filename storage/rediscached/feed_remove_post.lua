@@ -0,0 +1,19 @@
+local postUsersKey = KEYS[1]
+local member = ARGV[1]
+local ttlMs = ARGV[2]
+
+-- The post is removed from cached heads of the users it was put to, keys of every
+-- head go as (feed, items, meta) triples after the post users key, users go after
+-- the two arguments. Users the post was put to meanwhile are left for the next run.
+
+for i = 2, #KEYS, 3 do
+    local feedKey, itemsKey, metaKey = KEYS[i], KEYS[i + 1], KEYS[i + 2]
+    redis.call("HINCRBY", metaKey, "vers", 1)
+    redis.call("PEXPIRE", metaKey, ttlMs)
+    redis.call("ZREM", feedKey, member)
+    redis.call("HDEL", itemsKey, member)
+end
+for i = 3, #ARGV do
+    redis.call("SREM", postUsersKey, ARGV[i])
+end
+return redis.call("SCARD", postUsersKey)
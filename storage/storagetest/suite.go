@@ -0,0 +1,981 @@
+// Package storagetest is the conformance suite every storage.Storage implementation must pass
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"netwitter/plain"
+	"netwitter/schemas"
+	"netwitter/storage"
+	"netwitter/workers"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Factory returns a storage that is empty for the calling test
+type Factory func(t *testing.T) storage.Storage
+
+func RunStorageSuite(t *testing.T, newStorage Factory) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s storage.Storage)
+	}{
+		{"PutGet", testPutGet},
+		{"PutReservedID", testPutReservedID},
+		{"GetNotFound", testGetNotFound},
+		{"Edit", testEdit},
+		{"EditNotFound", testEditNotFound},
+		{"EditByOtherAuthor", testEditByOtherAuthor},
+		{"Delete", testDelete},
+		{"Revisions", testRevisions},
+		{"Replies", testReplies},
+		{"RepostCollision", testRepostCollision},
+		{"UserReposts", testUserReposts},
+		{"Mentions", testMentions},
+		{"MentionsRemovedByEdit", testMentionsRemovedByEdit},
+		{"Hashtags", testHashtags},
+		{"Search", testSearch},
+		{"SearchPagination", testSearchPagination},
+		{"SearchPaginationWhileWriting", testSearchPaginationWhileWriting},
+		{"SearchAfterEdit", testSearchAfterEdit},
+		{"AllPostsFromUser", testAllPostsFromUser},
+		{"PaginationWalk", testPaginationWalk},
+		{"PaginationExactPages", testPaginationExactPages},
+		{"PaginationDefaultSize", testPaginationDefaultSize},
+		{"PaginationEmpty", testPaginationEmpty},
+		{"PaginationInvalidPage", testPaginationInvalidPage},
+		{"PaginationAfterEdit", testPaginationAfterEdit},
+		{"PaginationAfterDelete", testPaginationAfterDelete},
+		{"PaginationSizeFromToken", testPaginationSizeFromToken},
+		{"PaginationNewer", testPaginationNewer},
+		{"PaginationNewerFromEmpty", testPaginationNewerFromEmpty},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newStorage(t))
+		})
+	}
+}
+
+var userCounter int
+
+// newUser returns user id unique across runs, so storages may be shared between tests
+func newUser(t *testing.T) schemas.UserId {
+	userCounter++
+	return schemas.UserId(fmt.Sprintf("user-%d-%s", userCounter, primitive.NewObjectID().Hex()))
+}
+
+func putPosts(t *testing.T, s storage.Storage, author schemas.UserId, count int) []*schemas.Post {
+	posts := make([]*schemas.Post, 0, count)
+	for i := 0; i < count; i++ {
+		post, err := s.PutPost(context.Background(), author, schemas.Text(fmt.Sprintf("post %d", i)), plain.PostOptions{})
+		if err != nil {
+			t.Fatalf("put post: %v", err)
+		}
+		posts = append(posts, post)
+	}
+	return posts
+}
+
+func assertSamePost(t *testing.T, expected *schemas.Post, actual *schemas.Post) {
+	t.Helper()
+	if expected.ID != actual.ID {
+		t.Errorf("id: expected %s, got %s", expected.ID.Hex(), actual.ID.Hex())
+	}
+	if expected.AuthorID != actual.AuthorID {
+		t.Errorf("author: expected %s, got %s", expected.AuthorID, actual.AuthorID)
+	}
+	if expected.Content != actual.Content {
+		t.Errorf("content: expected %q, got %q", expected.Content, actual.Content)
+	}
+	if expected.Version != actual.Version {
+		t.Errorf("version: expected %d, got %d", expected.Version, actual.Version)
+	}
+	if !expected.CreatedAt.Equal(actual.CreatedAt) {
+		t.Errorf("createdAt: expected %s, got %s", expected.CreatedAt, actual.CreatedAt)
+	}
+	if !expected.LastModifiedAt.Equal(actual.LastModifiedAt) {
+		t.Errorf("lastModifiedAt: expected %s, got %s", expected.LastModifiedAt, actual.LastModifiedAt)
+	}
+}
+
+func assertNotFound(t *testing.T, err error) {
+	t.Helper()
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func testPutGet(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t)
+
+	post, err := s.PutPost(ctx, author, "hello", plain.PostOptions{})
+	if err != nil {
+		t.Fatalf("put post: %v", err)
+	}
+	if post.AuthorID != author || post.Content != "hello" || post.Version != 0 {
+		t.Errorf("unexpected new post: %+v", post)
+	}
+	if post.CreatedAt.IsZero() || !post.LastModifiedAt.Equal(post.CreatedAt) {
+		t.Errorf("new post must be modified at creation: %s, %s", post.CreatedAt, post.LastModifiedAt)
+	}
+
+	got, err := s.GetPost(ctx, post.ID)
+	if err != nil {
+		t.Fatalf("get post: %v", err)
+	}
+	assertSamePost(t, post, got)
+}
+
+func testPutReservedID(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	postId := schemas.NewPostId()
+
+	post, err := s.PutPost(ctx, newUser(t), "hello", plain.PostOptions{ID: &postId})
+	if err != nil {
+		t.Fatalf("put post: %v", err)
+	}
+	if post.ID != postId {
+		t.Errorf("expected reserved id %s, got %s", postId.Hex(), post.ID.Hex())
+	}
+	got, err := s.GetPost(ctx, postId)
+	if err != nil {
+		t.Fatalf("get post: %v", err)
+	}
+	assertSamePost(t, post, got)
+}
+
+func testGetNotFound(t *testing.T, s storage.Storage) {
+	_, err := s.GetPost(context.Background(), schemas.PostId(primitive.NewObjectID()))
+	assertNotFound(t, err)
+}
+
+func testEdit(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t)
+	post := putPosts(t, s, author, 1)[0]
+
+	edited, err := s.EditPost(ctx, post.ID, author, "edited")
+	if err != nil {
+		t.Fatalf("edit post: %v", err)
+	}
+	if edited.Content != "edited" || edited.Version != post.Version+1 {
+		t.Errorf("unexpected edited post: %+v", edited)
+	}
+	if !edited.CreatedAt.Equal(post.CreatedAt) || edited.LastModifiedAt.Before(post.LastModifiedAt) {
+		t.Errorf("unexpected edited post times: %s, %s", edited.CreatedAt, edited.LastModifiedAt)
+	}
+
+	got, err := s.GetPost(ctx, post.ID)
+	if err != nil {
+		t.Fatalf("get post: %v", err)
+	}
+	assertSamePost(t, edited, got)
+
+	again, err := s.EditPost(ctx, post.ID, author, "edited again")
+	if err != nil {
+		t.Fatalf("edit post: %v", err)
+	}
+	if again.Version != post.Version+2 {
+		t.Errorf("version must grow on every edit, got %d", again.Version)
+	}
+}
+
+func testEditNotFound(t *testing.T, s storage.Storage) {
+	_, err := s.EditPost(context.Background(), schemas.PostId(primitive.NewObjectID()), newUser(t), "edited")
+	assertNotFound(t, err)
+}
+
+func testEditByOtherAuthor(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	post := putPosts(t, s, newUser(t), 1)[0]
+
+	_, err := s.EditPost(ctx, post.ID, newUser(t), "edited")
+	assertNotFound(t, err)
+
+	got, err := s.GetPost(ctx, post.ID)
+	if err != nil {
+		t.Fatalf("get post: %v", err)
+	}
+	assertSamePost(t, post, got)
+}
+
+func testDelete(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t)
+	posts := putPosts(t, s, author, 3)
+
+	err := s.DeletePost(ctx, posts[1].ID, newUser(t))
+	assertNotFound(t, err)
+
+	err = s.DeletePost(ctx, posts[1].ID, author)
+	if err != nil {
+		t.Fatalf("delete post: %v", err)
+	}
+	_, err = s.GetPost(ctx, posts[1].ID)
+	assertNotFound(t, err)
+	err = s.DeletePost(ctx, posts[1].ID, author)
+	assertNotFound(t, err)
+
+	page, _, err := s.GetUserPosts(ctx, author, plain.GetUserPostsPageData{})
+	if err != nil {
+		t.Fatalf("get user posts: %v", err)
+	}
+	if len(page) != 2 || page[0].ID != posts[2].ID || page[1].ID != posts[0].ID {
+		t.Errorf("deleted post must leave user posts")
+	}
+}
+
+func testRevisions(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t)
+	post := putPosts(t, s, author, 1)[0]
+
+	revisions, next, err := s.GetPostRevisions(ctx, post.ID, plain.GetUserPostsPageData{})
+	if err != nil || len(revisions) != 0 || next != nil {
+		t.Fatalf("new post must have no revisions: %v, %d", err, len(revisions))
+	}
+
+	for _, text := range []schemas.Text{"first", "second", "third"} {
+		_, err = s.EditPost(ctx, post.ID, author, text)
+		if err != nil {
+			t.Fatalf("edit post: %v", err)
+		}
+	}
+
+	revisions, next, err = s.GetPostRevisions(ctx, post.ID, plain.GetUserPostsPageData{Size: 2})
+	if err != nil {
+		t.Fatalf("get revisions: %v", err)
+	}
+	if len(revisions) != 2 || next == nil {
+		t.Fatalf("expected full page with next one, got %d", len(revisions))
+	}
+	if revisions[0].Content != "second" || revisions[0].Version != 2 || revisions[1].Content != "first" || revisions[1].Version != 1 {
+		t.Errorf("revisions must go newest first: %+v, %+v", revisions[0], revisions[1])
+	}
+
+	revisions, next, err = s.GetPostRevisions(ctx, post.ID, *next)
+	if err != nil {
+		t.Fatalf("get revisions: %v", err)
+	}
+	if len(revisions) != 1 || next != nil {
+		t.Fatalf("expected last page, got %d", len(revisions))
+	}
+	if revisions[0].Content != post.Content || revisions[0].Version != 0 || !revisions[0].LastModifiedAt.Equal(post.LastModifiedAt) {
+		t.Errorf("oldest revision must be the original post: %+v", revisions[0])
+	}
+}
+
+func testReplies(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	root := putPosts(t, s, newUser(t), 1)[0]
+
+	replier := newUser(t)
+	var replies []*schemas.Post
+	for i := 0; i < 3; i++ {
+		reply, err := s.PutPost(ctx, replier, "reply", plain.PostOptions{ParentID: &root.ID})
+		if err != nil {
+			t.Fatalf("put reply: %v", err)
+		}
+		if reply.ParentID == nil || *reply.ParentID != root.ID {
+			t.Fatalf("reply must keep parent")
+		}
+		replies = append(replies, reply)
+	}
+
+	page, next, err := s.GetPostReplies(ctx, root.ID, plain.GetUserPostsPageData{Size: 2})
+	if err != nil {
+		t.Fatalf("get replies: %v", err)
+	}
+	if len(page) != 2 || next == nil || page[0].ID != replies[2].ID || page[1].ID != replies[1].ID {
+		t.Fatalf("unexpected first replies page")
+	}
+
+	iterator, err := s.GetAllRepliesToPost(ctx, root.ID)
+	if err != nil {
+		t.Fatalf("get all replies: %v", err)
+	}
+	for i := range replies {
+		p := iterator.GetNextPost(ctx)
+		if p == nil || p.ID != replies[i].ID {
+			t.Fatalf("all replies must go oldest first")
+		}
+	}
+	if iterator.GetNextPost(ctx) != nil {
+		t.Errorf("unexpected reply")
+	}
+	if iterator.Err() != nil {
+		t.Errorf("iterate replies: %v", iterator.Err())
+	}
+	err = iterator.Close(ctx)
+	if err != nil {
+		t.Errorf("close replies iterator: %v", err)
+	}
+}
+
+func testRepostCollision(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	post := putPosts(t, s, newUser(t), 1)[0]
+	reposter := newUser(t)
+
+	err := s.PutRepost(ctx, reposter, post.ID)
+	if err != nil {
+		t.Fatalf("repost: %v", err)
+	}
+	err = s.PutRepost(ctx, reposter, post.ID)
+	if !errors.Is(err, storage.ErrCollision) {
+		t.Errorf("expected collision, got %v", err)
+	}
+}
+
+// repostAll reposts the posts one by one, so reposts do not tie on time
+func repostAll(t *testing.T, s storage.Storage, reposter schemas.UserId, posts []*schemas.Post) {
+	t.Helper()
+	for _, post := range posts {
+		time.Sleep(2 * time.Millisecond)
+		err := s.PutRepost(context.Background(), reposter, post.ID)
+		if err != nil {
+			t.Fatalf("repost: %v", err)
+		}
+	}
+}
+
+func assertReposts(t *testing.T, reposter schemas.UserId, expected []*schemas.Post, actual []*schemas.FeedEntry) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d reposts, got %d", len(expected), len(actual))
+	}
+	for i := range expected {
+		if actual[i].PostID != expected[i].ID || actual[i].AuthorID != expected[i].AuthorID || actual[i].RepostedBy != reposter {
+			t.Errorf("repost %d: expected %s by %s, got %+v", i, expected[i].ID.Hex(), expected[i].AuthorID, actual[i])
+		}
+	}
+}
+
+func testUserReposts(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t)
+	reposter := newUser(t)
+	posts := append(putPosts(t, s, author, 3), putPosts(t, s, newUser(t), 2)...)
+	repostAll(t, s, reposter, []*schemas.Post{posts[3], posts[0], posts[4], posts[1]})
+
+	var walked []*schemas.FeedEntry
+	page := plain.GetUserPostsPageData{Size: 3}
+	for pages := 0; pages < 10; pages++ {
+		reposts, next, err := s.GetUserReposts(ctx, reposter, page)
+		if err != nil {
+			t.Fatalf("get user reposts: %v", err)
+		}
+		walked = append(walked, reposts...)
+		if next == nil {
+			break
+		}
+		page = *next
+	}
+	assertReposts(t, reposter, []*schemas.Post{posts[1], posts[4], posts[0], posts[3]}, walked)
+	for i := 1; i < len(walked); i++ {
+		if walked[i].AddedAt.After(walked[i-1].AddedAt) {
+			t.Errorf("repost %d is newer than the previous one", i)
+		}
+	}
+
+	head := plain.HeadPage(plain.TimeSortKey(walked[0].AddedAt), walked[0].PostID, 1, 0)
+	repostAll(t, s, reposter, []*schemas.Post{posts[2]})
+	err := s.DeletePost(ctx, posts[4].ID, posts[4].AuthorID)
+	if err != nil {
+		t.Fatalf("delete post: %v", err)
+	}
+	newer, head, err := s.GetUserReposts(ctx, reposter, *head)
+	if err != nil {
+		t.Fatalf("get newer reposts: %v", err)
+	}
+	assertReposts(t, reposter, []*schemas.Post{posts[2]}, newer)
+	if head == nil || head.Pending != 0 {
+		t.Errorf("expected a head with no pending reposts, got %+v", head)
+	}
+
+	all, _, err := s.GetUserReposts(ctx, reposter, plain.GetUserPostsPageData{Size: 10})
+	if err != nil {
+		t.Fatalf("get user reposts: %v", err)
+	}
+	assertReposts(t, reposter, []*schemas.Post{posts[2], posts[1], posts[0], posts[3]}, all)
+}
+
+func assertMentionsPage(t *testing.T, s storage.Storage, userId schemas.UserId, expected ...*schemas.Post) {
+	t.Helper()
+	page, next, err := s.GetUserMentions(context.Background(), userId, plain.GetUserPostsPageData{Size: 10})
+	if err != nil {
+		t.Fatalf("get mentions: %v", err)
+	}
+	if len(page) != len(expected) || next != nil {
+		t.Fatalf("expected %d mentions, got %d", len(expected), len(page))
+	}
+	for i := range page {
+		if page[i].ID != expected[i].ID {
+			t.Errorf("unexpected mention %d: %s", i, page[i].ID.Hex())
+		}
+	}
+}
+
+func testMentions(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author, first, second := newUser(t), newUser(t), newUser(t)
+
+	text := fmt.Sprintf("hi @%s and @%s, bye @%s", first, second, first)
+	post, err := s.PutPost(ctx, author, schemas.Text(text), plain.PostOptions{})
+	if err != nil {
+		t.Fatalf("put post: %v", err)
+	}
+	if len(post.Mentions) != 3 || post.Mentions[0].UserID != first || post.Mentions[1].UserID != second {
+		t.Fatalf("unexpected mentions: %+v", post.Mentions)
+	}
+	if post.Mentions[0].Offset != 3 || post.Mentions[0].Length != len(first)+1 {
+		t.Errorf("unexpected mention position: %+v", post.Mentions[0])
+	}
+
+	got, err := s.GetPost(ctx, post.ID)
+	if err != nil {
+		t.Fatalf("get post: %v", err)
+	}
+	if len(got.Mentions) != 3 {
+		t.Errorf("mentions must be stored, got %+v", got.Mentions)
+	}
+
+	later, err := s.PutPost(ctx, author, schemas.Text("@"+first), plain.PostOptions{})
+	if err != nil {
+		t.Fatalf("put post: %v", err)
+	}
+	putPosts(t, s, author, 2)
+
+	assertMentionsPage(t, s, first, later, post)
+	assertMentionsPage(t, s, second, post)
+	assertMentionsPage(t, s, author)
+
+	err = s.DeletePost(ctx, later.ID, author)
+	if err != nil {
+		t.Fatalf("delete post: %v", err)
+	}
+	assertMentionsPage(t, s, first, post)
+}
+
+func testMentionsRemovedByEdit(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author, first, second := newUser(t), newUser(t), newUser(t)
+	post, err := s.PutPost(ctx, author, schemas.Text("cc @"+first), plain.PostOptions{})
+	if err != nil {
+		t.Fatalf("put post: %v", err)
+	}
+
+	edited, err := s.EditPost(ctx, post.ID, author, schemas.Text("cc @"+second))
+	if err != nil {
+		t.Fatalf("edit post: %v", err)
+	}
+	if len(edited.Mentions) != 1 || edited.Mentions[0].UserID != second {
+		t.Errorf("unexpected mentions after edit: %+v", edited.Mentions)
+	}
+	assertMentionsPage(t, s, first)
+	assertMentionsPage(t, s, second, post)
+
+	_, err = s.EditPost(ctx, post.ID, author, "nobody")
+	if err != nil {
+		t.Fatalf("edit post: %v", err)
+	}
+	assertMentionsPage(t, s, second)
+}
+
+func assertHashtagPage(t *testing.T, s storage.Storage, hashtag schemas.Hashtag, expected ...*schemas.Post) {
+	t.Helper()
+	page, next, err := s.GetHashtagPosts(context.Background(), hashtag, plain.GetUserPostsPageData{Size: 10})
+	if err != nil {
+		t.Fatalf("get hashtag posts: %v", err)
+	}
+	if len(page) != len(expected) || next != nil {
+		t.Fatalf("expected %d posts with #%s, got %d", len(expected), hashtag, len(page))
+	}
+	for i := range page {
+		if page[i].ID != expected[i].ID {
+			t.Errorf("unexpected post %d: %s", i, page[i].ID.Hex())
+		}
+	}
+}
+
+func testHashtags(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t)
+	// hashtags are global, unique ones keep tests independent on shared storages
+	suffix := primitive.NewObjectID().Hex()
+	first, second := schemas.Hashtag("first"+suffix), schemas.Hashtag("second"+suffix)
+
+	text := fmt.Sprintf("#First%s and #%s, again #%s", suffix, second, first)
+	post, err := s.PutPost(ctx, author, schemas.Text(text), plain.PostOptions{})
+	if err != nil {
+		t.Fatalf("put post: %v", err)
+	}
+	if len(post.Hashtags) != 2 || post.Hashtags[0] != first || post.Hashtags[1] != second {
+		t.Fatalf("unexpected hashtags: %+v", post.Hashtags)
+	}
+	later, err := s.PutPost(ctx, newUser(t), schemas.Text("#"+first), plain.PostOptions{})
+	if err != nil {
+		t.Fatalf("put post: %v", err)
+	}
+	assertHashtagPage(t, s, first, later, post)
+	assertHashtagPage(t, s, second, post)
+
+	_, err = s.EditPost(ctx, post.ID, author, schemas.Text("only #"+second))
+	if err != nil {
+		t.Fatalf("edit post: %v", err)
+	}
+	assertHashtagPage(t, s, first, later)
+	assertHashtagPage(t, s, second, post)
+
+	err = s.DeletePost(ctx, post.ID, author)
+	if err != nil {
+		t.Fatalf("delete post: %v", err)
+	}
+	assertHashtagPage(t, s, second)
+}
+
+// newWord returns a word no other test uses, so search results are predictable on shared storages
+func newWord() string {
+	return "w" + primitive.NewObjectID().Hex()
+}
+
+func searchAll(t *testing.T, s storage.Storage, query plain.SearchQuery, size int) []*schemas.Post {
+	return searchFrom(t, s, query, plain.SearchPageData{Size: size})
+}
+
+// searchFrom collects search results from the page to the end
+func searchFrom(t *testing.T, s storage.Storage, query plain.SearchQuery, pageData plain.SearchPageData) []*schemas.Post {
+	ctx := context.Background()
+	var collected []*schemas.Post
+	for pages := 0; ; pages++ {
+		page, next, err := s.SearchPosts(ctx, query, pageData)
+		if err != nil {
+			t.Fatalf("search posts: %v", err)
+		}
+		if len(page) > pageData.Size || pages > 100 {
+			t.Fatalf("broken search pagination")
+		}
+		collected = append(collected, page...)
+		if next == nil {
+			return collected
+		}
+		pageData = *next
+	}
+}
+
+func assertFound(t *testing.T, found []*schemas.Post, expected ...*schemas.Post) {
+	t.Helper()
+	if len(found) != len(expected) {
+		t.Fatalf("expected %d posts, found %d", len(expected), len(found))
+	}
+	ids := map[schemas.PostId]bool{}
+	for _, post := range found {
+		if ids[post.ID] {
+			t.Errorf("post %s is found twice", post.ID.Hex())
+		}
+		ids[post.ID] = true
+	}
+	for _, post := range expected {
+		if !ids[post.ID] {
+			t.Errorf("post %s is not found", post.ID.Hex())
+		}
+	}
+}
+
+func testSearch(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author, other := newUser(t), newUser(t)
+	word, rareWord := newWord(), newWord()
+
+	first, err := s.PutPost(ctx, author, schemas.Text("about "+word), plain.PostOptions{})
+	if err != nil {
+		t.Fatalf("put post: %v", err)
+	}
+	second, err := s.PutPost(ctx, other, schemas.Text(word+" and "+rareWord), plain.PostOptions{})
+	if err != nil {
+		t.Fatalf("put post: %v", err)
+	}
+	putPosts(t, s, author, 2)
+
+	assertFound(t, searchAll(t, s, plain.SearchQuery{Text: word}, 10), first, second)
+	assertFound(t, searchAll(t, s, plain.SearchQuery{Text: word, AuthorID: author}, 10), first)
+	assertFound(t, searchAll(t, s, plain.SearchQuery{Text: newWord()}, 10))
+
+	found := searchAll(t, s, plain.SearchQuery{Text: word + " " + rareWord}, 10)
+	assertFound(t, found, first, second)
+	if found[0].ID != second.ID {
+		t.Errorf("post matching more words must go first")
+	}
+}
+
+func testSearchPagination(t *testing.T, s storage.Storage) {
+	author := newUser(t)
+	word := newWord()
+	var posts []*schemas.Post
+	for i := 0; i < 7; i++ {
+		post, err := s.PutPost(context.Background(), author, schemas.Text(word), plain.PostOptions{})
+		if err != nil {
+			t.Fatalf("put post: %v", err)
+		}
+		posts = append(posts, post)
+	}
+
+	found := searchAll(t, s, plain.SearchQuery{Text: word}, 3)
+	assertFound(t, found, posts...)
+	for i := range found {
+		if found[i].ID != posts[len(posts)-1-i].ID {
+			t.Errorf("equally relevant posts must go newest first")
+		}
+	}
+
+	_, _, err := s.SearchPosts(context.Background(), plain.SearchQuery{Text: word}, plain.SearchPageData{Cursor: "garbage"})
+	if err == nil {
+		t.Errorf("expected error on invalid cursor")
+	}
+}
+
+func testSearchPaginationWhileWriting(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t)
+	word := newWord()
+	var posts []*schemas.Post
+	for i := 1; i <= 6; i++ {
+		post, err := s.PutPost(ctx, author, schemas.Text(strings.Repeat(word+" ", i%3+1)), plain.PostOptions{})
+		if err != nil {
+			t.Fatalf("put post: %v", err)
+		}
+		posts = append(posts, post)
+	}
+
+	query := plain.SearchQuery{Text: word}
+	found, next, err := s.SearchPosts(ctx, query, plain.SearchPageData{Size: 2})
+	if err != nil {
+		t.Fatalf("search posts: %v", err)
+	}
+	if next == nil {
+		t.Fatal("expected more pages")
+	}
+	// posts written between pages change nothing in the ranking of the rest
+	putPosts(t, s, newUser(t), 5)
+	assertFound(t, append(found, searchFrom(t, s, query, *next)...), posts...)
+}
+
+func testSearchAfterEdit(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t)
+	oldWord, editedWord := newWord(), newWord()
+	post, err := s.PutPost(ctx, author, schemas.Text(oldWord), plain.PostOptions{})
+	if err != nil {
+		t.Fatalf("put post: %v", err)
+	}
+
+	_, err = s.EditPost(ctx, post.ID, author, schemas.Text(editedWord))
+	if err != nil {
+		t.Fatalf("edit post: %v", err)
+	}
+	assertFound(t, searchAll(t, s, plain.SearchQuery{Text: oldWord}, 10))
+	assertFound(t, searchAll(t, s, plain.SearchQuery{Text: editedWord}, 10), post)
+
+	err = s.DeletePost(ctx, post.ID, author)
+	if err != nil {
+		t.Fatalf("delete post: %v", err)
+	}
+	assertFound(t, searchAll(t, s, plain.SearchQuery{Text: editedWord}, 10))
+}
+
+func testAllPostsFromUser(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t)
+	posts := putPosts(t, s, author, 4)
+	putPosts(t, s, newUser(t), 2)
+
+	iterator, err := s.GetAllPostsFromUser(ctx, author)
+	if err != nil {
+		t.Fatalf("get all posts: %v", err)
+	}
+	defer iterator.Close(ctx)
+	seen := map[schemas.PostId]bool{}
+	for p := iterator.GetNextPost(ctx); p != nil; p = iterator.GetNextPost(ctx) {
+		if p.AuthorID != author {
+			t.Errorf("foreign post %s", p.ID.Hex())
+		}
+		seen[p.ID] = true
+	}
+	if iterator.Err() != nil {
+		t.Errorf("iterate posts: %v", iterator.Err())
+	}
+	for _, post := range posts {
+		if !seen[post.ID] {
+			t.Errorf("missing post %s", post.ID.Hex())
+		}
+	}
+}
+
+// walkUserPosts collects all pages and fails on a page larger than size
+func walkUserPosts(t *testing.T, s storage.Storage, author schemas.UserId, size int) ([]*schemas.Post, []int) {
+	ctx := context.Background()
+	var collected []*schemas.Post
+	var pageSizes []int
+	pageData := plain.GetUserPostsPageData{Size: size}
+	for {
+		page, next, err := s.GetUserPosts(ctx, author, pageData)
+		if err != nil {
+			t.Fatalf("get user posts: %v", err)
+		}
+		if len(page) > size {
+			t.Fatalf("page overflow: %d > %d", len(page), size)
+		}
+		collected = append(collected, page...)
+		pageSizes = append(pageSizes, len(page))
+		if next == nil {
+			return collected, pageSizes
+		}
+		if len(pageSizes) > 100 {
+			t.Fatalf("pagination does not end")
+		}
+		pageData = *next
+	}
+}
+
+func assertNewestFirst(t *testing.T, expected []*schemas.Post, actual []*schemas.Post) {
+	t.Helper()
+	if len(expected) != len(actual) {
+		t.Fatalf("expected %d posts, got %d", len(expected), len(actual))
+	}
+	for i := range actual {
+		assertSamePost(t, expected[len(expected)-1-i], actual[i])
+	}
+}
+
+func testPaginationWalk(t *testing.T, s storage.Storage) {
+	author := newUser(t)
+	posts := putPosts(t, s, author, 7)
+	putPosts(t, s, newUser(t), 3)
+
+	collected, pageSizes := walkUserPosts(t, s, author, 3)
+	assertNewestFirst(t, posts, collected)
+	if fmt.Sprint(pageSizes) != "[3 3 1]" {
+		t.Errorf("unexpected page sizes %v", pageSizes)
+	}
+}
+
+func testPaginationExactPages(t *testing.T, s storage.Storage) {
+	author := newUser(t)
+	posts := putPosts(t, s, author, 6)
+
+	collected, pageSizes := walkUserPosts(t, s, author, 3)
+	assertNewestFirst(t, posts, collected)
+	if fmt.Sprint(pageSizes) != "[3 3]" {
+		t.Errorf("no empty page is expected after the exact last one, got %v", pageSizes)
+	}
+
+	collected, pageSizes = walkUserPosts(t, s, author, 10)
+	assertNewestFirst(t, posts, collected)
+	if fmt.Sprint(pageSizes) != "[6]" {
+		t.Errorf("unexpected page sizes %v", pageSizes)
+	}
+}
+
+func testPaginationDefaultSize(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t)
+	putPosts(t, s, author, plain.DefaultPageSize+1)
+
+	page, next, err := s.GetUserPosts(ctx, author, plain.GetUserPostsPageData{})
+	if err != nil {
+		t.Fatalf("get user posts: %v", err)
+	}
+	if len(page) != plain.DefaultPageSize || next == nil {
+		t.Errorf("zero size means default page size, got %d", len(page))
+	}
+}
+
+func testPaginationEmpty(t *testing.T, s storage.Storage) {
+	page, next, err := s.GetUserPosts(context.Background(), newUser(t), plain.GetUserPostsPageData{Size: 3})
+	if err != nil {
+		t.Fatalf("get user posts: %v", err)
+	}
+	if len(page) != 0 || next != nil {
+		t.Errorf("expected empty last page, got %d", len(page))
+	}
+}
+
+func testPaginationInvalidPage(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t)
+	posts := putPosts(t, s, author, 4)
+
+	_, next, err := s.GetUserPosts(ctx, author, plain.GetUserPostsPageData{Size: 2})
+	if err != nil {
+		t.Fatalf("get user posts: %v", err)
+	}
+	if next == nil {
+		t.Fatalf("expected next page")
+	}
+	tampered := []byte(next.Token)
+	tampered[len(tampered)/2] ^= 1
+
+	invalidPages := map[string]plain.GetUserPostsPageData{
+		"negative size":  {Size: -1},
+		"garbage token":  {Token: "not a token", Size: 3},
+		"raw post id":    {Token: posts[2].ID.ToBase64URL(), Size: 3},
+		"tampered token": {Token: string(tampered), Size: 3},
+	}
+	for name, pageData := range invalidPages {
+		_, _, err := s.GetUserPosts(ctx, author, pageData)
+		if err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func testPaginationAfterEdit(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t)
+	posts := putPosts(t, s, author, 4)
+
+	// cached storages may keep the first page
+	_, _, err := s.GetUserPosts(ctx, author, plain.GetUserPostsPageData{Size: 2})
+	if err != nil {
+		t.Fatalf("get user posts: %v", err)
+	}
+
+	edited, err := s.EditPost(ctx, posts[3].ID, author, "edited")
+	if err != nil {
+		t.Fatalf("edit post: %v", err)
+	}
+	posts[3] = edited
+
+	collected, _ := walkUserPosts(t, s, author, 2)
+	assertNewestFirst(t, posts, collected)
+}
+
+func testPaginationAfterDelete(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t)
+	posts := putPosts(t, s, author, 5)
+
+	firstPage, next, err := s.GetUserPosts(ctx, author, plain.GetUserPostsPageData{Size: 2})
+	if err != nil {
+		t.Fatalf("get user posts: %v", err)
+	}
+	if next == nil {
+		t.Fatalf("expected next page")
+	}
+
+	// the token points at the deleted post, the walk goes on right after it
+	err = s.DeletePost(ctx, firstPage[1].ID, author)
+	if err != nil {
+		t.Fatalf("delete post: %v", err)
+	}
+	secondPage, _, err := s.GetUserPosts(ctx, author, *next)
+	if err != nil {
+		t.Fatalf("get page after deleted post: %v", err)
+	}
+	if len(secondPage) != 2 || secondPage[0].ID != posts[2].ID || secondPage[1].ID != posts[1].ID {
+		t.Errorf("unexpected page after deleted post: %v", secondPage)
+	}
+}
+
+func testPaginationSizeFromToken(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t)
+	putPosts(t, s, author, 7)
+
+	_, next, err := s.GetUserPosts(ctx, author, plain.GetUserPostsPageData{Size: 3})
+	if err != nil {
+		t.Fatalf("get user posts: %v", err)
+	}
+	if next == nil {
+		t.Fatalf("expected next page")
+	}
+	page, _, err := s.GetUserPosts(ctx, author, plain.GetUserPostsPageData{Token: next.Token})
+	if err != nil {
+		t.Fatalf("get next page: %v", err)
+	}
+	if len(page) != 3 {
+		t.Errorf("zero size means the size of the token, got %d", len(page))
+	}
+}
+
+func testPaginationNewer(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t)
+	posts := putPosts(t, s, author, 3)
+
+	// cached storages serve newer posts from the first page
+	firstPage, _, err := s.GetUserPosts(ctx, author, plain.GetUserPostsPageData{Size: 2})
+	if err != nil {
+		t.Fatalf("get user posts: %v", err)
+	}
+	head := plain.HeadPage(0, firstPage[0].ID, 2, 0)
+	page, head := pollNewer(t, s, author, *head)
+	if len(page) != 0 || head.Pending != 0 {
+		t.Fatalf("expected no newer posts, got %d, pending %d", len(page), head.Pending)
+	}
+
+	posts = append(posts, putPosts(t, s, author, 5)...)
+	expected := []struct {
+		posts   []*schemas.Post
+		pending int
+	}{
+		{[]*schemas.Post{posts[3], posts[4]}, 3},
+		{[]*schemas.Post{posts[5], posts[6]}, 1},
+		{[]*schemas.Post{posts[7]}, 0},
+		{nil, 0},
+	}
+	for i, exp := range expected {
+		page, head = pollNewer(t, s, author, *head)
+		if head.Pending != exp.pending {
+			t.Errorf("poll %d: expected %d pending, got %d", i, exp.pending, head.Pending)
+		}
+		assertNewestFirst(t, exp.posts, page)
+	}
+}
+
+func testPaginationNewerFromEmpty(t *testing.T, s storage.Storage) {
+	author := newUser(t)
+	head := plain.HeadPageAt(time.Now(), 3)
+	posts := putPosts(t, s, author, 2)
+
+	page, head := pollNewer(t, s, author, *head)
+	if head.Pending != 0 {
+		t.Errorf("expected no pending posts, got %d", head.Pending)
+	}
+	assertNewestFirst(t, posts, page)
+}
+
+func pollNewer(t *testing.T, s storage.Storage, author schemas.UserId, head plain.GetUserPostsPageData) ([]*schemas.Post, *plain.GetUserPostsPageData) {
+	t.Helper()
+	page, nextHead, err := s.GetUserPosts(context.Background(), author, head)
+	if err != nil {
+		t.Fatalf("get newer posts: %v", err)
+	}
+	if nextHead == nil {
+		t.Fatalf("pages of newer posts must be followed by a head")
+	}
+	return page, nextHead
+}
+
+// NopScheduler drops every task, storages under test have no workers
+type NopScheduler struct{}
+
+var _ workers.Scheduler = NopScheduler{}
+
+func (NopScheduler) PublishSpreadPostOverSubs(schemas.UserId, schemas.PostId) error   { return nil }
+func (NopScheduler) PublishSpreadRepostOverSubs(schemas.UserId, schemas.PostId) error { return nil }
+func (NopScheduler) PublishRetractPostFromSubs(schemas.UserId, schemas.PostId) error  { return nil }
+func (NopScheduler) PublishCollectPostsToPersonalFeed(schemas.UserId, schemas.UserId) error {
+	return nil
+}
+func (NopScheduler) PublishRemovePostsFromPersonalFeed(schemas.UserId, schemas.UserId) error {
+	return nil
+}
+func (NopScheduler) Register(workers.PostsTasksExecutor) error { return nil }
+func (NopScheduler) Listen() error                             { return nil }
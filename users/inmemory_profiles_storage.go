@@ -0,0 +1,81 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"netwitter/plain"
+	"netwitter/schemas"
+	"netwitter/storage"
+	"sync"
+)
+
+type MemoryProfilesStorage struct {
+	mu sync.RWMutex
+
+	profileByUser map[schemas.UserId]*schemas.User
+}
+
+func NewInMemoryProfilesStorage() *MemoryProfilesStorage {
+	return &MemoryProfilesStorage{
+		profileByUser: map[schemas.UserId]*schemas.User{},
+	}
+}
+
+func (s *MemoryProfilesStorage) PutProfile(_ context.Context, user schemas.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.profileByUser[user.ID]; ok {
+		return fmt.Errorf("%w: user %s", storage.ErrCollision, user.ID)
+	}
+	s.profileByUser[user.ID] = &user
+	return nil
+}
+
+func (s *MemoryProfilesStorage) GetProfile(_ context.Context, userId schemas.UserId) (*schemas.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.profileByUser[userId]
+	if !ok {
+		return nil, fmt.Errorf("%w: user %s", storage.ErrNotFound, userId)
+	}
+	result := *user
+	return &result, nil
+}
+
+func (s *MemoryProfilesStorage) UpdateProfile(_ context.Context, userId schemas.UserId, update plain.ProfileUpdate) (*schemas.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.profileByUser[userId]
+	if !ok {
+		return nil, fmt.Errorf("%w: user %s", storage.ErrNotFound, userId)
+	}
+	if update.DisplayName != nil {
+		user.DisplayName = *update.DisplayName
+	}
+	if update.Bio != nil {
+		user.Bio = *update.Bio
+	}
+	if update.Private != nil {
+		user.Private = *update.Private
+	}
+	if update.AvatarID != nil {
+		user.AvatarID = nil
+		if *update.AvatarID != "" {
+			avatarId := *update.AvatarID
+			user.AvatarID = &avatarId
+		}
+	}
+	result := *user
+	return &result, nil
+}
+
+func (s *MemoryProfilesStorage) DeleteProfile(_ context.Context, userId schemas.UserId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.profileByUser, userId)
+	return nil
+}
@@ -0,0 +1,204 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"netwitter/schemas"
+	"netwitter/storage"
+	"sort"
+	"sync"
+)
+
+type MemoryUsersStorage struct {
+	mu sync.RWMutex
+
+	subscriptions map[schemas.UserId]map[schemas.UserId]struct{}
+	subscribers   map[schemas.UserId]map[schemas.UserId]struct{}
+	blocks        map[schemas.UserId]map[schemas.UserId]struct{}
+	mutes         map[schemas.UserId]map[schemas.UserId]struct{}
+	// followRequests are kept by target user
+	followRequests map[schemas.UserId]map[schemas.UserId]struct{}
+	pulledAuthors  map[schemas.UserId]struct{}
+}
+
+func NewInMemoryStorage() *MemoryUsersStorage {
+	return &MemoryUsersStorage{
+		subscriptions:  map[schemas.UserId]map[schemas.UserId]struct{}{},
+		subscribers:    map[schemas.UserId]map[schemas.UserId]struct{}{},
+		blocks:         map[schemas.UserId]map[schemas.UserId]struct{}{},
+		mutes:          map[schemas.UserId]map[schemas.UserId]struct{}{},
+		followRequests: map[schemas.UserId]map[schemas.UserId]struct{}{},
+		pulledAuthors:  map[schemas.UserId]struct{}{},
+	}
+}
+
+func (s *MemoryUsersStorage) MakeSubscription(_ context.Context, subscriber schemas.UserId, to schemas.UserId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addToSet(s.subscriptions, subscriber, to)
+	addToSet(s.subscribers, to, subscriber)
+	return nil
+}
+
+func (s *MemoryUsersStorage) RemoveSubscription(_ context.Context, subscriber schemas.UserId, from schemas.UserId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.subscriptions[subscriber], from)
+	delete(s.subscribers[from], subscriber)
+	return nil
+}
+
+func (s *MemoryUsersStorage) GetUserSubscriptions(_ context.Context, userId schemas.UserId) ([]schemas.UserId, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return setToList(s.subscriptions[userId]), nil
+}
+
+func (s *MemoryUsersStorage) GetUserSubscribers(_ context.Context, userId schemas.UserId) ([]schemas.UserId, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return setToList(s.subscribers[userId]), nil
+}
+
+func (s *MemoryUsersStorage) IsSubscribed(_ context.Context, subscriber schemas.UserId, to schemas.UserId) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.subscriptions[subscriber][to]
+	return ok, nil
+}
+
+func (s *MemoryUsersStorage) CountUserSubscribers(_ context.Context, userId schemas.UserId) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.subscribers[userId]), nil
+}
+
+func (s *MemoryUsersStorage) MarkPulledAuthor(_ context.Context, authorId schemas.UserId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pulledAuthors[authorId] = struct{}{}
+	return nil
+}
+
+func (s *MemoryUsersStorage) IsPulledAuthor(_ context.Context, authorId schemas.UserId) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.pulledAuthors[authorId]
+	return ok, nil
+}
+
+func (s *MemoryUsersStorage) GetPulledSubscriptions(_ context.Context, userId schemas.UserId) ([]schemas.UserId, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pulled := map[schemas.UserId]struct{}{}
+	for author := range s.subscriptions[userId] {
+		if _, ok := s.pulledAuthors[author]; ok {
+			pulled[author] = struct{}{}
+		}
+	}
+	return setToList(pulled), nil
+}
+
+func (s *MemoryUsersStorage) PutBlock(_ context.Context, blocker schemas.UserId, blocked schemas.UserId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addToSet(s.blocks, blocker, blocked)
+	return nil
+}
+
+func (s *MemoryUsersStorage) RemoveBlock(_ context.Context, blocker schemas.UserId, blocked schemas.UserId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.blocks[blocker], blocked)
+	return nil
+}
+
+func (s *MemoryUsersStorage) GetBlockedUsers(_ context.Context, userId schemas.UserId) ([]schemas.UserId, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return setToList(s.blocks[userId]), nil
+}
+
+func (s *MemoryUsersStorage) IsBlockedBetween(_ context.Context, first schemas.UserId, second schemas.UserId) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, firstBlocked := s.blocks[first][second]
+	_, secondBlocked := s.blocks[second][first]
+	return firstBlocked || secondBlocked, nil
+}
+
+func (s *MemoryUsersStorage) PutMute(_ context.Context, muter schemas.UserId, muted schemas.UserId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addToSet(s.mutes, muter, muted)
+	return nil
+}
+
+func (s *MemoryUsersStorage) RemoveMute(_ context.Context, muter schemas.UserId, muted schemas.UserId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.mutes[muter], muted)
+	return nil
+}
+
+func (s *MemoryUsersStorage) GetMutedUsers(_ context.Context, userId schemas.UserId) ([]schemas.UserId, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return setToList(s.mutes[userId]), nil
+}
+
+func (s *MemoryUsersStorage) PutFollowRequest(_ context.Context, requester schemas.UserId, target schemas.UserId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addToSet(s.followRequests, target, requester)
+	return nil
+}
+
+func (s *MemoryUsersStorage) RemoveFollowRequest(_ context.Context, requester schemas.UserId, target schemas.UserId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.followRequests[target][requester]; !ok {
+		return fmt.Errorf("%w: follow request of %s to %s", storage.ErrNotFound, requester, target)
+	}
+	delete(s.followRequests[target], requester)
+	return nil
+}
+
+func (s *MemoryUsersStorage) GetFollowRequests(_ context.Context, target schemas.UserId) ([]schemas.UserId, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return setToList(s.followRequests[target]), nil
+}
+
+func addToSet(sets map[schemas.UserId]map[schemas.UserId]struct{}, key schemas.UserId, value schemas.UserId) {
+	set, ok := sets[key]
+	if !ok {
+		set = map[schemas.UserId]struct{}{}
+		sets[key] = set
+	}
+	set[value] = struct{}{}
+}
+
+func setToList(set map[schemas.UserId]struct{}) []schemas.UserId {
+	userList := make([]schemas.UserId, 0, len(set))
+	for userId := range set {
+		userList = append(userList, userId)
+	}
+	sort.Slice(userList, func(i, j int) bool {
+		return userList[i] < userList[j]
+	})
+	return userList
+}
@@ -2,23 +2,126 @@ package users
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"netwitter/plain"
 	"netwitter/schemas"
 	"netwitter/storage"
 	"netwitter/workers"
+	"regexp"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	MaxDisplayNameLength = 50
+	MaxBioLength         = 160
+)
+
+// handlePattern keeps handles mentionable
+var handlePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+var (
+	UsersError        = errors.New("users")
+	ErrInvalidProfile = fmt.Errorf("%w.invalid_profile", UsersError)
+	ErrBlocked        = fmt.Errorf("%w.blocked", UsersError)
 )
 
 type UsersManager struct {
-	usersStorage storage.UsersStorage
-	feedStorage  storage.FeedStorage
-	scheduler    workers.Scheduler
+	usersStorage    storage.UsersStorage
+	profilesStorage storage.ProfilesStorage
+	scheduler       workers.Scheduler
+}
+
+func NewUsersManager(usersStorage storage.UsersStorage, profilesStorage storage.ProfilesStorage, scheduler workers.Scheduler) *UsersManager {
+	return &UsersManager{usersStorage: usersStorage, profilesStorage: profilesStorage, scheduler: scheduler}
+}
+
+// ValidateProfile checks a profile before registration, the avatar is checked by media
+func ValidateProfile(user schemas.User) error {
+	if !handlePattern.MatchString(string(user.ID)) {
+		return fmt.Errorf("%w: handle must be 1 to 32 latin letters, digits, '_' or '-'", ErrInvalidProfile)
+	}
+	return validateProfileUpdate(plain.ProfileUpdate{DisplayName: &user.DisplayName, Bio: &user.Bio})
+}
+
+func validateProfileUpdate(update plain.ProfileUpdate) error {
+	if update.DisplayName != nil && utf8.RuneCountInString(*update.DisplayName) > MaxDisplayNameLength {
+		return fmt.Errorf("%w: display name is longer than %d characters", ErrInvalidProfile, MaxDisplayNameLength)
+	}
+	if update.Bio != nil && utf8.RuneCountInString(*update.Bio) > MaxBioLength {
+		return fmt.Errorf("%w: bio is longer than %d characters", ErrInvalidProfile, MaxBioLength)
+	}
+	return nil
+}
+
+// Register fails with storage.ErrCollision when the handle is taken
+func (um *UsersManager) Register(ctx context.Context, user schemas.User) (*schemas.User, error) {
+	err := ValidateProfile(user)
+	if err != nil {
+		return nil, err
+	}
+	user.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	err = um.profilesStorage.PutProfile(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Unregister removes the profile of a user whose registration could not be completed,
+// so the handle is free again
+func (um *UsersManager) Unregister(ctx context.Context, userId schemas.UserId) error {
+	return um.profilesStorage.DeleteProfile(ctx, userId)
 }
 
-func NewUsersManager(usersStorage storage.UsersStorage, feedStorage storage.FeedStorage, scheduler workers.Scheduler) *UsersManager {
-	return &UsersManager{usersStorage: usersStorage, feedStorage: feedStorage, scheduler: scheduler}
+func (um *UsersManager) GetProfile(ctx context.Context, userId schemas.UserId) (*schemas.User, error) {
+	return um.profilesStorage.GetProfile(ctx, userId)
 }
 
-func (um *UsersManager) MakeSubscription(ctx context.Context, subscriber schemas.UserId, to schemas.UserId) error {
+func (um *UsersManager) UpdateProfile(ctx context.Context, userId schemas.UserId, update plain.ProfileUpdate) (*schemas.User, error) {
+	err := validateProfileUpdate(update)
+	if err != nil {
+		return nil, err
+	}
+	return um.profilesStorage.UpdateProfile(ctx, userId, update)
+}
+
+// CheckUserExists fails with storage.ErrNotFound for users without a profile
+func (um *UsersManager) CheckUserExists(ctx context.Context, userId schemas.UserId) error {
+	_, err := um.profilesStorage.GetProfile(ctx, userId)
+	return err
+}
+
+// MakeSubscription fails with storage.ErrNotFound when the target is not registered
+// and with ErrBlocked when either of the users blocked the other. Subscriptions to
+// private accounts are requested instead, requested tells the target has to approve.
+func (um *UsersManager) MakeSubscription(ctx context.Context, subscriber schemas.UserId, to schemas.UserId) (requested bool, _ error) {
+	target, err := um.profilesStorage.GetProfile(ctx, to)
+	if err != nil {
+		return false, err
+	}
+	blocked, err := um.usersStorage.IsBlockedBetween(ctx, subscriber, to)
+	if err != nil {
+		return false, err
+	}
+	if blocked {
+		return false, ErrBlocked
+	}
+
+	if target.Private {
+		subscribed, err := um.usersStorage.IsSubscribed(ctx, subscriber, to)
+		if err != nil {
+			return false, err
+		}
+		if !subscribed {
+			return true, um.usersStorage.PutFollowRequest(ctx, subscriber, to)
+		}
+	}
+	return false, um.subscribe(ctx, subscriber, to)
+}
+
+func (um *UsersManager) subscribe(ctx context.Context, subscriber schemas.UserId, to schemas.UserId) error {
 	err := um.usersStorage.MakeSubscription(ctx, subscriber, to)
 	if err != nil {
 		return err
@@ -31,6 +134,64 @@ func (um *UsersManager) MakeSubscription(ctx context.Context, subscriber schemas
 	return nil
 }
 
+// RemoveSubscription cancels a pending follow request as well
+func (um *UsersManager) RemoveSubscription(ctx context.Context, subscriber schemas.UserId, from schemas.UserId) error {
+	err := um.usersStorage.RemoveFollowRequest(ctx, subscriber, from)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+
+	err = um.usersStorage.RemoveSubscription(ctx, subscriber, from)
+	if err != nil {
+		return err
+	}
+
+	err = um.scheduler.PublishRemovePostsFromPersonalFeed(subscriber, from)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ApproveFollowRequest fails with storage.ErrNotFound when the requester did not ask to subscribe
+func (um *UsersManager) ApproveFollowRequest(ctx context.Context, target schemas.UserId, requester schemas.UserId) error {
+	err := um.usersStorage.RemoveFollowRequest(ctx, requester, target)
+	if err != nil {
+		return err
+	}
+	return um.subscribe(ctx, requester, target)
+}
+
+func (um *UsersManager) RejectFollowRequest(ctx context.Context, target schemas.UserId, requester schemas.UserId) error {
+	return um.usersStorage.RemoveFollowRequest(ctx, requester, target)
+}
+
+func (um *UsersManager) GetFollowRequests(ctx context.Context, target schemas.UserId) ([]schemas.UserId, error) {
+	return um.usersStorage.GetFollowRequests(ctx, target)
+}
+
+// CanViewPosts tells whether the viewer sees posts of the author: authors are public,
+// unless their account is private and the viewer is not an approved subscriber
+func (um *UsersManager) CanViewPosts(ctx context.Context, viewer schemas.UserId, author schemas.UserId) (bool, error) {
+	if viewer == author {
+		return true, nil
+	}
+	profile, err := um.profilesStorage.GetProfile(ctx, author)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return true, nil
+		}
+		return false, err
+	}
+	if !profile.Private {
+		return true, nil
+	}
+	if viewer == "" {
+		return false, nil
+	}
+	return um.usersStorage.IsSubscribed(ctx, viewer, author)
+}
+
 func (um *UsersManager) GetUserSubscriptions(ctx context.Context, userId schemas.UserId) ([]schemas.UserId, error) {
 	return um.usersStorage.GetUserSubscriptions(ctx, userId)
 }
@@ -38,6 +199,46 @@ func (um *UsersManager) GetUserSubscriptions(ctx context.Context, userId schemas
 func (um *UsersManager) GetUserSubscribers(ctx context.Context, userId schemas.UserId) ([]schemas.UserId, error) {
 	return um.usersStorage.GetUserSubscribers(ctx, userId)
 }
-func (um *UsersManager) GetUserFeed(ctx context.Context, userId schemas.UserId, page plain.GetUserPostsPageData) ([]*schemas.Post, *plain.GetUserPostsPageData, error) {
-	return um.feedStorage.GetUserFeed(ctx, userId, page)
+
+// Block removes subscriptions between the users in both directions
+func (um *UsersManager) Block(ctx context.Context, blocker schemas.UserId, blocked schemas.UserId) error {
+	err := um.CheckUserExists(ctx, blocked)
+	if err != nil {
+		return err
+	}
+	err = um.usersStorage.PutBlock(ctx, blocker, blocked)
+	if err != nil {
+		return err
+	}
+
+	err = um.RemoveSubscription(ctx, blocker, blocked)
+	if err != nil {
+		return err
+	}
+	return um.RemoveSubscription(ctx, blocked, blocker)
+}
+
+func (um *UsersManager) Unblock(ctx context.Context, blocker schemas.UserId, blocked schemas.UserId) error {
+	return um.usersStorage.RemoveBlock(ctx, blocker, blocked)
+}
+
+func (um *UsersManager) GetBlockedUsers(ctx context.Context, userId schemas.UserId) ([]schemas.UserId, error) {
+	return um.usersStorage.GetBlockedUsers(ctx, userId)
+}
+
+// Mute hides posts of the muted user from the feed of the muter only
+func (um *UsersManager) Mute(ctx context.Context, muter schemas.UserId, muted schemas.UserId) error {
+	err := um.CheckUserExists(ctx, muted)
+	if err != nil {
+		return err
+	}
+	return um.usersStorage.PutMute(ctx, muter, muted)
+}
+
+func (um *UsersManager) Unmute(ctx context.Context, muter schemas.UserId, muted schemas.UserId) error {
+	return um.usersStorage.RemoveMute(ctx, muter, muted)
+}
+
+func (um *UsersManager) GetMutedUsers(ctx context.Context, userId schemas.UserId) ([]schemas.UserId, error) {
+	return um.usersStorage.GetMutedUsers(ctx, userId)
 }
@@ -0,0 +1,192 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"netwitter/plain"
+	"netwitter/schemas"
+	"netwitter/storage"
+	"netwitter/workers"
+	"testing"
+)
+
+// newTestManager registers a private author and a reader, subscription tasks are queued but never executed
+func newTestManager(t *testing.T) *UsersManager {
+	t.Helper()
+	ctx := context.Background()
+	um := NewUsersManager(NewInMemoryStorage(), NewInMemoryProfilesStorage(), workers.NewLocalScheduler())
+	for _, userId := range []schemas.UserId{"author", "reader"} {
+		_, err := um.Register(ctx, schemas.User{ID: userId})
+		if err != nil {
+			t.Fatalf("register %s: %v", userId, err)
+		}
+	}
+	private := true
+	_, err := um.UpdateProfile(ctx, "author", plain.ProfileUpdate{Private: &private})
+	if err != nil {
+		t.Fatalf("make author private: %v", err)
+	}
+	return um
+}
+
+func requestFollow(t *testing.T, um *UsersManager, requester schemas.UserId, target schemas.UserId) {
+	t.Helper()
+	requested, err := um.MakeSubscription(context.Background(), requester, target)
+	if err != nil {
+		t.Fatalf("subscribe %s to %s: %v", requester, target, err)
+	}
+	if !requested {
+		t.Fatalf("subscription of %s to private %s must be requested", requester, target)
+	}
+}
+
+func assertCanView(t *testing.T, um *UsersManager, viewer schemas.UserId, author schemas.UserId, expected bool) {
+	t.Helper()
+	canView, err := um.CanViewPosts(context.Background(), viewer, author)
+	if err != nil {
+		t.Fatalf("can view posts: %v", err)
+	}
+	if canView != expected {
+		t.Errorf("expected %q seeing posts of %q to be %t", viewer, author, expected)
+	}
+}
+
+func assertFollowRequests(t *testing.T, um *UsersManager, target schemas.UserId, expected ...schemas.UserId) {
+	t.Helper()
+	requests, err := um.GetFollowRequests(context.Background(), target)
+	if err != nil {
+		t.Fatalf("get follow requests: %v", err)
+	}
+	if len(requests) != len(expected) {
+		t.Fatalf("expected follow requests %v, got %v", expected, requests)
+	}
+	for i := range expected {
+		if requests[i] != expected[i] {
+			t.Errorf("expected follow requests %v, got %v", expected, requests)
+		}
+	}
+}
+
+func assertSubscribers(t *testing.T, um *UsersManager, userId schemas.UserId, expected ...schemas.UserId) {
+	t.Helper()
+	subscribers, err := um.GetUserSubscribers(context.Background(), userId)
+	if err != nil {
+		t.Fatalf("get subscribers: %v", err)
+	}
+	if len(subscribers) != len(expected) {
+		t.Fatalf("expected subscribers %v, got %v", expected, subscribers)
+	}
+	for i := range expected {
+		if subscribers[i] != expected[i] {
+			t.Errorf("expected subscribers %v, got %v", expected, subscribers)
+		}
+	}
+}
+
+func TestFollowRequestApprove(t *testing.T) {
+	um := newTestManager(t)
+	ctx := context.Background()
+
+	requestFollow(t, um, "reader", "author")
+	assertFollowRequests(t, um, "author", "reader")
+	assertSubscribers(t, um, "author")
+	assertCanView(t, um, "reader", "author", false)
+
+	err := um.ApproveFollowRequest(ctx, "author", "reader")
+	if err != nil {
+		t.Fatalf("approve: %v", err)
+	}
+	assertFollowRequests(t, um, "author")
+	assertSubscribers(t, um, "author", "reader")
+	assertCanView(t, um, "reader", "author", true)
+
+	err = um.ApproveFollowRequest(ctx, "author", "reader")
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected approved request to be gone, got %v", err)
+	}
+	requested, err := um.MakeSubscription(ctx, "reader", "author")
+	if err != nil {
+		t.Fatalf("subscribe again: %v", err)
+	}
+	if requested {
+		t.Error("approved subscriber must not be requested again")
+	}
+}
+
+func TestFollowRequestReject(t *testing.T) {
+	um := newTestManager(t)
+	ctx := context.Background()
+
+	requestFollow(t, um, "reader", "author")
+	err := um.RejectFollowRequest(ctx, "author", "reader")
+	if err != nil {
+		t.Fatalf("reject: %v", err)
+	}
+	assertFollowRequests(t, um, "author")
+	assertSubscribers(t, um, "author")
+	assertCanView(t, um, "reader", "author", false)
+
+	err = um.ApproveFollowRequest(ctx, "author", "reader")
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected rejected request not to be approved, got %v", err)
+	}
+	// the reader may ask again
+	requestFollow(t, um, "reader", "author")
+	assertFollowRequests(t, um, "author", "reader")
+}
+
+func TestBlockDuringFollowRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		blocker schemas.UserId
+		blocked schemas.UserId
+	}{
+		{"TargetBlocks", "author", "reader"},
+		{"RequesterBlocks", "reader", "author"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			um := newTestManager(t)
+			ctx := context.Background()
+
+			requestFollow(t, um, "reader", "author")
+			err := um.Block(ctx, tt.blocker, tt.blocked)
+			if err != nil {
+				t.Fatalf("block: %v", err)
+			}
+			assertFollowRequests(t, um, "author")
+
+			err = um.ApproveFollowRequest(ctx, "author", "reader")
+			if !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("expected the request to be dropped by the block, got %v", err)
+			}
+			assertSubscribers(t, um, "author")
+			assertCanView(t, um, "reader", "author", false)
+
+			_, err = um.MakeSubscription(ctx, "reader", "author")
+			if !errors.Is(err, ErrBlocked) {
+				t.Errorf("expected blocked request, got %v", err)
+			}
+			assertFollowRequests(t, um, "author")
+		})
+	}
+}
+
+func TestCanViewPostsOfUnknownAuthor(t *testing.T) {
+	um := newTestManager(t)
+
+	// posts of authors without a profile are not hidden, like those of public ones
+	assertCanView(t, um, "reader", "unknown", true)
+	assertCanView(t, um, "", "unknown", true)
+	assertCanView(t, um, "unknown", "unknown", true)
+
+	assertCanView(t, um, "", "author", false)
+	assertCanView(t, um, "author", "author", true)
+	assertCanView(t, um, "author", "reader", true)
+
+	_, err := um.MakeSubscription(context.Background(), "reader", "unknown")
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected subscription to an unknown author to fail, got %v", err)
+	}
+}
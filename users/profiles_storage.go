@@ -0,0 +1,100 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"netwitter/plain"
+	"netwitter/schemas"
+	"netwitter/storage"
+)
+
+type ProfilesStorage struct {
+	profilesCollection *mongo.Collection
+}
+
+func NewProfilesStorage(ctx context.Context, mongoUrl, dbName string) *ProfilesStorage {
+	mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoUrl))
+	if err != nil {
+		panic(fmt.Sprintf("connect to mongo failed: %s", err))
+	}
+
+	profilesCollection := mongoClient.Database(dbName).Collection("users")
+	return &ProfilesStorage{profilesCollection: profilesCollection}
+}
+
+func (s *ProfilesStorage) PutProfile(ctx context.Context, user schemas.User) error {
+	_, err := s.profilesCollection.InsertOne(ctx, user)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("%w: user %s", storage.ErrCollision, user.ID)
+		}
+		return fmt.Errorf("profile insertion failed: %s", err.Error())
+	}
+	return nil
+}
+
+func (s *ProfilesStorage) GetProfile(ctx context.Context, userId schemas.UserId) (*schemas.User, error) {
+	var user schemas.User
+	err := s.profilesCollection.FindOne(ctx, bson.M{"_id": string(userId)}).Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("%w: user %s", storage.ErrNotFound, userId)
+		}
+		return nil, fmt.Errorf("failed to extract, cause %s", err.Error())
+	}
+	return &user, nil
+}
+
+func (s *ProfilesStorage) UpdateProfile(ctx context.Context, userId schemas.UserId, update plain.ProfileUpdate) (*schemas.User, error) {
+	setFields := bson.M{}
+	unsetFields := bson.M{}
+	if update.DisplayName != nil {
+		setFields["displayName"] = *update.DisplayName
+	}
+	if update.Bio != nil {
+		setFields["bio"] = *update.Bio
+	}
+	if update.Private != nil {
+		setFields["private"] = *update.Private
+	}
+	if update.AvatarID != nil {
+		if *update.AvatarID == "" {
+			unsetFields["avatarId"] = ""
+		} else {
+			setFields["avatarId"] = string(*update.AvatarID)
+		}
+	}
+	if len(setFields) == 0 && len(unsetFields) == 0 {
+		return s.GetProfile(ctx, userId)
+	}
+
+	mongoUpdate := bson.M{}
+	if len(setFields) != 0 {
+		mongoUpdate["$set"] = setFields
+	}
+	if len(unsetFields) != 0 {
+		mongoUpdate["$unset"] = unsetFields
+	}
+	mongoOpts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var user schemas.User
+	err := s.profilesCollection.FindOneAndUpdate(ctx, bson.M{"_id": string(userId)}, mongoUpdate, mongoOpts).Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("%w: user %s", storage.ErrNotFound, userId)
+		}
+		return nil, fmt.Errorf("profile update failed: %s", err.Error())
+	}
+	return &user, nil
+}
+
+func (s *ProfilesStorage) DeleteProfile(ctx context.Context, userId schemas.UserId) error {
+	_, err := s.profilesCollection.DeleteOne(ctx, bson.M{"_id": string(userId)})
+	if err != nil {
+		return fmt.Errorf("profile removal failed: %s", err.Error())
+	}
+	return nil
+}
@@ -7,15 +7,39 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"netwitter/schemas"
+	"netwitter/storage"
 )
 
+const pulledAuthorsCollName = "pulled_authors"
+
 type SubscriptionInfo struct {
 	SubscriberID schemas.UserId `bson:"subscriberId"`
 	TargetUserID schemas.UserId `bson:"targetUserId"`
 }
 
+const (
+	relationBlock = "block"
+	relationMute  = "mute"
+	// relationFollowRequest waits for the target to approve the subscription
+	relationFollowRequest = "follow_request"
+)
+
+// RelationInfo is a block or a mute of the target user
+type RelationInfo struct {
+	UserID       schemas.UserId `bson:"userId"`
+	Kind         string         `bson:"kind"`
+	TargetUserID schemas.UserId `bson:"targetUserId"`
+}
+
+// pulledAuthorInfo marks an author whose posts are pulled on feed reads
+type pulledAuthorInfo struct {
+	AuthorID schemas.UserId `bson:"_id"`
+}
+
 type UsersStorage struct {
-	usersCollection *mongo.Collection
+	usersCollection         *mongo.Collection
+	relationsCollection     *mongo.Collection
+	pulledAuthorsCollection *mongo.Collection
 }
 
 func NewStorage(ctx context.Context, mongoUrl, dbName string) *UsersStorage {
@@ -30,7 +54,17 @@ func NewStorage(ctx context.Context, mongoUrl, dbName string) *UsersStorage {
 		panic(fmt.Sprintf("failed ensure index: %s", err))
 	}
 
-	return &UsersStorage{usersCollection: usersCollestion}
+	relationsCollection := mongoClient.Database(dbName).Collection("relations")
+	err = ensureRelationsIndexes(ctx, relationsCollection)
+	if err != nil {
+		panic(fmt.Sprintf("failed ensure index: %s", err))
+	}
+
+	return &UsersStorage{
+		usersCollection:         usersCollestion,
+		relationsCollection:     relationsCollection,
+		pulledAuthorsCollection: mongoClient.Database(dbName).Collection(pulledAuthorsCollName),
+	}
 }
 
 func ensureIndexes(ctx context.Context, collection *mongo.Collection) error {
@@ -50,6 +84,24 @@ func ensureIndexes(ctx context.Context, collection *mongo.Collection) error {
 	return nil
 }
 
+func ensureRelationsIndexes(ctx context.Context, collection *mongo.Collection) error {
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"userId", 1}, {"kind", 1}, {"targetUserId", 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{"targetUserId", 1}, {"kind", 1}},
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 func (s *UsersStorage) MakeSubscription(ctx context.Context, subscriber schemas.UserId, to schemas.UserId) error {
 	mongoQuery := bson.M{"subscriberId": string(subscriber), "targetUserId": string(to)}
 	mongoOpts := options.Replace().SetUpsert(true)
@@ -65,6 +117,16 @@ func (s *UsersStorage) MakeSubscription(ctx context.Context, subscriber schemas.
 	return nil
 }
 
+func (s *UsersStorage) RemoveSubscription(ctx context.Context, subscriber schemas.UserId, from schemas.UserId) error {
+	mongoQuery := bson.M{"subscriberId": string(subscriber), "targetUserId": string(from)}
+	_, err := s.usersCollection.DeleteOne(ctx, mongoQuery)
+	if err != nil {
+		return fmt.Errorf("subscription removal failed: %s", err.Error())
+	}
+
+	return nil
+}
+
 func (s *UsersStorage) GetUserSubscriptions(ctx context.Context, userId schemas.UserId) ([]schemas.UserId, error) {
 	mongoQuery := bson.M{"subscriberId": string(userId)}
 	cursor, err := s.usersCollection.Find(ctx, mongoQuery)
@@ -105,3 +167,183 @@ func (s *UsersStorage) GetUserSubscribers(ctx context.Context, userId schemas.Us
 	}
 	return userSubList, nil
 }
+
+func (s *UsersStorage) IsSubscribed(ctx context.Context, subscriber schemas.UserId, to schemas.UserId) (bool, error) {
+	count, err := s.usersCollection.CountDocuments(ctx, bson.M{"subscriberId": string(subscriber), "targetUserId": string(to)})
+	if err != nil {
+		return false, fmt.Errorf("mongo count failed: %s", err.Error())
+	}
+	return count > 0, nil
+}
+
+func (s *UsersStorage) CountUserSubscribers(ctx context.Context, userId schemas.UserId) (int, error) {
+	count, err := s.usersCollection.CountDocuments(ctx, bson.M{"targetUserId": string(userId)})
+	if err != nil {
+		return 0, fmt.Errorf("mongo count failed: %s", err.Error())
+	}
+	return int(count), nil
+}
+
+func (s *UsersStorage) MarkPulledAuthor(ctx context.Context, authorId schemas.UserId) error {
+	mongoOpts := options.Replace().SetUpsert(true)
+	_, err := s.pulledAuthorsCollection.ReplaceOne(ctx, bson.M{"_id": string(authorId)}, &pulledAuthorInfo{AuthorID: authorId}, mongoOpts)
+	if err != nil {
+		return fmt.Errorf("pulled author insertion failed: %s", err.Error())
+	}
+	return nil
+}
+
+func (s *UsersStorage) IsPulledAuthor(ctx context.Context, authorId schemas.UserId) (bool, error) {
+	count, err := s.pulledAuthorsCollection.CountDocuments(ctx, bson.M{"_id": string(authorId)})
+	if err != nil {
+		return false, fmt.Errorf("mongo count failed: %s", err.Error())
+	}
+	return count > 0, nil
+}
+
+// GetPulledSubscriptions joins subscriptions with the marks, so feed reads take one query
+func (s *UsersStorage) GetPulledSubscriptions(ctx context.Context, userId schemas.UserId) ([]schemas.UserId, error) {
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.M{"subscriberId": string(userId)}}},
+		{{"$lookup", bson.M{
+			"from":         pulledAuthorsCollName,
+			"localField":   "targetUserId",
+			"foreignField": "_id",
+			"as":           "pulled",
+		}}},
+		{{"$match", bson.M{"pulled": bson.M{"$ne": bson.A{}}}}},
+		{{"$sort", bson.M{"targetUserId": 1}}},
+	}
+	cursor, err := s.usersCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("mongo search failed: %s", err.Error())
+	}
+
+	var pulledSubscriptions []*SubscriptionInfo
+	err = cursor.All(ctx, &pulledSubscriptions)
+	if err != nil {
+		return nil, fmt.Errorf("mongo decode failed: %s", err.Error())
+	}
+
+	pulledAuthors := make([]schemas.UserId, 0, len(pulledSubscriptions))
+	for i := range pulledSubscriptions {
+		pulledAuthors = append(pulledAuthors, pulledSubscriptions[i].TargetUserID)
+	}
+	return pulledAuthors, nil
+}
+
+func (s *UsersStorage) putRelation(ctx context.Context, relation RelationInfo) error {
+	mongoQuery := bson.M{"userId": string(relation.UserID), "kind": relation.Kind, "targetUserId": string(relation.TargetUserID)}
+	mongoOpts := options.Replace().SetUpsert(true)
+	_, err := s.relationsCollection.ReplaceOne(ctx, mongoQuery, relation, mongoOpts)
+	if err != nil {
+		return fmt.Errorf("%s insertion failed: %s", relation.Kind, err.Error())
+	}
+	return nil
+}
+
+// removeRelation tells whether there was the relation to remove
+func (s *UsersStorage) removeRelation(ctx context.Context, relation RelationInfo) (bool, error) {
+	mongoQuery := bson.M{"userId": string(relation.UserID), "kind": relation.Kind, "targetUserId": string(relation.TargetUserID)}
+	result, err := s.relationsCollection.DeleteOne(ctx, mongoQuery)
+	if err != nil {
+		return false, fmt.Errorf("%s removal failed: %s", relation.Kind, err.Error())
+	}
+	return result.DeletedCount > 0, nil
+}
+
+func (s *UsersStorage) getRelationTargets(ctx context.Context, userId schemas.UserId, kind string) ([]schemas.UserId, error) {
+	mongoOpts := options.Find().SetSort(bson.D{{"targetUserId", 1}})
+	cursor, err := s.relationsCollection.Find(ctx, bson.M{"userId": string(userId), "kind": kind}, mongoOpts)
+	if err != nil {
+		return nil, fmt.Errorf("mongo search failed: %s", err.Error())
+	}
+
+	var relations []*RelationInfo
+	err = cursor.All(ctx, &relations)
+	if err != nil {
+		return nil, fmt.Errorf("putting %ss from mongo failed: %s", kind, err.Error())
+	}
+
+	targets := make([]schemas.UserId, 0, len(relations))
+	for i := range relations {
+		targets = append(targets, relations[i].TargetUserID)
+	}
+	return targets, nil
+}
+
+func (s *UsersStorage) PutBlock(ctx context.Context, blocker schemas.UserId, blocked schemas.UserId) error {
+	return s.putRelation(ctx, RelationInfo{UserID: blocker, Kind: relationBlock, TargetUserID: blocked})
+}
+
+func (s *UsersStorage) RemoveBlock(ctx context.Context, blocker schemas.UserId, blocked schemas.UserId) error {
+	_, err := s.removeRelation(ctx, RelationInfo{UserID: blocker, Kind: relationBlock, TargetUserID: blocked})
+	return err
+}
+
+func (s *UsersStorage) GetBlockedUsers(ctx context.Context, userId schemas.UserId) ([]schemas.UserId, error) {
+	return s.getRelationTargets(ctx, userId, relationBlock)
+}
+
+func (s *UsersStorage) IsBlockedBetween(ctx context.Context, first schemas.UserId, second schemas.UserId) (bool, error) {
+	mongoQuery := bson.M{
+		"kind": relationBlock,
+		"$or": bson.A{
+			bson.M{"userId": string(first), "targetUserId": string(second)},
+			bson.M{"userId": string(second), "targetUserId": string(first)},
+		},
+	}
+	count, err := s.relationsCollection.CountDocuments(ctx, mongoQuery)
+	if err != nil {
+		return false, fmt.Errorf("mongo count failed: %s", err.Error())
+	}
+	return count > 0, nil
+}
+
+func (s *UsersStorage) PutMute(ctx context.Context, muter schemas.UserId, muted schemas.UserId) error {
+	return s.putRelation(ctx, RelationInfo{UserID: muter, Kind: relationMute, TargetUserID: muted})
+}
+
+func (s *UsersStorage) RemoveMute(ctx context.Context, muter schemas.UserId, muted schemas.UserId) error {
+	_, err := s.removeRelation(ctx, RelationInfo{UserID: muter, Kind: relationMute, TargetUserID: muted})
+	return err
+}
+
+func (s *UsersStorage) GetMutedUsers(ctx context.Context, userId schemas.UserId) ([]schemas.UserId, error) {
+	return s.getRelationTargets(ctx, userId, relationMute)
+}
+
+func (s *UsersStorage) PutFollowRequest(ctx context.Context, requester schemas.UserId, target schemas.UserId) error {
+	return s.putRelation(ctx, RelationInfo{UserID: requester, Kind: relationFollowRequest, TargetUserID: target})
+}
+
+func (s *UsersStorage) RemoveFollowRequest(ctx context.Context, requester schemas.UserId, target schemas.UserId) error {
+	removed, err := s.removeRelation(ctx, RelationInfo{UserID: requester, Kind: relationFollowRequest, TargetUserID: target})
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return fmt.Errorf("%w: follow request of %s to %s", storage.ErrNotFound, requester, target)
+	}
+	return nil
+}
+
+func (s *UsersStorage) GetFollowRequests(ctx context.Context, target schemas.UserId) ([]schemas.UserId, error) {
+	mongoOpts := options.Find().SetSort(bson.D{{"userId", 1}})
+	cursor, err := s.relationsCollection.Find(ctx, bson.M{"targetUserId": string(target), "kind": relationFollowRequest}, mongoOpts)
+	if err != nil {
+		return nil, fmt.Errorf("mongo search failed: %s", err.Error())
+	}
+
+	var requests []*RelationInfo
+	err = cursor.All(ctx, &requests)
+	if err != nil {
+		return nil, fmt.Errorf("putting follow requests from mongo failed: %s", err.Error())
+	}
+
+	requesters := make([]schemas.UserId, 0, len(requests))
+	for i := range requests {
+		requesters = append(requesters, requests[i].UserID)
+	}
+	return requesters, nil
+}
@@ -28,6 +28,26 @@ func (pte *PostsTasksExecutor) ExecuteSpreadPostOverSubscribers(userId string, p
 	return nil
 }
 
+func (pte *PostsTasksExecutor) ExecuteSpreadRepostOverSubscribers(reposterId string, postId string) error {
+	ctx := context.Background()
+	reposterIdInSchemas := schemas.UserId(reposterId)
+	postIdInSchemas, err := schemas.IDFromText(postId)
+	if err != nil {
+		return err
+	}
+	return pte.feedManager.SpreadRepostOverSubscribers(ctx, reposterIdInSchemas, postIdInSchemas)
+}
+
+func (pte *PostsTasksExecutor) ExecuteRetractPostFromSubscribers(userId string, postId string) error {
+	ctx := context.Background()
+	userIdInSchemas := schemas.UserId(userId)
+	postIdInSchemas, err := schemas.IDFromText(postId)
+	if err != nil {
+		return err
+	}
+	return pte.feedManager.RetractPostFromSubscribers(ctx, userIdInSchemas, postIdInSchemas)
+}
+
 func (pte *PostsTasksExecutor) ExecuteCollectPostsToPersonalFeed(subscriber string, from string) error {
 	ctx := context.Background()
 	subscriberInSchemas := schemas.UserId(subscriber)
@@ -40,9 +60,24 @@ func (pte *PostsTasksExecutor) ExecuteCollectPostsToPersonalFeed(subscriber stri
 	return nil
 }
 
+func (pte *PostsTasksExecutor) ExecuteRemovePostsFromPersonalFeed(subscriber string, from string) error {
+	ctx := context.Background()
+	subscriberInSchemas := schemas.UserId(subscriber)
+	sourceInSchemas := schemas.UserId(from)
+
+	err := pte.feedManager.RemovePostsFromPersonalFeed(ctx, subscriberInSchemas, sourceInSchemas)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 func (pte *PostsTasksExecutor) GetCommandsMapping() map[string]interface{} {
 	return map[string]interface{}{
-		"SpreadPostOverSubscribers":  pte.ExecuteSpreadPostOverSubscribers,
-		"CollectPostsToPersonalFeed": pte.ExecuteCollectPostsToPersonalFeed,
+		"SpreadPostOverSubscribers":   pte.ExecuteSpreadPostOverSubscribers,
+		"CollectPostsToPersonalFeed":  pte.ExecuteCollectPostsToPersonalFeed,
+		"RetractPostFromSubscribers":  pte.ExecuteRetractPostFromSubscribers,
+		"SpreadRepostOverSubscribers": pte.ExecuteSpreadRepostOverSubscribers,
+		"RemovePostsFromPersonalFeed": pte.ExecuteRemovePostsFromPersonalFeed,
 	}
 }
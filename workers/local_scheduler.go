@@ -0,0 +1,75 @@
+package workers
+
+import (
+	"fmt"
+	"log"
+	"netwitter/schemas"
+)
+
+const localQueueSize = 1024
+
+// LocalScheduler runs tasks in the same process, one by one in publishing order.
+// It is meant for single binary runs without redis.
+type LocalScheduler struct {
+	executor *PostsTasksExecutor
+	queue    chan func(executor *PostsTasksExecutor) error
+}
+
+func NewLocalScheduler() *LocalScheduler {
+	return &LocalScheduler{
+		queue: make(chan func(executor *PostsTasksExecutor) error, localQueueSize),
+	}
+}
+
+func (sh *LocalScheduler) Listen() error {
+	if sh.executor == nil {
+		return fmt.Errorf("no executor registered")
+	}
+	for task := range sh.queue {
+		err := task(sh.executor)
+		if err != nil {
+			log.Println("Something went wrong: ", err)
+		}
+	}
+	return nil
+}
+
+func (sh *LocalScheduler) Register(executor PostsTasksExecutor) error {
+	sh.executor = &executor
+	return nil
+}
+
+func (sh *LocalScheduler) PublishSpreadPostOverSubs(userId schemas.UserId, postId schemas.PostId) error {
+	sh.queue <- func(executor *PostsTasksExecutor) error {
+		return executor.ExecuteSpreadPostOverSubscribers(string(userId), postId.Hex())
+	}
+	return nil
+}
+
+func (sh *LocalScheduler) PublishSpreadRepostOverSubs(reposterId schemas.UserId, postId schemas.PostId) error {
+	sh.queue <- func(executor *PostsTasksExecutor) error {
+		return executor.ExecuteSpreadRepostOverSubscribers(string(reposterId), postId.Hex())
+	}
+	return nil
+}
+
+func (sh *LocalScheduler) PublishRetractPostFromSubs(userId schemas.UserId, postId schemas.PostId) error {
+	sh.queue <- func(executor *PostsTasksExecutor) error {
+		return executor.ExecuteRetractPostFromSubscribers(string(userId), postId.Hex())
+	}
+	return nil
+}
+
+func (sh *LocalScheduler) PublishCollectPostsToPersonalFeed(userId schemas.UserId, from schemas.UserId) error {
+	sh.queue <- func(executor *PostsTasksExecutor) error {
+		return executor.ExecuteCollectPostsToPersonalFeed(string(userId), string(from))
+	}
+	return nil
+}
+
+func (sh *LocalScheduler) PublishRemovePostsFromPersonalFeed(userId schemas.UserId, from schemas.UserId) error {
+	sh.queue <- func(executor *PostsTasksExecutor) error {
+		return executor.ExecuteRemovePostsFromPersonalFeed(string(userId), string(from))
+	}
+	return nil
+}
@@ -11,11 +11,23 @@ import (
 	"time"
 )
 
-type Scheduler struct {
+// Scheduler publishes background tasks and runs them with the registered executor
+type Scheduler interface {
+	PublishSpreadPostOverSubs(userId schemas.UserId, postId schemas.PostId) error
+	PublishSpreadRepostOverSubs(reposterId schemas.UserId, postId schemas.PostId) error
+	PublishRetractPostFromSubs(userId schemas.UserId, postId schemas.PostId) error
+	PublishCollectPostsToPersonalFeed(userId schemas.UserId, from schemas.UserId) error
+	PublishRemovePostsFromPersonalFeed(userId schemas.UserId, from schemas.UserId) error
+	Register(executor PostsTasksExecutor) error
+	Listen() error
+}
+
+// MachineryScheduler sends tasks through redis broker to worker processes
+type MachineryScheduler struct {
 	server *machinery.Server
 }
 
-func NewScheduler(brokerUrl string) *Scheduler {
+func NewMachineryScheduler(brokerUrl string) *MachineryScheduler {
 	cfg := &config.Config{
 		DefaultQueue:    "tasks",
 		ResultsExpireIn: int(time.Hour.Seconds()),
@@ -37,13 +49,13 @@ func NewScheduler(brokerUrl string) *Scheduler {
 		panic(err)
 	}
 
-	scheduler := &Scheduler{
+	scheduler := &MachineryScheduler{
 		server: server,
 	}
 	return scheduler
 }
 
-func (sh *Scheduler) Listen() error {
+func (sh *MachineryScheduler) Listen() error {
 	worker := sh.server.NewWorker("worker", 0)
 	errorHandler := func(err error) {
 		log.ERROR.Println("Something went wrong: ", err)
@@ -53,7 +65,7 @@ func (sh *Scheduler) Listen() error {
 	return worker.Launch()
 }
 
-func (sh *Scheduler) PublishSpreadPostOverSubs(userId schemas.UserId, postId schemas.PostId) error {
+func (sh *MachineryScheduler) PublishSpreadPostOverSubs(userId schemas.UserId, postId schemas.PostId) error {
 	task := &tasks.Signature{
 		Name: "SpreadPostOverSubscribers",
 		Args: []tasks.Arg{
@@ -71,7 +83,43 @@ func (sh *Scheduler) PublishSpreadPostOverSubs(userId schemas.UserId, postId sch
 	return err
 }
 
-func (sh *Scheduler) PublishCollectPostsToPersonalFeed(userId schemas.UserId, from schemas.UserId) error {
+func (sh *MachineryScheduler) PublishSpreadRepostOverSubs(reposterId schemas.UserId, postId schemas.PostId) error {
+	task := &tasks.Signature{
+		Name: "SpreadRepostOverSubscribers",
+		Args: []tasks.Arg{
+			{
+				Type:  "string",
+				Value: string(reposterId),
+			},
+			{
+				Type:  "string",
+				Value: primitive.ObjectID(postId).Hex(),
+			},
+		},
+	}
+	_, err := sh.server.SendTask(task)
+	return err
+}
+
+func (sh *MachineryScheduler) PublishRetractPostFromSubs(userId schemas.UserId, postId schemas.PostId) error {
+	task := &tasks.Signature{
+		Name: "RetractPostFromSubscribers",
+		Args: []tasks.Arg{
+			{
+				Type:  "string",
+				Value: string(userId),
+			},
+			{
+				Type:  "string",
+				Value: primitive.ObjectID(postId).Hex(),
+			},
+		},
+	}
+	_, err := sh.server.SendTask(task)
+	return err
+}
+
+func (sh *MachineryScheduler) PublishCollectPostsToPersonalFeed(userId schemas.UserId, from schemas.UserId) error {
 	task := &tasks.Signature{
 		Name: "CollectPostsToPersonalFeed",
 		Args: []tasks.Arg{
@@ -89,6 +137,24 @@ func (sh *Scheduler) PublishCollectPostsToPersonalFeed(userId schemas.UserId, fr
 	return err
 }
 
-func (sh *Scheduler) Register(executor PostsTasksExecutor) error {
+func (sh *MachineryScheduler) PublishRemovePostsFromPersonalFeed(userId schemas.UserId, from schemas.UserId) error {
+	task := &tasks.Signature{
+		Name: "RemovePostsFromPersonalFeed",
+		Args: []tasks.Arg{
+			{
+				Type:  "string",
+				Value: string(userId),
+			},
+			{
+				Type:  "string",
+				Value: string(from),
+			},
+		},
+	}
+	_, err := sh.server.SendTask(task)
+	return err
+}
+
+func (sh *MachineryScheduler) Register(executor PostsTasksExecutor) error {
 	return sh.server.RegisterTasks(executor.GetCommandsMapping())
 }